@@ -0,0 +1,147 @@
+package beacon
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Apple's Bluetooth SIG company identifier, used by iBeacon manufacturer data
+const AppleCompanyID = 0x004C
+
+// Service UUID under which Eddystone frames are sent as service data
+const EddystoneServiceUUID = "0000feaa-0000-1000-8000-00805f9b34fb"
+
+// iBeacon manufacturer data: type 0x02, length 0x15, then 21 bytes of payload
+const (
+    iBeaconType   = 0x02
+    iBeaconLength = 0x15
+)
+
+// Eddystone frame types
+const (
+    eddystoneUID = 0x00
+    eddystoneTLM = 0x20
+)
+
+// IBeacon is a decoded Apple iBeacon advertisement
+type IBeacon struct {
+    UUID          string // Proximity UUID
+    Major         uint16
+    Minor         uint16
+    MeasuredPower int8   // RSSI at 1 m, in dBm
+}
+
+// ID returns the identity used to match the beacon against registered devices
+func (b IBeacon) ID() string {
+    return fmt.Sprintf("ibeacon:%s:%d:%d", b.UUID, b.Major, b.Minor)
+}
+
+// EddystoneUID is a decoded Eddystone-UID frame
+type EddystoneUID struct {
+    Namespace string // 10-byte namespace, hex encoded
+    Instance  string // 6-byte instance, hex encoded
+    TxPower   int8   // Calibrated TX power at 0 m, in dBm
+}
+
+// ID returns the identity used to match the beacon against registered devices
+func (b EddystoneUID) ID() string {
+    return fmt.Sprintf("eddystone:%s:%s", b.Namespace, b.Instance)
+}
+
+// EddystoneTLM is a decoded (unencrypted) Eddystone-TLM telemetry frame
+type EddystoneTLM struct {
+    BatteryMillivolts uint16        // 0 if not supported
+    Temperature       float64       // Degrees Celsius; -128 if not supported
+    AdvertisingCount  uint32        // Frames sent since power-up
+    Uptime            time.Duration // Time since power-up, 0.1 s resolution
+}
+
+// ParseIBeacon decodes a manufacturer data element, reporting false if it is not an iBeacon
+func ParseIBeacon(companyID uint16, data []byte) (IBeacon, bool) {
+    if companyID != AppleCompanyID || len(data) != 23 || data[0] != iBeaconType || data[1] != iBeaconLength {
+        return IBeacon{}, false
+    }
+    return IBeacon{
+        UUID:          FormatUUID(data[2:18]),
+        Major:         binary.BigEndian.Uint16(data[18:20]),
+        Minor:         binary.BigEndian.Uint16(data[20:22]),
+        MeasuredPower: int8(data[22]),
+    }, true
+}
+
+// ParseEddystoneUID decodes Eddystone service data, reporting false if it is not a UID frame
+func ParseEddystoneUID(serviceUUID string, data []byte) (EddystoneUID, bool) {
+    // The two reserved trailing bytes are optional in practice
+    if !isEddystone(serviceUUID) || len(data) < 18 || data[0] != eddystoneUID {
+        return EddystoneUID{}, false
+    }
+    return EddystoneUID{
+        TxPower:   int8(data[1]),
+        Namespace: hex.EncodeToString(data[2:12]),
+        Instance:  hex.EncodeToString(data[12:18]),
+    }, true
+}
+
+// ParseEddystoneTLM decodes Eddystone service data, reporting false if it is not an unencrypted TLM frame
+func ParseEddystoneTLM(serviceUUID string, data []byte) (EddystoneTLM, bool) {
+    // Version 0x00 is the unencrypted telemetry layout
+    if !isEddystone(serviceUUID) || len(data) != 14 || data[0] != eddystoneTLM || data[1] != 0x00 {
+        return EddystoneTLM{}, false
+    }
+    return EddystoneTLM{
+        BatteryMillivolts: binary.BigEndian.Uint16(data[2:4]),
+        Temperature:       float64(int16(binary.BigEndian.Uint16(data[4:6]))) / 256, // Signed 8.8 fixed point
+        AdvertisingCount:  binary.BigEndian.Uint32(data[6:10]),
+        Uptime:            time.Duration(binary.BigEndian.Uint32(data[10:14])) * 100 * time.Millisecond,
+    }, true
+}
+
+// NormalizeID returns a beacon ID in the form ID() produces, so a registered
+// ID matches the beacon however it was written: any case, proximity UUID with or
+// without dashes, and major/minor with leading zeros
+func NormalizeID(id string) (string, error) {
+    parts := strings.Split(strings.ToLower(strings.TrimSpace(id)), ":")
+    switch {
+    case len(parts) == 4 && parts[0] == "ibeacon":
+        uuid, err := hex.DecodeString(strings.ReplaceAll(parts[1], "-", ""))
+        if err != nil || len(uuid) != 16 {
+            return "", fmt.Errorf("%q: proximity UUID must be 32 hex digits", id)
+        }
+        major, err := strconv.ParseUint(parts[2], 10, 16)
+        if err != nil {
+            return "", fmt.Errorf("%q: major must be a number from 0 to 65535", id)
+        }
+        minor, err := strconv.ParseUint(parts[3], 10, 16)
+        if err != nil {
+            return "", fmt.Errorf("%q: minor must be a number from 0 to 65535", id)
+        }
+        return IBeacon{UUID: FormatUUID(uuid), Major: uint16(major), Minor: uint16(minor)}.ID(), nil
+    case len(parts) == 3 && parts[0] == "eddystone":
+        namespace, err := hex.DecodeString(parts[1])
+        if err != nil || len(namespace) != 10 {
+            return "", fmt.Errorf("%q: namespace must be 20 hex digits", id)
+        }
+        instance, err := hex.DecodeString(parts[2])
+        if err != nil || len(instance) != 6 {
+            return "", fmt.Errorf("%q: instance must be 12 hex digits", id)
+        }
+        return EddystoneUID{Namespace: hex.EncodeToString(namespace), Instance: hex.EncodeToString(instance)}.ID(), nil
+    }
+    return "", fmt.Errorf("%q is not ibeacon:<uuid>:<major>:<minor> or eddystone:<namespace>:<instance>", id)
+}
+
+// FormatUUID formats 16 bytes as a lowercase 8-4-4-4-12 UUID string
+func FormatUUID(b []byte) string {
+    s := hex.EncodeToString(b)
+    return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+// Eddystone service data may be reported with the 16-bit or the full 128-bit UUID
+func isEddystone(serviceUUID string) bool {
+    uuid := strings.ToLower(serviceUUID)
+    return uuid == EddystoneServiceUUID || uuid == "feaa"
+}
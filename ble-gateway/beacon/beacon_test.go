@@ -0,0 +1,66 @@
+package beacon
+
+import (
+    "encoding/hex"
+    "testing"
+)
+
+func TestNormalizeID(t *testing.T) {
+    tests := []struct {
+        id   string
+        want string // "" if the ID is rejected
+    }{
+        {"ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:2", "ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:2"},
+        {"IBEACON:FDA50693-A4E2-4FB1-AFCF-C6EB07647825:1:2", "ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:2"},
+        {"ibeacon:fda50693a4e24fb1afcfc6eb07647825:00001:0002", "ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:2"},
+        {" ibeacon:fda50693a4e24fb1afcfc6eb07647825:65535:0 ", "ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:65535:0"},
+        {"eddystone:EDD1EBEAC04E5DEFA017:0000000000AB", "eddystone:edd1ebeac04e5defa017:0000000000ab"},
+        {"ibeacon:fda50693a4e24fb1afcfc6eb076478:1:2", ""},   // Short UUID
+        {"ibeacon:fda50693a4e24fb1afcfc6eb07647825:65536:2", ""}, // Major out of range
+        {"ibeacon:fda50693a4e24fb1afcfc6eb07647825:-1:2", ""},
+        {"ibeacon:fda50693a4e24fb1afcfc6eb07647825:1", ""},
+        {"eddystone:edd1ebeac04e5defa0:0000000000ab", ""}, // Short namespace
+        {"eddystone:edd1ebeac04e5defa017:0000000000zz", ""},
+        {"altbeacon:fda50693a4e24fb1afcfc6eb07647825:1:2", ""},
+        {"", ""},
+    }
+    for _, tt := range tests {
+        got, err := NormalizeID(tt.id)
+        if tt.want == "" {
+            if err == nil {
+                t.Errorf("NormalizeID(%q) = %q, want an error", tt.id, got)
+            }
+            continue
+        }
+        if err != nil || got != tt.want {
+            t.Errorf("NormalizeID(%q) = %q, %v; want %q", tt.id, got, err, tt.want)
+        }
+    }
+}
+
+// A normalized ID must equal the ID the scanner derives from the advertisement
+func TestNormalizeIDMatchesParsedBeacons(t *testing.T) {
+    ibeaconData, _ := hex.DecodeString("0215fda50693a4e24fb1afcfc6eb0764782500010002c5")
+    ibeacon, ok := ParseIBeacon(AppleCompanyID, ibeaconData)
+    if !ok {
+        t.Fatal("iBeacon not parsed")
+    }
+    eddystoneData, _ := hex.DecodeString("00e7edd1ebeac04e5defa0170000000000ab0000")
+    eddystone, ok := ParseEddystoneUID("feaa", eddystoneData)
+    if !ok {
+        t.Fatal("Eddystone-UID not parsed")
+    }
+
+    tests := []struct {
+        registered string
+        seen       string
+    }{
+        {"IBEACON:FDA50693A4E24FB1AFCFC6EB07647825:01:02", ibeacon.ID()},
+        {"Eddystone:EDD1EBEAC04E5DEFA017:0000000000AB", eddystone.ID()},
+    }
+    for _, tt := range tests {
+        if got, err := NormalizeID(tt.registered); err != nil || got != tt.seen {
+            t.Errorf("NormalizeID(%q) = %q, %v; want %q", tt.registered, got, err, tt.seen)
+        }
+    }
+}
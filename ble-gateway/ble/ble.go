@@ -1,109 +1,110 @@
 package ble
 
 import (
-    "database/sql"
+    "context"
     "fmt"
     "log"
-    "sync"
     "time"
-    "tinygo.org/x/bluetooth"
     "ble-gateway/handler"
-    _ "github.com/mattn/go-sqlite3" // SQLite3 driver
-    pb "ble-gateway/proto"
+    "ble-gateway/presence"
+    "ble-gateway/store"
 )
 
-// Initialize BLE adapter
-var adapter = bluetooth.DefaultAdapter
-
-var connectedDevices = make(map[string]string) // MAC address -> UUID mapping
-var lastSeen = make(map[string]time.Time)      // MAC address -> last detected time
-var mu sync.Mutex
-
-const RSSIThreshold = -90
-const timeoutDuration = 30 * time.Second
-const scanInterval = 3 * time.Second
-
-// Open SQLite database
-func openDB() (*sql.DB, error) {
-    db, err := sql.Open("sqlite3", "./ble.db")
-    if err != nil {
-        return nil, fmt.Errorf("failed to open database: %v", err)
-    }
-    return db, nil
-}
-
-// Check the is_active value for a specific UUID
-func isDeviceActive(db *sql.DB, uuid string) (bool, error) {
-    var isActive int
-    query := `SELECT is_active FROM devices WHERE uuid = ?`
-    err := db.QueryRow(query, uuid).Scan(&isActive)
-    if err != nil {
-        if err == sql.ErrNoRows {
-            return false, nil // If UUID is not in the database, do not connect
+// Generic Attribute service, exposed by every GATT server and never a user UUID
+const genericAttributeUUID = "00001801-0000-1000-8000-00805f9b34fb"
+
+// SweepTimeouts logs out devices not seen within the timeout on every tick,
+// until ticks is closed. Only sweeps that changed a device are traced, which
+// is all Replay needs. Tests can drive it with their own channel and a
+// presence.ManualClock.
+func SweepTimeouts(monitor *Monitor, ticks <-chan time.Time) {
+    for range ticks {
+        if monitor.CheckTimeouts() {
+            recordTrace(TraceRecord{Time: monitor.clock.Now(), Event: TraceSweep})
         }
-        return false, fmt.Errorf("failed to check device active status: %v", err)
     }
-    return isActive == 1, nil // Return true if is_active is 1, otherwise false
 }
 
-// Restart BLE scan and refresh device states
-func RestartScan(client pb.DeviceServiceClient) {
-    db, err := openDB()
-    if err != nil {
-        log.Fatalf("Database connection failed: %v", err)
-    }
-    defer db.Close()
+// StatusReporter delivers a login (status 1) or logout (status 0) for a UUID
+type StatusReporter func(report handler.DeviceReport)
+
+// Restart BLE scan and refresh device states until ctx is done; the monitor
+// must have been started. Each scan is stopped after cfg.ScanWindow, while
+// timeouts are swept on their own ticker, since a running scan may not return
+// for as long as devices advertise. The settings are read from the monitor at
+// the start of every scan window. RestartScan returns once the scan and the
+// sweeps have stopped, so the monitor sees no more samples.
+func RestartScan(ctx context.Context, devices store.DeviceStore, radio Radio, monitor *Monitor) {
+    cfg, clock := monitor.Config(), monitor.clock
+
+    sweepInterval := cfg.SweepInterval
+    sweep := time.NewTicker(sweepInterval)
+    defer sweep.Stop()
+    ticks := make(chan time.Time)
+    swept := make(chan struct{})
+    go func() {
+        defer close(ticks)
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case t := <-sweep.C:
+                ticks <- t
+            }
+        }
+    }()
+    go func() {
+        SweepTimeouts(monitor, ticks)
+        close(swept)
+    }()
+    defer func() { <-swept }()
 
     for {
-        time.Sleep(scanInterval)
-
-        fmt.Println("Restarting BLE scan to refresh device states...")
-        checkTimeouts(client)
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(cfg.ScanInterval):
+        }
 
-        err := adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
-            if result.LocalName() == "" {
-                return
-            }
+        cfg = monitor.Config()
+        if cfg.SweepInterval != sweepInterval {
+            sweepInterval = cfg.SweepInterval
+            sweep.Reset(sweepInterval)
+        }
 
-            macAddress := result.Address.String()
-            lastSeen[macAddress] = time.Now()
+        stats := monitor.Metrics()
+        fmt.Printf("Restarting BLE scan to refresh device states... (present: %d, logins: %d, logouts: %d)\n", stats.Present, stats.Logins, stats.Logouts)
+        recordTrace(TraceRecord{Time: clock.Now(), Event: TraceScanStart})
 
-            device, err := adapter.Connect(result.Address, bluetooth.ConnectionParams{})
-            if err != nil {
-                handleDisconnect(macAddress, client)
+        window := time.AfterFunc(cfg.ScanWindow, func() {
+            radio.StopScan() // Returns an error if the scan has already returned
+        })
+        stopOnCancel := context.AfterFunc(ctx, func() {
+            radio.StopScan()
+        })
+        err := radio.Scan(func(result Advertisement) {
+            // Beacons are identified from their frames in either mode
+            uuids := beaconUUIDs(devices, result)
+            if cfg.Mode == ModePassive {
+                uuids = append(uuids, advertisedUUIDs(cfg, result)...)
+            }
+            if len(uuids) > 0 {
+                // Presence is decided from the advertisement itself, no connection is made
+                recordTrace(newAdvertisementRecord(clock.Now(), result, uuids, nil))
+                handleScanResult(devices, cfg, monitor, result, uuids, nil)
                 return
             }
-            defer device.Disconnect()
 
-            services, err := device.DiscoverServices(nil)
-            if err != nil {
-                handleDisconnect(macAddress, client)
+            if cfg.Mode == ModePassive || result.LocalName == "" {
                 return
             }
 
-            for _, service := range services {
-                uuid := service.UUID().String()
-                if uuid != "00001801-0000-1000-8000-00805f9b34fb" {
-                    if result.RSSI > RSSIThreshold {
-                        isActive, err := isDeviceActive(db, uuid)
-                        if err != nil {
-                            log.Printf("Error checking device active status: %v", err)
-                            continue
-                        }
-
-                        if isActive {
-                            handleConnect(macAddress, uuid, client)
-                            fmt.Printf("Device Address: %s, UUID: %s, RSSI: %d\n", macAddress, uuid, result.RSSI)
-                        } else {
-                            fmt.Printf("Device UUID %s is not active. Skipping connection.\n", uuid)
-                            handleDisconnect(macAddress, client)
-                        }
-                    } else {
-                        handleDisconnect(macAddress, client)
-                    }
-                }
-            }
+            services, connectErr := discoverServices(radio, result.Address)
+            recordTrace(newAdvertisementRecord(clock.Now(), result, services, connectErr))
+            handleScanResult(devices, cfg, monitor, result, services, connectErr)
         })
+        window.Stop()
+        stopOnCancel()
 
         if err != nil {
             log.Printf("Error restarting BLE scan: %v", err)
@@ -111,44 +112,120 @@ func RestartScan(client pb.DeviceServiceClient) {
     }
 }
 
-// BLE device connection/disconnection handler
-func handleDisconnect(macAddress string, client pb.DeviceServiceClient) {
-    mu.Lock()
-    defer mu.Unlock()
+// Reconfigure applies new settings to the monitor and ends the current scan
+// window, so the scan loop continues with them after cfg.ScanInterval
+func Reconfigure(radio Radio, monitor *Monitor, cfg Config) error {
+    if err := monitor.Reconfigure(cfg); err != nil {
+        return err
+    }
+    radio.StopScan() // Returns an error between two scan windows
+    return nil
+}
+
+// Connect to a scanned device and list its GATT services
+func discoverServices(radio Radio, macAddress string) ([]string, error) {
+    device, err := radio.Connect(macAddress)
+    if err != nil {
+        return nil, err
+    }
+    defer device.Disconnect()
+
+    return device.DiscoverServices()
+}
+
+// NewStatusMonitor creates a presence monitor that logs every transition
+// and reports logins and logouts
+func NewStatusMonitor(cfg Config, clock presence.Clock, report StatusReporter) *Monitor {
+    monitor := NewMonitor(cfg, clock)
+    monitor.Subscribe(presence.LogEvent)
+    monitor.Subscribe(func(e presence.Event) {
+        if e.IsLogin() {
+            report(newDeviceReport(e, 1))
+        } else if e.IsLogout() {
+            report(newDeviceReport(e, 0))
+        }
+    })
+    return monitor
+}
+
+func newDeviceReport(e presence.Event, status int32) handler.DeviceReport {
+    return handler.DeviceReport{
+        UUID:       e.UUID,
+        Status:     status,
+        Reason:     e.Reason,
+        ObservedAt: e.Time,
+        RSSI:       e.RSSI,
+        Distance:   e.Distance,
+    }
+}
 
-    if uuid, exists := connectedDevices[macAddress]; exists {
-        fmt.Printf("Device %s disconnected.\n", uuid)
-        delete(connectedDevices, macAddress)
-        delete(lastSeen, macAddress)
-        handler.SendDeviceStatus(client, uuid, 0)
+// SnapshotReports turns logged in devices into login reports for a presence snapshot
+func SnapshotReports(snapshot []presence.Snapshot) []handler.DeviceReport {
+    reports := make([]handler.DeviceReport, 0, len(snapshot))
+    for _, device := range snapshot {
+        reports = append(reports, handler.DeviceReport{
+            UUID:       device.UUID,
+            Status:     1,
+            ObservedAt: device.LastSeen,
+            RSSI:       device.RSSI,
+            Distance:   device.Distance,
+        })
     }
+    return reports
 }
 
-func handleConnect(macAddress string, uuid string, client pb.DeviceServiceClient) {
-    mu.Lock()
-    defer mu.Unlock()
-
-    if _, exists := connectedDevices[macAddress]; !exists {
-        fmt.Printf("Device %s connected.\n", uuid)
-        connectedDevices[macAddress] = uuid
-        lastSeen[macAddress] = time.Now()
-        handler.SendDeviceStatus(client, uuid, 1)
-    } else {
-        lastSeen[macAddress] = time.Now()
+// RefreshWhitelist re-checks the UUIDs of logged in devices against the
+// registry and logs out devices whose UUID is no longer active
+func RefreshWhitelist(devices store.DeviceStore, monitor *Monitor) error {
+    for macAddress, uuid := range monitor.Present() {
+        isActive, err := devices.IsActive(uuid)
+        if err != nil {
+            return err
+        }
+        if !isActive {
+            fmt.Printf("Device UUID %s is no longer active. Logging out.\n", uuid)
+            monitor.Lose(macAddress, presence.ReasonInactive)
+        }
     }
+    return nil
 }
 
-// Check timeouts for devices and handle disconnection if not detected within timeoutDuration
-func checkTimeouts(client pb.DeviceServiceClient) {
-    mu.Lock()
-    defer mu.Unlock()
+// Update device states from one scan result and the services discovered on it
+func handleScanResult(devices store.DeviceStore, cfg Config, monitor *Monitor, result Advertisement, services []string, connectErr error) {
+    macAddress := result.Address
+
+    if connectErr != nil {
+        monitor.Lose(macAddress, presence.ReasonConnectFailed)
+        return
+    }
+
+    // Calibration is only read from the store when logging in by distance
+    calibration := cfg.DefaultCalibration
+    if cfg.EnterDistance > 0 {
+        calibration = calibrationFor(devices, cfg, services)
+    }
 
-    currentTime := time.Now()
+    // A device is tracked under the first active UUID it exposes
+    inactive := false
+    for _, uuid := range services {
+        if cfg.ignored(uuid) {
+            continue
+        }
+        isActive, err := devices.IsActive(uuid)
+        if err != nil {
+            log.Printf("Error checking device active status: %v", err)
+            continue
+        }
 
-    for macAddress, lastSeenTime := range lastSeen {
-        if currentTime.Sub(lastSeenTime) > timeoutDuration {
-            fmt.Printf("Device %s timed out (no signal for %v).\n", connectedDevices[macAddress], timeoutDuration)
-            handleDisconnect(macAddress, client)
+        if isActive {
+            monitor.Observe(Sample{Address: macAddress, UUID: uuid, RSSI: result.RSSI, Calibration: calibration})
+            return
         }
+        fmt.Printf("Device UUID %s is not active. Skipping connection.\n", uuid)
+        inactive = true
+    }
+
+    if inactive {
+        monitor.Lose(macAddress, presence.ReasonInactive)
     }
 }
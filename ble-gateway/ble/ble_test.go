@@ -0,0 +1,173 @@
+package ble
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+    "ble-gateway/presence"
+)
+
+// Feed n ticks to SweepTimeouts, advancing clock by step before each, and wait for them
+func sweep(monitor *Monitor, clock *presence.ManualClock, n int, step time.Duration) {
+    for i := 0; i < n; i++ {
+        clock.Advance(step)
+        ticks := make(chan time.Time, 1)
+        ticks <- clock.Now()
+        close(ticks)
+        SweepTimeouts(monitor, ticks)
+    }
+}
+
+// Count the records of one event type in a trace file
+func countTrace(t *testing.T, path string, event string) int {
+    t.Helper()
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    count := 0
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        var record TraceRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            t.Fatal(err)
+        }
+        if record.Event == event {
+            count++
+        }
+    }
+    return count
+}
+
+func TestSweepLogsOutDisappearedDevice(t *testing.T) {
+    tracePath := filepath.Join(t.TempDir(), "trace.jsonl")
+    if err := StartTrace(tracePath); err != nil {
+        t.Fatal(err)
+    }
+    defer StopTrace()
+
+    devices, adverts := testRegistry(1)
+    cfg := DefaultConfig()
+    clock := presence.NewManualClock(time.Unix(1700000000, 0))
+    monitor := NewMonitor(cfg, clock)
+    var mu sync.Mutex
+    var events []presence.Event
+    monitor.Subscribe(func(e presence.Event) {
+        mu.Lock()
+        events = append(events, e)
+        mu.Unlock()
+    })
+    monitor.Start()
+    defer monitor.Stop()
+
+    // Seen every second until logged in, with sweeps in between
+    for i := 0; i < cfg.DwellCount+1; i++ {
+        handleScanResult(devices, cfg, monitor, adverts[0], adverts[0].ServiceUUIDs, nil)
+        sweep(monitor, clock, 1, time.Second)
+    }
+    monitor.Sync()
+    if got := monitor.Present(); len(got) != 1 {
+        t.Fatalf("present after %d sightings: %v", cfg.DwellCount+1, got)
+    }
+    if n := countTrace(t, tracePath, TraceSweep); n != 0 {
+        t.Errorf("%d sweeps traced while nothing timed out", n)
+    }
+
+    // Then it disappears: logged out once the timeout has passed, not before
+    lastSeen := clock.Now().Add(-time.Second)
+    sweep(monitor, clock, int(cfg.Timeout/time.Second)-2, time.Second)
+    monitor.Sync()
+    if got := monitor.Present(); len(got) != 1 {
+        t.Fatalf("logged out before the timeout: %v", got)
+    }
+    sweep(monitor, clock, 3, time.Second)
+    monitor.Sync()
+    if got := monitor.Present(); len(got) != 0 {
+        t.Fatalf("still present %v after the timeout", clock.Now().Sub(lastSeen))
+    }
+
+    mu.Lock()
+    if len(events) == 0 || !events[len(events)-1].IsLogout() || events[len(events)-1].Reason != presence.ReasonTimeout {
+        t.Errorf("last event is not a timeout logout: %+v", events)
+    }
+    logins := 0
+    for _, e := range events {
+        if e.IsLogin() {
+            logins++
+        }
+    }
+    mu.Unlock()
+    if logins != 1 {
+        t.Errorf("%d logins, want 1", logins)
+    }
+
+    // Only the sweeps that changed something are traced
+    traced := countTrace(t, tracePath, TraceSweep)
+    if traced == 0 {
+        t.Error("timeout sweep not traced")
+    }
+    sweep(monitor, clock, 60, time.Second)
+    if n := countTrace(t, tracePath, TraceSweep); n != traced {
+        t.Errorf("%d idle sweeps traced", n-traced)
+    }
+}
+
+func TestRestartScanWithSimRadio(t *testing.T) {
+    devices, adverts := testRegistry(1)
+    stranger := Advertisement{Address: "AA:BB:CC:DD:EE:FF", RSSI: -60, ServiceUUIDs: []string{"00000000-0000-4000-8000-999999999999"}}
+
+    // Short real-time windows, since both the radio and the scan loop run on the wall clock
+    cfg := DefaultConfig()
+    cfg.Mode = ModePassive
+    cfg.ScanWindow = 50 * time.Millisecond
+    cfg.ScanInterval = 10 * time.Millisecond
+    cfg.SweepInterval = 20 * time.Millisecond
+    cfg.Timeout = 200 * time.Millisecond
+    reports := &reportLog{}
+    monitor := NewStatusMonitor(cfg, presence.SystemClock, reports.report)
+    monitor.Start()
+    defer monitor.Stop()
+
+    // The registered device advertises for a while and then goes quiet
+    var script []SimEvent
+    for i := 0; i < 5; i++ {
+        at := time.Duration(i) * 20 * time.Millisecond
+        script = append(script, SimEvent{At: at, Advertisement: adverts[0]}, SimEvent{At: at, Advertisement: stranger})
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        RestartScan(ctx, devices, NewSimRadio(script), monitor)
+        close(done)
+    }()
+
+    deadline := time.Now().Add(5 * time.Second)
+    for len(reports.all()) < 2 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    cancel()
+    <-done
+    monitor.Sync()
+
+    got := reports.all()
+    if len(got) != 2 {
+        t.Fatalf("reported %+v, want a login and a logout", got)
+    }
+    uuid := adverts[0].ServiceUUIDs[0]
+    if got[0].UUID != uuid || got[0].Status != 1 || got[0].Reason != presence.ReasonSignal {
+        t.Errorf("first report %+v, want a signal login of %s", got[0], uuid)
+    }
+    if got[1].UUID != uuid || got[1].Status != 0 || got[1].Reason != presence.ReasonTimeout {
+        t.Errorf("second report %+v, want a timeout logout of %s", got[1], uuid)
+    }
+    if !got[1].ObservedAt.After(got[0].ObservedAt) {
+        t.Errorf("logout at %v is not after the login at %v", got[1].ObservedAt, got[0].ObservedAt)
+    }
+}
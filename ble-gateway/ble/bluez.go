@@ -0,0 +1,199 @@
+package ble
+
+import (
+    "fmt"
+    "log"
+    "reflect"
+    "sync"
+    "tinygo.org/x/bluetooth"
+)
+
+// BlueZRadio implements Radio on top of a tinygo bluetooth adapter (BlueZ on Linux)
+type BlueZRadio struct {
+    adapter bluezAdapter
+
+    // tinygo's StopScan closes the scan's channel without a lock, so two
+    // overlapping calls could close it twice; stops are serialized here
+    scanMu   sync.Mutex
+    scanning bool // Scan is running
+    stopped  bool // The running scan has been stopped
+
+    mu        sync.Mutex
+    addresses map[string]bluetooth.Address // MAC address -> address as reported by the adapter
+}
+
+// The calls BlueZRadio makes on a *bluetooth.Adapter
+type bluezAdapter interface {
+    Scan(callback func(*bluetooth.Adapter, bluetooth.ScanResult)) error
+    StopScan() error
+    Connect(address bluetooth.Address, params bluetooth.ConnectionParams) (bluetooth.Device, error)
+}
+
+// NewBlueZRadio wraps an adapter that has already been enabled
+func NewBlueZRadio(adapter *bluetooth.Adapter) *BlueZRadio {
+    return newBlueZRadio(adapter)
+}
+
+func newBlueZRadio(adapter bluezAdapter) *BlueZRadio {
+    return &BlueZRadio{
+        adapter:   adapter,
+        addresses: make(map[string]bluetooth.Address),
+    }
+}
+
+// Scan starts a BLE scan and blocks until StopScan is called
+func (r *BlueZRadio) Scan(callback func(Advertisement)) error {
+    r.scanMu.Lock()
+    if r.scanning {
+        r.scanMu.Unlock()
+        return fmt.Errorf("scan already in progress")
+    }
+    r.scanning, r.stopped = true, false
+    r.scanMu.Unlock()
+
+    err := r.adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+        macAddress := result.Address.String()
+
+        // Keep the adapter's address so Connect can be called with a plain string
+        r.mu.Lock()
+        r.addresses[macAddress] = result.Address
+        r.mu.Unlock()
+
+        callback(Advertisement{
+            Address:          macAddress,
+            LocalName:        result.LocalName(),
+            RSSI:             result.RSSI,
+            ServiceUUIDs:     advertisedServiceUUIDs(result.AdvertisementPayload),
+            ManufacturerData: manufacturerData(result.AdvertisementPayload),
+            ServiceData:      serviceData(result.AdvertisementPayload),
+        })
+    })
+
+    r.scanMu.Lock()
+    defer r.scanMu.Unlock()
+    if !r.stopped {
+        // A scan that failed still counts as running for tinygo until it is stopped
+        r.adapter.StopScan()
+    }
+    r.scanning = false
+    return err
+}
+
+// StopScan stops a running scan. It may be called from any goroutine, and
+// returns an error if no scan is running or it has already been stopped.
+func (r *BlueZRadio) StopScan() error {
+    r.scanMu.Lock()
+    defer r.scanMu.Unlock()
+
+    if !r.scanning || r.stopped {
+        return fmt.Errorf("no scan in progress")
+    }
+    if err := r.adapter.StopScan(); err != nil {
+        return err // Not started yet, so a later call can still stop it
+    }
+    r.stopped = true
+    return nil
+}
+
+// Connect connects to a device previously reported by Scan
+func (r *BlueZRadio) Connect(macAddress string) (Peripheral, error) {
+    r.mu.Lock()
+    address, exists := r.addresses[macAddress]
+    r.mu.Unlock()
+    if !exists {
+        return nil, fmt.Errorf("device %s has not been seen by the scanner", macAddress)
+    }
+
+    device, err := r.adapter.Connect(address, bluetooth.ConnectionParams{})
+    if err != nil {
+        return nil, err
+    }
+    return &bluezPeripheral{device: device}, nil
+}
+
+type bluezPeripheral struct {
+    device bluetooth.Device
+}
+
+func (p *bluezPeripheral) DiscoverServices() ([]string, error) {
+    services, err := p.device.DiscoverServices(nil)
+    if err != nil {
+        return nil, err
+    }
+
+    uuids := make([]string, 0, len(services))
+    for _, service := range services {
+        uuids = append(uuids, service.UUID().String())
+    }
+    return uuids, nil
+}
+
+func (p *bluezPeripheral) Disconnect() error {
+    return p.device.Disconnect()
+}
+
+// Copy the manufacturer data out of a scan result, which is only valid during the callback
+func manufacturerData(payload bluetooth.AdvertisementPayload) []ManufacturerData {
+    var elements []ManufacturerData
+    for _, element := range payload.ManufacturerData() {
+        elements = append(elements, ManufacturerData{
+            CompanyID: element.CompanyID,
+            Data:      append([]byte(nil), element.Data...),
+        })
+    }
+    return elements
+}
+
+// Copy the service data out of a scan result, which is only valid during the callback
+func serviceData(payload bluetooth.AdvertisementPayload) []ServiceData {
+    var elements []ServiceData
+    for _, element := range payload.ServiceData() {
+        elements = append(elements, ServiceData{
+            UUID: element.UUID.String(),
+            Data: append([]byte(nil), element.Data...),
+        })
+    }
+    return elements
+}
+
+// Logs the first scan result whose service UUIDs cannot be read
+var serviceUUIDsUnreadable sync.Once
+
+// advertisedServiceUUIDs returns the service UUID list of a scan result.
+// AdvertisementPayload only exposes HasServiceUUID, which cannot list the UUIDs,
+// and Bytes, which BlueZ does not provide. The BlueZ backend of tinygo bluetooth
+// v0.10.0 stores the parsed fields in an exported AdvertisementFields value, so
+// read it from there; bluez_test.go pins the version this was checked against.
+func advertisedServiceUUIDs(payload bluetooth.AdvertisementPayload) []string {
+    fields, ok := advertisementFields(payload)
+    if !ok {
+        serviceUUIDsUnreadable.Do(func() {
+            log.Printf("Cannot read service UUIDs from %T scan results: devices are only found by manufacturer data and beacons", payload)
+        })
+        return nil
+    }
+
+    uuids := make([]string, 0, len(fields.ServiceUUIDs))
+    for _, uuid := range fields.ServiceUUIDs {
+        uuids = append(uuids, uuid.String())
+    }
+    return uuids
+}
+
+// Find the AdvertisementFields value behind a payload
+func advertisementFields(payload bluetooth.AdvertisementPayload) (bluetooth.AdvertisementFields, bool) {
+    v := reflect.ValueOf(payload)
+    if v.Kind() == reflect.Ptr {
+        v = v.Elem()
+    }
+    if v.Kind() != reflect.Struct {
+        return bluetooth.AdvertisementFields{}, false
+    }
+
+    field := v.FieldByName("AdvertisementFields")
+    if !field.IsValid() || !field.CanInterface() {
+        return bluetooth.AdvertisementFields{}, false
+    }
+    fields, ok := field.Interface().(bluetooth.AdvertisementFields)
+    return fields, ok
+}
@@ -0,0 +1,189 @@
+package ble
+
+import (
+    "bytes"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "tinygo.org/x/bluetooth"
+)
+
+// Same shape as the payload the BlueZ backend of tinygo bluetooth v0.10.0
+// builds in makeScanResult (gap_linux.go)
+type bluezPayload struct {
+    bluetooth.AdvertisementFields
+}
+
+func (p *bluezPayload) LocalName() string                 { return p.AdvertisementFields.LocalName }
+func (p *bluezPayload) HasServiceUUID(bluetooth.UUID) bool { return false }
+func (p *bluezPayload) Bytes() []byte                     { return nil }
+func (p *bluezPayload) ManufacturerData() []bluetooth.ManufacturerDataElement {
+    return p.AdvertisementFields.ManufacturerData
+}
+func (p *bluezPayload) ServiceData() []bluetooth.ServiceDataElement {
+    return p.AdvertisementFields.ServiceData
+}
+
+// A payload that only holds raw advertisement data, like the HCI backends
+type rawPayload struct{}
+
+func (rawPayload) LocalName() string                                      { return "" }
+func (rawPayload) HasServiceUUID(bluetooth.UUID) bool                      { return false }
+func (rawPayload) Bytes() []byte                                          { return []byte{} }
+func (rawPayload) ManufacturerData() []bluetooth.ManufacturerDataElement { return nil }
+func (rawPayload) ServiceData() []bluetooth.ServiceDataElement           { return nil }
+
+// advertisedServiceUUIDs depends on the layout of the BlueZ payload, so an
+// upgrade has to re-check gap_linux.go and bluezPayload before bumping this
+func TestBluetoothVersion(t *testing.T) {
+    mod, err := os.ReadFile("../go.mod")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !strings.Contains(string(mod), "tinygo.org/x/bluetooth v0.10.0\n") {
+        t.Error("tinygo.org/x/bluetooth is no longer v0.10.0: check that advertisedServiceUUIDs still finds AdvertisementFields in BlueZ scan results")
+    }
+}
+
+func TestAdvertisedServiceUUIDs(t *testing.T) {
+    user, err := bluetooth.ParseUUID("123e4567-e89b-12d3-a456-426614174000")
+    if err != nil {
+        t.Fatal(err)
+    }
+    eddystone := bluetooth.New16BitUUID(0xfeaa)
+
+    tests := []struct {
+        name    string
+        payload bluetooth.AdvertisementPayload
+        want    []string
+    }{
+        {"service UUIDs", &bluezPayload{bluetooth.AdvertisementFields{ServiceUUIDs: []bluetooth.UUID{user, eddystone}}}, []string{"123e4567-e89b-12d3-a456-426614174000", "0000feaa-0000-1000-8000-00805f9b34fb"}},
+        {"no service UUIDs", &bluezPayload{}, []string{}},
+        {"raw payload", rawPayload{}, nil},
+        {"no payload", nil, nil},
+    }
+    for _, tt := range tests {
+        got := advertisedServiceUUIDs(tt.payload)
+        if strings.Join(got, ",") != strings.Join(tt.want, ",") || (got == nil) != (tt.want == nil) {
+            t.Errorf("%s: %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestUnreadableServiceUUIDsLoggedOnce(t *testing.T) {
+    var logged bytes.Buffer
+    log.SetOutput(&logged)
+    defer log.SetOutput(os.Stderr)
+    serviceUUIDsUnreadable = sync.Once{}
+
+    for i := 0; i < 3; i++ {
+        advertisedServiceUUIDs(rawPayload{})
+    }
+    advertisedServiceUUIDs(&bluezPayload{})
+    if n := strings.Count(logged.String(), "Cannot read service UUIDs"); n != 1 {
+        t.Errorf("logged %d times, want once:\n%s", n, logged.String())
+    }
+}
+
+// fakeAdapter stops scans the way tinygo's BlueZ adapter does: StopScan
+// closes the scan's channel without a lock and Scan never clears it
+type fakeAdapter struct {
+    cancel  chan struct{}
+    started chan struct{} // Receives once a scan has set up its channel
+    scanErr error         // Returned by Scan right after it starts
+}
+
+func (a *fakeAdapter) Scan(callback func(*bluetooth.Adapter, bluetooth.ScanResult)) error {
+    if a.cancel != nil {
+        return fmt.Errorf("already scanning")
+    }
+    cancel := make(chan struct{})
+    a.cancel = cancel
+    a.started <- struct{}{}
+    if a.scanErr != nil {
+        return a.scanErr
+    }
+    <-cancel
+    return nil
+}
+
+func (a *fakeAdapter) StopScan() error {
+    if a.cancel == nil {
+        return fmt.Errorf("not scanning")
+    }
+    close(a.cancel)
+    a.cancel = nil
+    return nil
+}
+
+func (a *fakeAdapter) Connect(bluetooth.Address, bluetooth.ConnectionParams) (bluetooth.Device, error) {
+    return bluetooth.Device{}, fmt.Errorf("not supported")
+}
+
+func TestBlueZStopScanConcurrently(t *testing.T) {
+    adapter := &fakeAdapter{started: make(chan struct{}, 1)}
+    radio := newBlueZRadio(adapter)
+
+    for round := 0; round < 20; round++ {
+        scanned := make(chan error, 1)
+        go func() {
+            scanned <- radio.Scan(func(Advertisement) {})
+        }()
+        <-adapter.started
+
+        // The scan window, a cancelled context and a reload all stop the scan at once
+        var wg sync.WaitGroup
+        stopped := make(chan error, 8)
+        for i := 0; i < cap(stopped); i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                stopped <- radio.StopScan()
+            }()
+        }
+        wg.Wait()
+        close(stopped)
+
+        successes := 0
+        for err := range stopped {
+            if err == nil {
+                successes++
+            }
+        }
+        if successes != 1 {
+            t.Fatalf("round %d: %d calls stopped the scan, want 1", round, successes)
+        }
+        if err := <-scanned; err != nil {
+            t.Fatalf("round %d: scan failed: %v", round, err)
+        }
+    }
+    if err := radio.StopScan(); err == nil {
+        t.Error("StopScan without a scan succeeded")
+    }
+}
+
+func TestBlueZScanAfterFailedScan(t *testing.T) {
+    adapter := &fakeAdapter{started: make(chan struct{}, 1), scanErr: fmt.Errorf("discovery failed")}
+    radio := newBlueZRadio(adapter)
+    if err := radio.Scan(func(Advertisement) {}); err == nil {
+        t.Fatal("failed scan returned no error")
+    }
+    <-adapter.started
+
+    // The adapter must not be left believing a scan is running
+    adapter.scanErr = nil
+    scanned := make(chan error, 1)
+    go func() {
+        scanned <- radio.Scan(func(Advertisement) {})
+    }()
+    <-adapter.started
+    if err := radio.StopScan(); err != nil {
+        t.Fatal(err)
+    }
+    if err := <-scanned; err != nil {
+        t.Errorf("scan after a failed scan: %v", err)
+    }
+}
@@ -0,0 +1,99 @@
+package ble
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "time"
+    "ble-gateway/store"
+)
+
+// Calibrate records RSSI samples of one device held at a known distance,
+// refits its path-loss constants from every sample recorded so far and
+// stores them with the device
+func Calibrate(devices store.DeviceStore, radio Radio, cfg Config, uuid string, distance float64, count int, timeout time.Duration) (Calibration, error) {
+    if distance <= 0 {
+        return Calibration{}, fmt.Errorf("distance must be positive, got %v", distance)
+    }
+
+    if _, err := devices.Device(uuid); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            return Calibration{}, fmt.Errorf("UUID %s is not registered", uuid)
+        }
+        return Calibration{}, err
+    }
+
+    rssi, err := collectRSSI(devices, radio, cfg, uuid, count, timeout)
+    if err != nil {
+        return Calibration{}, err
+    }
+    fmt.Printf("Collected %d samples at %.2f m (mean RSSI %.1f dBm).\n", len(rssi), distance, mean(rssi))
+
+    recorded := make([]CalibrationSample, 0, len(rssi))
+    for _, value := range rssi {
+        recorded = append(recorded, CalibrationSample{Distance: distance, RSSI: value})
+    }
+    if err := devices.AddCalibrationSamples(uuid, recorded); err != nil {
+        return Calibration{}, err
+    }
+    samples, err := devices.CalibrationSamples(uuid)
+    if err != nil {
+        return Calibration{}, err
+    }
+
+    exponent := cfg.DefaultCalibration.PathLossExponent
+    if stored, found, err := findCalibration(devices, uuid); err == nil && found {
+        exponent = stored.PathLossExponent
+    }
+    calibration, err := FitCalibration(samples, exponent)
+    if err != nil {
+        return Calibration{}, err
+    }
+
+    if err := devices.SetCalibration(uuid, calibration.TxPower, calibration.PathLossExponent); err != nil {
+        return Calibration{}, err
+    }
+    return calibration, nil
+}
+
+// Scan until count advertisements of the device have been seen or timeout passes
+func collectRSSI(devices store.DeviceStore, radio Radio, cfg Config, uuid string, count int, timeout time.Duration) ([]float64, error) {
+    var samples []float64
+    timer := time.AfterFunc(timeout, func() { radio.StopScan() })
+    defer timer.Stop()
+
+    err := radio.Scan(func(result Advertisement) {
+        if len(samples) >= count || !advertises(devices, cfg, result, uuid) {
+            return
+        }
+        samples = append(samples, float64(result.RSSI))
+        if len(samples) >= count {
+            radio.StopScan()
+        }
+    })
+    if err != nil {
+        return nil, fmt.Errorf("scan failed: %v", err)
+    }
+    if len(samples) < count {
+        return nil, fmt.Errorf("only %d of %d samples received before timeout", len(samples), count)
+    }
+    return samples, nil
+}
+
+// Report whether an advertisement identifies the device, without connecting
+func advertises(devices store.DeviceStore, cfg Config, result Advertisement, uuid string) bool {
+    for _, candidate := range append(advertisedUUIDs(cfg, result), beaconUUIDs(devices, result)...) {
+        if candidate == uuid {
+            return true
+        }
+    }
+    return false
+}
+
+func mean(values []float64) float64 {
+    sum := 0.0
+    for _, v := range values {
+        sum += v
+    }
+    return sum / math.Max(1, float64(len(values)))
+}
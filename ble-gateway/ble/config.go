@@ -0,0 +1,131 @@
+package ble
+
+import (
+    "fmt"
+    "strings"
+    "time"
+    "ble-gateway/store"
+)
+
+// Scan modes
+const (
+    ModeConnect = "connect" // Connect to each named device and discover its GATT services
+    ModePassive = "passive" // Identify users from advertisement data alone, never connect
+)
+
+// Config holds the scan loop settings; the yaml keys are those of the
+// scan section of the gateway configuration file
+type Config struct {
+    Mode string `yaml:"mode"` // ModeConnect or ModePassive
+
+    // The scan is stopped and restarted every ScanWindow, ScanInterval later.
+    // Devices not seen for Timeout are logged out, checked every SweepInterval.
+    ScanWindow    time.Duration `yaml:"scan_window"`
+    ScanInterval  time.Duration `yaml:"scan_interval"`
+    SweepInterval time.Duration `yaml:"sweep_interval"`
+    Timeout       time.Duration `yaml:"timeout"`
+
+    // Service UUIDs that never identify a user, e.g. the Generic Attribute service
+    IgnoredServices []string `yaml:"ignored_services"`
+
+    // RSSI smoothing
+    Filter                 string  `yaml:"rssi_filter"` // FilterNone, FilterAverage, FilterMedian or FilterKalman
+    FilterWindow           int     `yaml:"rssi_window"` // Samples in the moving average or median
+    KalmanProcessNoise     float64 `yaml:"kalman_q"`    // How fast the true RSSI is expected to drift
+    KalmanMeasurementNoise float64 `yaml:"kalman_r"`    // How noisy a single RSSI sample is
+
+    // Hysteresis: log in above EnterRSSI, log out below ExitRSSI,
+    // each only after DwellCount consecutive filtered samples agree
+    EnterRSSI  int `yaml:"enter_rssi"`
+    ExitRSSI   int `yaml:"exit_rssi"`
+    DwellCount int `yaml:"dwell"`
+
+    // Distance based login: when EnterDistance is set, users are logged in
+    // within EnterDistance meters and out beyond ExitDistance, replacing the
+    // RSSI thresholds. Devices without a stored calibration use DefaultCalibration.
+    EnterDistance      float64     `yaml:"enter_distance"`
+    ExitDistance       float64     `yaml:"exit_distance"`
+    DefaultCalibration Calibration `yaml:"default_calibration"`
+}
+
+// DefaultConfig returns the settings the gateway runs with when nothing is configured
+func DefaultConfig() Config {
+    return Config{
+        Mode:                   ModeConnect,
+        ScanWindow:             10 * time.Second,
+        ScanInterval:           3 * time.Second,
+        SweepInterval:          time.Second,
+        Timeout:                30 * time.Second,
+        IgnoredServices:        []string{genericAttributeUUID},
+        Filter:                 FilterAverage,
+        FilterWindow:           5,
+        KalmanProcessNoise:     0.05,
+        KalmanMeasurementNoise: 4,
+        EnterRSSI:              -88,
+        ExitRSSI:               -92,
+        DwellCount:             2,
+        DefaultCalibration:     Calibration{TxPower: -59, PathLossExponent: 2},
+    }
+}
+
+// Report whether a service UUID is never a user UUID
+func (c Config) ignored(uuid string) bool {
+    for _, ignored := range c.IgnoredServices {
+        if strings.EqualFold(uuid, ignored) {
+            return true
+        }
+    }
+    return false
+}
+
+// Validate checks the settings before scanning starts
+func (c Config) Validate() error {
+    if c.Mode != ModeConnect && c.Mode != ModePassive {
+        return fmt.Errorf("invalid scan mode %q (want %q or %q)", c.Mode, ModeConnect, ModePassive)
+    }
+    if c.ScanWindow <= 0 {
+        return fmt.Errorf("scan window must be positive, got %s", c.ScanWindow)
+    }
+    if c.ScanInterval < 0 {
+        return fmt.Errorf("scan interval must not be negative, got %s", c.ScanInterval)
+    }
+    if c.Timeout <= 0 {
+        return fmt.Errorf("timeout must be positive, got %s", c.Timeout)
+    }
+    if c.SweepInterval <= 0 || c.SweepInterval > c.Timeout {
+        return fmt.Errorf("sweep interval must be positive and at most the %s timeout, got %s", c.Timeout, c.SweepInterval)
+    }
+    for _, uuid := range c.IgnoredServices {
+        if _, err := store.NormalizeUUID(uuid); err != nil {
+            return fmt.Errorf("invalid ignored service UUID %q", uuid)
+        }
+    }
+    switch c.Filter {
+    case FilterNone, FilterKalman:
+    case FilterAverage, FilterMedian:
+        if c.FilterWindow < 1 {
+            return fmt.Errorf("filter window must be at least 1, got %d", c.FilterWindow)
+        }
+    default:
+        return fmt.Errorf("invalid RSSI filter %q (want %q, %q, %q or %q)", c.Filter, FilterNone, FilterAverage, FilterMedian, FilterKalman)
+    }
+    if c.Filter == FilterKalman && (c.KalmanProcessNoise <= 0 || c.KalmanMeasurementNoise <= 0) {
+        return fmt.Errorf("kalman noise parameters must be positive")
+    }
+    if c.ExitRSSI > c.EnterRSSI {
+        return fmt.Errorf("exit RSSI (%d dBm) must not be above enter RSSI (%d dBm)", c.ExitRSSI, c.EnterRSSI)
+    }
+    if c.EnterDistance < 0 {
+        return fmt.Errorf("enter distance must not be negative, got %v", c.EnterDistance)
+    }
+    if c.EnterDistance > 0 && c.ExitDistance < c.EnterDistance {
+        return fmt.Errorf("exit distance (%v m) must not be below enter distance (%v m)", c.ExitDistance, c.EnterDistance)
+    }
+    if c.DefaultCalibration.PathLossExponent <= 0 {
+        return fmt.Errorf("path loss exponent must be positive, got %v", c.DefaultCalibration.PathLossExponent)
+    }
+    if c.DwellCount < 1 {
+        return fmt.Errorf("dwell count must be at least 1, got %d", c.DwellCount)
+    }
+    return nil
+}
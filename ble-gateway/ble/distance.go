@@ -0,0 +1,93 @@
+package ble
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "ble-gateway/store"
+)
+
+// Calibration holds the log-distance path-loss constants of one device:
+// RSSI(d) = TxPower - 10 * PathLossExponent * log10(d / 1 m)
+type Calibration struct {
+    TxPower          float64 `yaml:"tx_power"`           // Measured RSSI at 1 m, in dBm
+    PathLossExponent float64 `yaml:"path_loss_exponent"` // 2 in free space, typically 2-4 indoors
+}
+
+// Distance estimates the distance in meters for a (filtered) RSSI
+func (c Calibration) Distance(rssi float64) float64 {
+    return math.Pow(10, (c.TxPower-rssi)/(10*c.PathLossExponent))
+}
+
+// CalibrationSample is an RSSI reading taken at a known distance
+type CalibrationSample = store.CalibrationSample
+
+// FitCalibration fits the path-loss constants to samples by least squares.
+// Samples from a single distance only determine TxPower, so the given
+// exponent is kept in that case.
+func FitCalibration(samples []CalibrationSample, exponent float64) (Calibration, error) {
+    if len(samples) == 0 {
+        return Calibration{}, fmt.Errorf("no calibration samples")
+    }
+
+    // Linear model rssi = TxPower + n * x with x = -10 * log10(d)
+    var sumX, sumY, sumXX, sumXY float64
+    distances := make(map[float64]bool)
+    for _, sample := range samples {
+        if sample.Distance <= 0 {
+            return Calibration{}, fmt.Errorf("invalid calibration distance %v", sample.Distance)
+        }
+        x := -10 * math.Log10(sample.Distance)
+        sumX += x
+        sumY += sample.RSSI
+        sumXX += x * x
+        sumXY += x * sample.RSSI
+        distances[sample.Distance] = true
+    }
+    count := float64(len(samples))
+
+    if len(distances) > 1 {
+        exponent = (count*sumXY - sumX*sumY) / (count*sumXX - sumX*sumX)
+        if exponent <= 0 {
+            return Calibration{}, fmt.Errorf("samples do not get weaker with distance (fitted exponent %.2f)", exponent)
+        }
+    }
+    return Calibration{
+        TxPower:          (sumY - exponent*sumX) / count,
+        PathLossExponent: exponent,
+    }, nil
+}
+
+// Calibration of the first service UUID that has one stored, or the configured default
+func calibrationFor(devices store.DeviceStore, cfg Config, services []string) Calibration {
+    for _, uuid := range services {
+        if cfg.ignored(uuid) {
+            continue
+        }
+        calibration, found, err := findCalibration(devices, uuid)
+        if err != nil {
+            log.Printf("Error reading calibration for %s: %v", uuid, err)
+            continue
+        }
+        if found {
+            return calibration
+        }
+    }
+    return cfg.DefaultCalibration
+}
+
+// Read the stored calibration of a device (found is false if the device has none)
+func findCalibration(devices store.DeviceStore, uuid string) (calibration Calibration, found bool, err error) {
+    device, err := devices.Device(uuid)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            return Calibration{}, false, nil
+        }
+        return Calibration{}, false, fmt.Errorf("failed to read calibration: %v", err)
+    }
+    if !device.Calibrated() {
+        return Calibration{}, false, nil
+    }
+    return Calibration{TxPower: device.TxPower, PathLossExponent: device.PathLossExponent}, true, nil
+}
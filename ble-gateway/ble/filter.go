@@ -0,0 +1,154 @@
+package ble
+
+import (
+    "math"
+    "sort"
+    "time"
+    "ble-gateway/presence"
+)
+
+// RSSI filter types
+const (
+    FilterNone    = "none"    // Use raw samples
+    FilterAverage = "average" // Moving average over the last FilterWindow samples
+    FilterMedian  = "median"  // Median of the last FilterWindow samples, ignores single spikes
+    FilterKalman  = "kalman"  // One-dimensional Kalman filter
+)
+
+// RSSIFilter smooths a stream of RSSI samples from one device
+type RSSIFilter interface {
+    Update(rssi float64) float64 // Add a sample and return the filtered value
+}
+
+// Create the RSSI filter selected by the configuration
+func newRSSIFilter(cfg Config) RSSIFilter {
+    switch cfg.Filter {
+    case FilterAverage:
+        return &movingAverage{size: cfg.FilterWindow}
+    case FilterMedian:
+        return &movingMedian{size: cfg.FilterWindow}
+    case FilterKalman:
+        return &kalmanFilter{q: cfg.KalmanProcessNoise, r: cfg.KalmanMeasurementNoise}
+    default:
+        return rawFilter{}
+    }
+}
+
+// The settings newRSSIFilter depends on
+type filterSettings struct {
+    filter string
+    window int
+    q, r   float64
+}
+
+func (c Config) filterSettings() filterSettings {
+    return filterSettings{c.Filter, c.FilterWindow, c.KalmanProcessNoise, c.KalmanMeasurementNoise}
+}
+
+type rawFilter struct{}
+
+func (rawFilter) Update(rssi float64) float64 {
+    return rssi
+}
+
+// movingAverage averages the most recent samples in a ring buffer
+type movingAverage struct {
+    size    int
+    samples []float64
+    next    int
+    sum     float64
+}
+
+func (f *movingAverage) Update(rssi float64) float64 {
+    if len(f.samples) < f.size {
+        f.samples = append(f.samples, rssi)
+    } else {
+        f.sum -= f.samples[f.next]
+        f.samples[f.next] = rssi
+        f.next = (f.next + 1) % f.size
+    }
+    f.sum += rssi
+    return f.sum / float64(len(f.samples))
+}
+
+// movingMedian returns the median of the most recent samples, so once the
+// window has filled, a spike shorter than half of it never reaches the output
+type movingMedian struct {
+    size    int
+    samples []float64
+    next    int
+}
+
+func (f *movingMedian) Update(rssi float64) float64 {
+    if len(f.samples) < f.size {
+        f.samples = append(f.samples, rssi)
+    } else {
+        f.samples[f.next] = rssi
+        f.next = (f.next + 1) % f.size
+    }
+
+    sorted := append([]float64(nil), f.samples...)
+    sort.Float64s(sorted)
+    middle := len(sorted) / 2
+    if len(sorted)%2 == 0 {
+        return (sorted[middle-1] + sorted[middle]) / 2
+    }
+    return sorted[middle]
+}
+
+// kalmanFilter models RSSI as a constant level disturbed by process noise q
+// and observed with measurement noise r
+type kalmanFilter struct {
+    q, r float64
+    x    float64 // Estimated RSSI
+    p    float64 // Estimate variance
+    init bool
+}
+
+func (f *kalmanFilter) Update(rssi float64) float64 {
+    if !f.init {
+        f.x, f.p, f.init = rssi, f.r, true
+        return f.x
+    }
+    f.p += f.q
+    k := f.p / (f.p + f.r)
+    f.x += k * (rssi - f.x)
+    f.p *= 1 - k
+    return f.x
+}
+
+// signalState is the RSSI history of one device
+type signalState struct {
+    filter     RSSIFilter
+    filtered   float64   // Last filtered RSSI
+    distance   float64   // Last estimated distance in meters
+    lastSample time.Time // When the last sample was added
+}
+
+// Add a sample and classify the filtered signal against the enter/exit thresholds
+func (s *signalState) observe(rssi int16, calibration Calibration, t time.Time, cfg Config) presence.Zone {
+    s.lastSample = t
+    s.filtered = s.filter.Update(float64(rssi))
+    s.distance = calibration.Distance(s.filtered)
+
+    near := s.filtered > float64(cfg.EnterRSSI)
+    far := s.filtered < float64(cfg.ExitRSSI)
+    if cfg.EnterDistance > 0 {
+        near = s.distance < cfg.EnterDistance
+        far = s.distance > cfg.ExitDistance
+    }
+
+    switch {
+    case near:
+        return presence.Near
+    case far:
+        return presence.Far
+    default:
+        return presence.Between
+    }
+}
+
+// Filtered RSSI rounded for display
+func (s *signalState) rssi() int {
+    return int(math.Round(s.filtered))
+}
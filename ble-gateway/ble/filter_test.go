@@ -0,0 +1,179 @@
+package ble
+
+import (
+    "math"
+    "testing"
+    "time"
+    "ble-gateway/presence"
+)
+
+func filterConfig(filter string) Config {
+    cfg := DefaultConfig()
+    cfg.Filter = filter
+    cfg.FilterWindow = 3
+    return cfg
+}
+
+func TestFilterSmoothing(t *testing.T) {
+    tests := []struct {
+        name    string
+        filter  string
+        samples []float64
+        want    []float64
+    }{
+        {"none", FilterNone, []float64{-60, -70, -80, -90}, []float64{-60, -70, -80, -90}},
+        {"average fills its window", FilterAverage, []float64{-60, -70, -80}, []float64{-60, -65, -70}},
+        {"average drops old samples", FilterAverage, []float64{-60, -70, -80, -90, -90}, []float64{-60, -65, -70, -80, -260.0 / 3}},
+        {"median fills its window", FilterMedian, []float64{-60, -70, -80}, []float64{-60, -65, -70}},
+        {"median drops old samples", FilterMedian, []float64{-60, -70, -80, -90, -60}, []float64{-60, -65, -70, -80, -80}},
+        {"kalman starts at the first sample", FilterKalman, []float64{-70}, []float64{-70}},
+        {"kalman holds a steady signal", FilterKalman, []float64{-70, -70, -70, -70}, []float64{-70, -70, -70, -70}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            filter := newRSSIFilter(filterConfig(tt.filter))
+            for i, sample := range tt.samples {
+                if got := filter.Update(sample); math.Abs(got-tt.want[i]) > 1e-9 {
+                    t.Errorf("sample %d (%v): got %v, want %v", i, sample, got, tt.want[i])
+                }
+            }
+        })
+    }
+}
+
+func TestFilterConvergesOnStep(t *testing.T) {
+    for _, filter := range []string{FilterAverage, FilterMedian, FilterKalman} {
+        t.Run(filter, func(t *testing.T) {
+            f := newRSSIFilter(filterConfig(filter))
+            f.Update(-60)
+            previous := -60.0
+            var got float64
+            for i := 0; i < 100; i++ {
+                got = f.Update(-90)
+                if got > previous+1e-9 {
+                    t.Fatalf("sample %d: moved away from the new level (%v after %v)", i, got, previous)
+                }
+                previous = got
+            }
+            if math.Abs(got+90) > 0.5 {
+                t.Errorf("settled at %v, want -90", got)
+            }
+        })
+    }
+}
+
+func TestFilterDampensOutliers(t *testing.T) {
+    // One reflection 40 dB above a steady -80 dBm signal
+    samples := []float64{-80, -80, -80, -80, -80, -40, -80, -80}
+    tests := []struct {
+        filter   string
+        maxError float64 // Largest deviation from -80 allowed in the output
+    }{
+        {FilterNone, 40},
+        {FilterAverage, 40.0 / 3},
+        {FilterMedian, 0},
+        {FilterKalman, 20},
+    }
+    for _, tt := range tests {
+        t.Run(tt.filter, func(t *testing.T) {
+            f := newRSSIFilter(filterConfig(tt.filter))
+            worst := 0.0
+            for _, sample := range samples {
+                worst = math.Max(worst, math.Abs(f.Update(sample)+80))
+            }
+            if worst > tt.maxError+1e-9 {
+                t.Errorf("deviated %v dB from the steady signal, want at most %v", worst, tt.maxError)
+            }
+        })
+    }
+}
+
+func TestMedianRejectsSpike(t *testing.T) {
+    // A device resting just outside the enter threshold, with one reflection far above it
+    cfg := DefaultConfig() // Enter above -88 dBm
+    samples := []int16{-90, -90, -90, -50, -90, -90}
+
+    tests := []struct {
+        filter    string
+        wantEnter bool
+    }{
+        {FilterNone, true},
+        {FilterAverage, true},
+        {FilterKalman, true},
+        {FilterMedian, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.filter, func(t *testing.T) {
+            cfg.Filter = tt.filter
+            cfg.FilterWindow = 3
+            signal := &signalState{filter: newRSSIFilter(cfg)}
+            now := time.Unix(1700000000, 0)
+            entered := false
+            for _, sample := range samples {
+                if signal.observe(sample, Calibration{TxPower: -59, PathLossExponent: 2}, now, cfg) == presence.Near {
+                    entered = true
+                }
+            }
+            if entered != tt.wantEnter {
+                t.Errorf("spike crossed the enter threshold: %v, want %v", entered, tt.wantEnter)
+            }
+        })
+    }
+}
+
+func TestSignalHysteresis(t *testing.T) {
+    byDistance := DefaultConfig()
+    byDistance.EnterDistance, byDistance.ExitDistance = 2, 4
+    calibration := Calibration{TxPower: -59, PathLossExponent: 2}
+
+    tests := []struct {
+        name    string
+        cfg     Config
+        filter  string
+        samples []int16
+        want    []presence.Zone
+    }{
+        {
+            name:    "RSSI thresholds",
+            cfg:     DefaultConfig(), // Enter above -88 dBm, exit below -92 dBm
+            filter:  FilterNone,
+            samples: []int16{-85, -88, -90, -92, -95, -89},
+            want:    []presence.Zone{presence.Near, presence.Between, presence.Between, presence.Between, presence.Far, presence.Between},
+        },
+        {
+            name:    "raw noise around the band flaps",
+            cfg:     DefaultConfig(),
+            filter:  FilterNone,
+            samples: []int16{-86, -94, -86, -94},
+            want:    []presence.Zone{presence.Near, presence.Far, presence.Near, presence.Far},
+        },
+        {
+            name:    "averaged noise stays in the band",
+            cfg:     DefaultConfig(),
+            filter:  FilterAverage,
+            samples: []int16{-90, -90, -90, -86, -94, -86, -94},
+            want:    []presence.Zone{presence.Between, presence.Between, presence.Between, presence.Between, presence.Between, presence.Between, presence.Between},
+        },
+        {
+            name:    "distance thresholds",
+            cfg:     byDistance, // Enter within 2 m, exit beyond 4 m
+            filter:  FilterNone,
+            samples: []int16{-59, -68, -75, -68},
+            want:    []presence.Zone{presence.Near, presence.Between, presence.Far, presence.Between},
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cfg := tt.cfg
+            cfg.Filter = tt.filter
+            cfg.FilterWindow = 3
+            signal := &signalState{filter: newRSSIFilter(cfg)}
+            now := time.Unix(1700000000, 0)
+            for i, sample := range tt.samples {
+                if got := signal.observe(sample, calibration, now, cfg); got != tt.want[i] {
+                    t.Errorf("sample %d (%d dBm, filtered %.1f): zone %v, want %v", i, sample, signal.filtered, got, tt.want[i])
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,276 @@
+package ble
+
+import (
+    "fmt"
+    "sync"
+    "time"
+    "ble-gateway/presence"
+    "ble-gateway/store"
+)
+
+// Size of the queue between the presence state machines and the subscribers
+const eventQueueSize = 256
+
+// Sample is one sighting of a device with an active UUID
+type Sample struct {
+    Address     string
+    UUID        string
+    RSSI        int16       // Raw RSSI of the advertisement
+    Calibration Calibration // Path-loss constants of the device
+}
+
+// Monitor tracks the presence of every device seen by the scanner.
+// The RSSI filters and presence state machines are owned by a single
+// goroutine; other goroutines reach them only through Monitor's methods,
+// which are safe for concurrent use. Transition events are delivered to
+// subscribers in order on a separate goroutine, so a slow subscriber such
+// as the gRPC reporter never blocks the scan callback. Calls made after
+// Stop have no effect.
+type Monitor struct {
+    cfg     Config
+    clock   presence.Clock
+    metrics *presence.Metrics
+
+    // Owned by the run goroutine
+    tracker *presence.Tracker
+    signals map[string]*signalState // MAC address -> filtered RSSI history
+
+    subscribers []func(presence.Event)
+    commands    chan func()
+    closed      chan struct{} // Closed by Stop
+    stopOnce    sync.Once
+    events      chan dispatchItem
+    stopped     chan struct{}
+}
+
+// dispatchItem is an event for the subscribers, or a marker used by Sync
+type dispatchItem struct {
+    event   presence.Event
+    flushed chan struct{} // If set, closed once every earlier event has been delivered
+}
+
+// NewMonitor creates a monitor; call Subscribe as needed, then Start
+func NewMonitor(cfg Config, clock presence.Clock) *Monitor {
+    m := &Monitor{
+        cfg:      cfg,
+        clock:    clock,
+        metrics:  presence.NewMetrics(),
+        tracker:  presence.NewTracker(presence.Config{DwellCount: cfg.DwellCount, Timeout: cfg.Timeout}, clock),
+        signals:  make(map[string]*signalState),
+        commands: make(chan func()),
+        closed:   make(chan struct{}),
+        events:   make(chan dispatchItem, eventQueueSize),
+        stopped:  make(chan struct{}),
+    }
+    m.tracker.Subscribe(func(e presence.Event) {
+        m.events <- dispatchItem{event: e}
+    })
+    m.Subscribe(m.metrics.Record)
+    return m
+}
+
+// Subscribe registers a function called for every presence transition.
+// It must be called before Start.
+func (m *Monitor) Subscribe(fn func(presence.Event)) {
+    m.subscribers = append(m.subscribers, fn)
+}
+
+// Start the owner and dispatcher goroutines
+func (m *Monitor) Start() {
+    go m.run()
+    go m.dispatch()
+}
+
+// Stop the monitor once queued events have been delivered; later calls only wait
+func (m *Monitor) Stop() {
+    m.stopOnce.Do(func() {
+        close(m.closed)
+    })
+    <-m.stopped
+}
+
+// Observe filters a sample and feeds it to the device's state machine
+func (m *Monitor) Observe(sample Sample) {
+    m.do(func() {
+        signal, exists := m.signals[sample.Address]
+        if !exists {
+            signal = &signalState{filter: newRSSIFilter(m.cfg)}
+            m.signals[sample.Address] = signal
+        }
+        zone := signal.observe(sample.RSSI, sample.Calibration, m.clock.Now(), m.cfg)
+
+        fmt.Printf("Device Address: %s, UUID: %s, RSSI: %d (filtered %d, ~%.1f m)\n", sample.Address, sample.UUID, sample.RSSI, signal.rssi(), signal.distance)
+        m.tracker.Observe(presence.Observation{Address: sample.Address, UUID: sample.UUID, RSSI: signal.rssi(), Distance: signal.distance, Zone: zone})
+    })
+}
+
+// Lose marks a device absent immediately
+func (m *Monitor) Lose(macAddress string, reason presence.Reason) {
+    m.do(func() {
+        m.tracker.Lose(macAddress, reason)
+    })
+}
+
+// LoseUUID marks every device logged in with uuid absent and returns how many there were
+func (m *Monitor) LoseUUID(uuid string, reason presence.Reason) int {
+    lost := 0
+    m.do(func() {
+        for macAddress, presentUUID := range m.tracker.Present() {
+            if presentUUID == uuid {
+                m.tracker.Lose(macAddress, reason)
+                lost++
+            }
+        }
+    })
+    return lost
+}
+
+// LoseAll marks every logged in device absent and returns how many there were
+func (m *Monitor) LoseAll(reason presence.Reason) int {
+    lost := 0
+    m.do(func() {
+        for macAddress := range m.tracker.Present() {
+            m.tracker.Lose(macAddress, reason)
+            lost++
+        }
+    })
+    return lost
+}
+
+// CheckTimeouts logs out devices not detected within the configured timeout
+// and reports whether any device state changed
+func (m *Monitor) CheckTimeouts() bool {
+    changed := false
+    m.do(func() {
+        changed = m.tracker.Tick() > 0
+
+        // Start over with a fresh filter for devices that have gone quiet
+        currentTime := m.clock.Now()
+        for macAddress, signal := range m.signals {
+            if currentTime.Sub(signal.lastSample) > m.cfg.Timeout {
+                delete(m.signals, macAddress)
+                changed = true
+            }
+        }
+    })
+    return changed
+}
+
+// Config returns the settings the monitor currently runs with
+func (m *Monitor) Config() Config {
+    var cfg Config
+    m.do(func() {
+        cfg = m.cfg
+    })
+    return cfg
+}
+
+// Reconfigure switches to new settings between two samples, so every sample
+// is judged entirely by the old or the new ones. Devices keep their presence
+// state; if the RSSI filter settings changed, smoothing starts over.
+func (m *Monitor) Reconfigure(cfg Config) error {
+    if err := cfg.Validate(); err != nil {
+        return err
+    }
+    m.do(func() {
+        if cfg.filterSettings() != m.cfg.filterSettings() {
+            for _, signal := range m.signals {
+                signal.filter = newRSSIFilter(cfg)
+            }
+        }
+        m.cfg = cfg
+        m.tracker.SetConfig(presence.Config{DwellCount: cfg.DwellCount, Timeout: cfg.Timeout})
+    })
+    return nil
+}
+
+// Restore puts the devices of sessions left open by the previous run back in
+// the Present state without reporting a login. Devices not seen again within
+// grace are logged out as of their last sighting.
+func (m *Monitor) Restore(sessions []store.Session, grace time.Duration) {
+    m.do(func() {
+        for _, session := range sessions {
+            m.tracker.Restore(presence.Restored{
+                Address:  session.Address,
+                UUID:     session.UUID,
+                LastSeen: session.LastSeen,
+                RSSI:     session.PeakRSSI,
+            }, grace)
+        }
+        m.metrics.Restored(len(sessions))
+    })
+}
+
+// Snapshot returns the logged in devices
+func (m *Monitor) Snapshot() []presence.Snapshot {
+    var snapshot []presence.Snapshot
+    m.do(func() {
+        snapshot = m.tracker.Snapshot()
+    })
+    return snapshot
+}
+
+// Present returns the logged in devices as MAC address -> UUID
+func (m *Monitor) Present() map[string]string {
+    var present map[string]string
+    m.do(func() {
+        present = m.tracker.Present()
+    })
+    return present
+}
+
+// Metrics returns the transition counters
+func (m *Monitor) Metrics() presence.MetricsSnapshot {
+    return m.metrics.Snapshot()
+}
+
+// Sync waits until every earlier call has been applied and its events delivered
+func (m *Monitor) Sync() {
+    flushed := make(chan struct{})
+    m.do(func() {
+        m.events <- dispatchItem{flushed: flushed}
+    })
+    select {
+    case <-flushed:
+    case <-m.stopped: // Stopped before the marker was queued
+    }
+}
+
+// Run fn on the owner goroutine and wait for it to finish; fn is dropped once the monitor is stopped
+func (m *Monitor) do(fn func()) {
+    done := make(chan struct{})
+    select {
+    case m.commands <- func() {
+        fn()
+        close(done)
+    }:
+    case <-m.closed:
+        return
+    }
+    <-done
+}
+
+func (m *Monitor) run() {
+    for {
+        select {
+        case fn := <-m.commands:
+            fn()
+        case <-m.closed:
+            close(m.events)
+            return
+        }
+    }
+}
+
+func (m *Monitor) dispatch() {
+    for item := range m.events {
+        if item.flushed != nil {
+            close(item.flushed)
+            continue
+        }
+        for _, fn := range m.subscribers {
+            fn(item.event)
+        }
+    }
+    close(m.stopped)
+}
@@ -0,0 +1,147 @@
+package ble
+
+import (
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+    "ble-gateway/presence"
+    "ble-gateway/store"
+)
+
+// Active devices of the monitor tests, one MAC address each
+func testRegistry(n int) (*store.MemoryStore, []Advertisement) {
+    var devices []store.Device
+    var adverts []Advertisement
+    for i := 0; i < n; i++ {
+        uuid := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+        devices = append(devices, store.Device{UUID: uuid, Name: fmt.Sprintf("sensor %d", i), Active: true})
+        adverts = append(adverts, Advertisement{Address: fmt.Sprintf("AA:BB:CC:DD:EE:%02X", i), RSSI: -60, ServiceUUIDs: []string{uuid}})
+    }
+    return store.NewMemoryStore(devices...), adverts
+}
+
+// eventLog checks that every device alternates between login and logout
+type eventLog struct {
+    mu       sync.Mutex
+    loggedIn map[string]bool
+    logins   int
+    logouts  int
+    errors   []string
+}
+
+func newEventLog() *eventLog {
+    return &eventLog{loggedIn: make(map[string]bool)}
+}
+
+func (l *eventLog) record(e presence.Event) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if e.IsLogin() {
+        if l.loggedIn[e.Address] {
+            l.errors = append(l.errors, fmt.Sprintf("%s logged in twice", e.Address))
+        }
+        l.loggedIn[e.Address] = true
+        l.logins++
+    } else if e.IsLogout() {
+        if !l.loggedIn[e.Address] {
+            l.errors = append(l.errors, fmt.Sprintf("%s logged out while not logged in", e.Address))
+        }
+        l.loggedIn[e.Address] = false
+        l.logouts++
+    }
+}
+
+// Run with -race: the monitor's state is only touched by its owner goroutine
+func TestMonitorConcurrentUse(t *testing.T) {
+    const workers = 8
+    const rounds = 200
+
+    devices, adverts := testRegistry(4)
+    cfg := DefaultConfig()
+    clock := presence.NewManualClock(time.Unix(1700000000, 0))
+    monitor := NewMonitor(cfg, clock)
+    events := newEventLog()
+    monitor.Subscribe(events.record)
+    monitor.Start()
+
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func(w int) {
+            defer wg.Done()
+            for i := 0; i < rounds; i++ {
+                advert := adverts[(w+i)%len(adverts)]
+                switch i % 8 {
+                case 0:
+                    clock.Advance(time.Second)
+                    monitor.CheckTimeouts()
+                case 1:
+                    monitor.Present()
+                case 2:
+                    monitor.Snapshot()
+                    monitor.Metrics()
+                case 3:
+                    if w == 0 && i%40 == 3 {
+                        monitor.LoseAll(presence.ReasonManual)
+                    }
+                case 4:
+                    monitor.Lose(advert.Address, presence.ReasonManual)
+                default:
+                    handleScanResult(devices, cfg, monitor, advert, advert.ServiceUUIDs, nil)
+                }
+            }
+        }(w)
+    }
+    wg.Wait()
+
+    monitor.Sync()
+    present := len(monitor.Present())
+    events.mu.Lock()
+    if events.logins-events.logouts != present {
+        t.Errorf("%d logins and %d logouts, but %d devices present", events.logins, events.logouts, present)
+    }
+    events.mu.Unlock()
+
+    monitor.LoseAll(presence.ReasonShutdown)
+    monitor.Stop()
+    monitor.Stop() // A second Stop only waits
+
+    events.mu.Lock()
+    defer events.mu.Unlock()
+    if events.logins == 0 {
+        t.Error("no device ever logged in")
+    }
+    if events.logins != events.logouts {
+        t.Errorf("%d logins but %d logouts after LoseAll", events.logins, events.logouts)
+    }
+    for _, err := range events.errors {
+        t.Error(err)
+    }
+}
+
+func TestMonitorCallsAfterStop(t *testing.T) {
+    devices, adverts := testRegistry(1)
+    monitor := NewMonitor(DefaultConfig(), presence.SystemClock)
+    monitor.Start()
+    monitor.Stop()
+
+    done := make(chan struct{})
+    go func() {
+        handleScanResult(devices, DefaultConfig(), monitor, adverts[0], adverts[0].ServiceUUIDs, nil)
+        monitor.CheckTimeouts()
+        monitor.Sync()
+        if present := monitor.Present(); len(present) != 0 {
+            t.Errorf("present after Stop: %v", present)
+        }
+        if lost := monitor.LoseAll(presence.ReasonShutdown); lost != 0 {
+            t.Errorf("LoseAll after Stop lost %d devices", lost)
+        }
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("calls after Stop blocked")
+    }
+}
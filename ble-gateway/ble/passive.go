@@ -0,0 +1,62 @@
+package ble
+
+import (
+    "log"
+    "strings"
+    "ble-gateway/beacon"
+    "ble-gateway/store"
+)
+
+// Length of a 128-bit UUID carried in manufacturer data
+const uuidLength = 16
+
+// Collect candidate user UUIDs from an advertisement without connecting:
+// the advertised service UUIDs, plus any manufacturer data payload that is
+// exactly one 128-bit UUID (big-endian, as written by the sensor firmware)
+func advertisedUUIDs(cfg Config, result Advertisement) []string {
+    var uuids []string
+    for _, uuid := range result.ServiceUUIDs {
+        uuid = strings.ToLower(uuid)
+        if !cfg.ignored(uuid) {
+            uuids = append(uuids, uuid)
+        }
+    }
+    for _, element := range result.ManufacturerData {
+        if len(element.Data) == uuidLength {
+            uuids = append(uuids, beacon.FormatUUID(element.Data))
+        }
+    }
+    return uuids
+}
+
+// Identities of the iBeacon and Eddystone-UID frames in an advertisement
+func beaconIDs(result Advertisement) []string {
+    var ids []string
+    for _, element := range result.ManufacturerData {
+        if b, ok := beacon.ParseIBeacon(element.CompanyID, element.Data); ok {
+            ids = append(ids, b.ID())
+        }
+    }
+    for _, element := range result.ServiceData {
+        if b, ok := beacon.ParseEddystoneUID(element.UUID, element.Data); ok {
+            ids = append(ids, b.ID())
+        }
+    }
+    return ids
+}
+
+// Resolve the beacons in an advertisement to the UUIDs of their registered devices
+func beaconUUIDs(devices store.DeviceStore, result Advertisement) []string {
+    var uuids []string
+    for _, id := range beaconIDs(result) {
+        uuid, err := devices.FindByBeacon(id)
+        if err != nil {
+            log.Printf("Error looking up beacon %s: %v", id, err)
+            continue
+        }
+        if uuid != "" {
+            uuids = append(uuids, uuid)
+        }
+    }
+    return uuids
+}
@@ -0,0 +1,116 @@
+package ble
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+    "google.golang.org/grpc"
+    "ble-gateway/handler"
+    "ble-gateway/presence"
+    pb "ble-gateway/proto"
+    "ble-gateway/store"
+)
+
+// Replay feeds a recorded trace through the presence logic on a virtual clock
+// and writes every status report that would have been sent to out
+func Replay(devices store.DeviceStore, r io.Reader, cfg Config, out io.Writer) error {
+    // Without registered devices every advertisement would be ignored
+    registered, err := devices.ListDevices(store.DeviceFilter{Limit: 1})
+    if err != nil {
+        return err
+    }
+    if len(registered) == 0 {
+        return fmt.Errorf("the database has no registered devices to replay against")
+    }
+
+    clock := presence.NewManualClock(time.Time{})
+    client := &replayClient{out: out, clock: clock}
+    monitor := NewStatusMonitor(cfg, clock, func(report handler.DeviceReport) {
+        handler.SendDeviceReport(client, report)
+    })
+    monitor.Start()
+    defer monitor.Stop()
+
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    line, records := 0, 0
+    for scanner.Scan() {
+        line++
+        if strings.TrimSpace(scanner.Text()) == "" {
+            continue
+        }
+        records++
+
+        var record TraceRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            return fmt.Errorf("line %d: invalid trace record: %v", line, err)
+        }
+        if record.Time.Before(clock.Now()) {
+            return fmt.Errorf("line %d: record time %s is before the previous record", line, record.Time.Format(time.RFC3339Nano))
+        }
+        clock.Set(record.Time)
+
+        switch record.Event {
+        case TraceScanStart:
+            // Only moves the clock; timeouts are checked where the gateway checked them
+        case TraceSweep:
+            monitor.CheckTimeouts()
+        case TraceAdvertisement:
+            var connectErr error
+            if record.ConnectError != "" {
+                connectErr = errors.New(record.ConnectError)
+            }
+            result := Advertisement{
+                Address:   record.Address,
+                LocalName: record.LocalName,
+                RSSI:      record.RSSI,
+            }
+            handleScanResult(devices, cfg, monitor, result, record.Services, connectErr)
+        default:
+            return fmt.Errorf("line %d: unknown event %q", line, record.Event)
+        }
+
+        // Deliver this record's reports before the clock moves on
+        monitor.Sync()
+    }
+    if err := scanner.Err(); err != nil {
+        return err
+    }
+    if records == 0 {
+        return fmt.Errorf("the trace has no records")
+    }
+    return nil
+}
+
+// replayClient stands in for the BALogin server and prints every status report
+type replayClient struct {
+    out   io.Writer
+    clock presence.Clock
+}
+
+func (c *replayClient) RequestUnusedUUID(ctx context.Context, in *pb.UUIDRequest, opts ...grpc.CallOption) (*pb.Response, error) {
+    return nil, fmt.Errorf("RequestUnusedUUID is not available during replay")
+}
+
+func (c *replayClient) PresenceStream(ctx context.Context, opts ...grpc.CallOption) (pb.DeviceService_PresenceStreamClient, error) {
+    return nil, fmt.Errorf("PresenceStream is not available during replay")
+}
+
+func (c *replayClient) SyncPresence(ctx context.Context, in *pb.PresenceSnapshot, opts ...grpc.CallOption) (*pb.Response, error) {
+    return nil, fmt.Errorf("SyncPresence is not available during replay")
+}
+
+func (c *replayClient) SendDeviceStatus(ctx context.Context, in *pb.DeviceStatus, opts ...grpc.CallOption) (*pb.Response, error) {
+    event := "LOGOUT"
+    if in.Status == 1 {
+        event = "LOGIN"
+    }
+    reason := strings.ToLower(strings.TrimPrefix(in.Reason.String(), "STATUS_REASON_"))
+    fmt.Fprintf(c.out, "%s %s %s %s\n", c.clock.Now().Format(time.RFC3339Nano), event, in.Uuid, reason)
+    return &pb.Response{Message: "replayed"}, nil
+}
@@ -0,0 +1,84 @@
+package ble
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+    "ble-gateway/store"
+)
+
+// Trace of a device seen once a second until it logs in
+func loginTrace(t *testing.T, advert Advertisement, sightings int) string {
+    t.Helper()
+    var trace strings.Builder
+    start := time.Unix(1700000000, 0).UTC()
+    for i := 0; i < sightings; i++ {
+        record := TraceRecord{
+            Time:     start.Add(time.Duration(i) * time.Second),
+            Event:    TraceAdvertisement,
+            Address:  advert.Address,
+            RSSI:     advert.RSSI,
+            Services: advert.ServiceUUIDs,
+        }
+        line, err := json.Marshal(record)
+        if err != nil {
+            t.Fatal(err)
+        }
+        trace.Write(append(line, '\n'))
+    }
+    return trace.String()
+}
+
+// Append a record without a device to a trace
+func withRecord(t *testing.T, trace string, at time.Time, event string) string {
+    t.Helper()
+    line, err := json.Marshal(TraceRecord{Time: at, Event: event})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return trace + string(line) + "\n"
+}
+
+func TestReplay(t *testing.T) {
+    devices, adverts := testRegistry(1)
+    cfg := DefaultConfig()
+    trace := loginTrace(t, adverts[0], cfg.DwellCount+1)
+    afterTimeout := time.Unix(1700000000, 0).UTC().Add(time.Minute)
+
+    tests := []struct {
+        name    string
+        devices store.DeviceStore
+        trace   string
+        err     string // "" if the replay succeeds
+        login   bool
+        logout  bool
+    }{
+        {"login", devices, trace, "", true, false},
+        {"scan start does not sweep", devices, withRecord(t, trace, afterTimeout, TraceScanStart), "", true, false},
+        {"sweep logs out", devices, withRecord(t, trace, afterTimeout, TraceSweep), "", true, true},
+        {"empty trace", devices, "", "no records", false, false},
+        {"blank trace", devices, "\n  \n", "no records", false, false},
+        {"empty database", store.NewMemoryStore(), trace, "no registered devices", false, false},
+        {"invalid record", devices, "{\n", "line 1", false, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var out bytes.Buffer
+            err := Replay(tt.devices, strings.NewReader(tt.trace), cfg, &out)
+            if tt.err == "" && err != nil {
+                t.Fatalf("replay failed: %v", err)
+            }
+            if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+                t.Fatalf("error = %v, want one mentioning %q", err, tt.err)
+            }
+            if got := strings.Contains(out.String(), "LOGIN "+adverts[0].ServiceUUIDs[0]); got != tt.login {
+                t.Errorf("login reported = %v, want %v; output:\n%s", got, tt.login, out.String())
+            }
+            if got := strings.Contains(out.String(), "LOGOUT "+adverts[0].ServiceUUIDs[0]+" timeout"); got != tt.logout {
+                t.Errorf("logout reported = %v, want %v; output:\n%s", got, tt.logout, out.String())
+            }
+        })
+    }
+}
@@ -0,0 +1,46 @@
+package ble
+
+// Advertisement is a single scan result, detached from the radio that produced it
+type Advertisement struct {
+    Address          string   // MAC address of the advertising device
+    LocalName        string   // Complete or shortened local name ("" if not advertised)
+    RSSI             int16    // Signal strength of the advertisement packet
+    ServiceUUIDs     []string // Service UUIDs listed in the advertisement
+    ManufacturerData []ManufacturerData
+    ServiceData      []ServiceData
+}
+
+// ManufacturerData is one manufacturer specific data element of an advertisement
+type ManufacturerData struct {
+    CompanyID uint16 // Bluetooth SIG company identifier
+    Data      []byte
+}
+
+// ServiceData is one service data element of an advertisement
+type ServiceData struct {
+    UUID string // Service UUID the data belongs to
+    Data []byte
+}
+
+// Scanner delivers advertisements to the callback until StopScan is called
+type Scanner interface {
+    Scan(callback func(Advertisement)) error
+    StopScan() error
+}
+
+// Connector opens a GATT connection to a device seen during a scan
+type Connector interface {
+    Connect(address string) (Peripheral, error)
+}
+
+// Peripheral is a connected BLE device
+type Peripheral interface {
+    DiscoverServices() ([]string, error) // UUIDs of all services on the device
+    Disconnect() error
+}
+
+// Radio is everything the scan loop needs from the BLE hardware
+type Radio interface {
+    Scanner
+    Connector
+}
@@ -0,0 +1,139 @@
+package ble
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sync"
+    "time"
+    "ble-gateway/presence"
+    "ble-gateway/store"
+)
+
+// End reason of a session that was left open by a previous run and superseded by a later one
+const EndReasonRestart = "gateway_restart"
+
+// SessionRecorder writes every login and logout to the presence history.
+// The open sessions of a gateway are the devices it has logged in, so they
+// double as the presence state restored after a crash. Record is a Monitor
+// subscriber and is only called from its dispatcher.
+type SessionRecorder struct {
+    devices store.DeviceStore
+    gateway string
+
+    mu   sync.Mutex
+    open map[string]int64 // MAC address -> ID of its open session
+}
+
+// NewSessionRecorder creates a recorder for the sessions of one gateway
+func NewSessionRecorder(devices store.DeviceStore, gateway string) *SessionRecorder {
+    return &SessionRecorder{devices: devices, gateway: gateway, open: make(map[string]int64)}
+}
+
+// Restore takes over the sessions this gateway left open when it last
+// stopped without logging devices out, and returns them for Monitor.Restore
+func (r *SessionRecorder) Restore() ([]store.Session, error) {
+    sessions, err := r.devices.OpenSessions(r.gateway)
+    if err != nil {
+        return nil, err
+    }
+
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    latest := make(map[string]store.Session)
+    for _, session := range sessions {
+        // Oldest first, so a later session of the same device supersedes an earlier one
+        if previous, exists := latest[session.Address]; exists {
+            if err := r.devices.EndSession(previous.ID, previous.LastSeen, EndReasonRestart, previous.PeakRSSI); err != nil {
+                return nil, err
+            }
+        }
+        latest[session.Address] = session
+    }
+
+    restored := make([]store.Session, 0, len(latest))
+    for address, session := range latest {
+        r.open[address] = session.ID
+        restored = append(restored, session)
+    }
+    return restored, nil
+}
+
+// Record starts a session on login and ends it on logout
+func (r *SessionRecorder) Record(e presence.Event) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if e.IsLogin() {
+        id, err := r.devices.StartSession(store.Session{
+            UUID:      e.UUID,
+            Address:   e.Address,
+            Gateway:   r.gateway,
+            StartedAt: e.Time,
+            PeakRSSI:  e.PeakRSSI,
+        })
+        if err != nil {
+            log.Printf("Failed to record login of %s: %v", e.UUID, err)
+            return
+        }
+        r.open[e.Address] = id
+    } else if e.IsLogout() {
+        id, found := r.open[e.Address]
+        if !found {
+            return // Logged in before the session could be recorded
+        }
+        delete(r.open, e.Address)
+        if err := r.devices.EndSession(id, e.Time, string(e.Reason), e.PeakRSSI); err != nil {
+            log.Printf("Failed to record logout of %s: %v", e.UUID, err)
+        }
+    }
+}
+
+// Checkpoint records when each logged in device was last seen, every
+// interval until ctx is done, for the reconciliation after a crash
+func (r *SessionRecorder) Checkpoint(ctx context.Context, monitor *Monitor, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        if err := r.checkpoint(monitor); err != nil {
+            log.Printf("Failed to checkpoint presence: %v", err)
+        }
+    }
+}
+
+// Record the last sighting of every logged in device once
+func (r *SessionRecorder) checkpoint(monitor *Monitor) error {
+    lastSeen := make(map[int64]time.Time)
+    snapshot := monitor.Snapshot()
+    r.mu.Lock()
+    for _, device := range snapshot {
+        if id, found := r.open[device.Address]; found {
+            lastSeen[id] = device.LastSeen
+        }
+    }
+    r.mu.Unlock()
+    return r.devices.MarkSeen(lastSeen)
+}
+
+// PruneSessions deletes sessions that ended more than retention ago, every interval until ctx is done
+func PruneSessions(ctx context.Context, devices store.DeviceStore, clock presence.Clock, retention time.Duration, interval time.Duration) {
+    for {
+        pruned, err := devices.PruneSessions(clock.Now().Add(-retention))
+        if err != nil {
+            log.Printf("Failed to prune sessions: %v", err)
+        } else if pruned > 0 {
+            fmt.Printf("Pruned %d sessions older than %s.\n", pruned, retention)
+        }
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(interval):
+        }
+    }
+}
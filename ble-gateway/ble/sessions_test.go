@@ -0,0 +1,262 @@
+package ble
+
+import (
+    "context"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+    "ble-gateway/db"
+    "ble-gateway/handler"
+    "ble-gateway/presence"
+    "ble-gateway/store"
+)
+
+// reportLog collects the reports of a status monitor
+type reportLog struct {
+    mu      sync.Mutex
+    reports []handler.DeviceReport
+}
+
+func (l *reportLog) report(report handler.DeviceReport) {
+    l.mu.Lock()
+    l.reports = append(l.reports, report)
+    l.mu.Unlock()
+}
+
+func (l *reportLog) all() []handler.DeviceReport {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return append([]handler.DeviceReport(nil), l.reports...)
+}
+
+// One run of the gateway on a shared database, up to its crash
+type gatewayRun struct {
+    monitor  *Monitor
+    sessions *SessionRecorder
+    reports  *reportLog
+}
+
+func startRun(devices store.DeviceStore, clock presence.Clock) *gatewayRun {
+    run := &gatewayRun{reports: &reportLog{}}
+    run.monitor = NewStatusMonitor(DefaultConfig(), clock, run.reports.report)
+    run.sessions = NewSessionRecorder(devices, "gw-1")
+    return run
+}
+
+func (run *gatewayRun) observe(samples ...Sample) {
+    for _, sample := range samples {
+        run.monitor.Observe(sample)
+    }
+    run.monitor.Sync()
+}
+
+func TestCrashRestore(t *testing.T) {
+    database, err := db.Open(filepath.Join(t.TempDir(), "ble.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer database.Close()
+    devices := db.NewStore(database)
+
+    stays := Sample{Address: "AA:BB:CC:DD:EE:01", UUID: "00000000-0000-4000-8000-000000000001", RSSI: -60}
+    leaves := Sample{Address: "AA:BB:CC:DD:EE:02", UUID: "00000000-0000-4000-8000-000000000002", RSSI: -60}
+    const grace = time.Minute
+
+    // First run: both devices log in, are checkpointed, and the gateway crashes
+    start := time.Unix(1700000000, 0)
+    clock := presence.NewManualClock(start)
+    first := startRun(devices, clock)
+    first.monitor.Subscribe(first.sessions.Record)
+    first.monitor.Start()
+    for i := 0; i < 3; i++ {
+        clock.Advance(time.Second)
+        first.observe(stays, leaves)
+    }
+    var loggedIn time.Time
+    reports := first.reports.all()
+    if len(reports) != 2 {
+        t.Fatalf("first run reported %+v, want two logins", reports)
+    }
+    for _, report := range reports {
+        if report.UUID == stays.UUID {
+            loggedIn = report.ObservedAt
+        }
+    }
+    checkpointed := clock.Now()
+    if err := first.sessions.checkpoint(first.monitor); err != nil {
+        t.Fatalf("checkpoint failed: %v", err)
+    }
+    clock.Advance(5 * time.Second)
+    first.monitor.Stop() // Crash: nobody is logged out
+
+    // Second run, 30 seconds later
+    clock.Advance(30 * time.Second)
+    second := startRun(devices, clock)
+    restored, err := second.sessions.Restore()
+    if err != nil {
+        t.Fatalf("Restore failed: %v", err)
+    }
+    if len(restored) != 2 {
+        t.Fatalf("restored %+v, want both open sessions", restored)
+    }
+    for _, session := range restored {
+        if !session.LastSeen.Equal(checkpointed) {
+            t.Errorf("session of %s restored as last seen %v, want the checkpoint at %v", session.Address, session.LastSeen, checkpointed)
+        }
+    }
+    second.monitor.Subscribe(second.sessions.Record)
+    second.monitor.Start()
+    defer second.monitor.Stop()
+    second.monitor.Restore(restored, grace)
+
+    // Within the grace period nothing is reported, whether seen again or not
+    clock.Advance(10 * time.Second)
+    second.observe(stays)
+    second.monitor.CheckTimeouts()
+    second.monitor.Sync()
+    if reports := second.reports.all(); len(reports) != 0 {
+        t.Fatalf("reported %+v within the grace period, want nothing", reports)
+    }
+    if present := second.monitor.Present(); len(present) != 2 {
+        t.Errorf("logged in within the grace period: %v, want both devices", present)
+    }
+
+    // Once it has run out, the device not seen again is logged out as of its last sighting
+    for elapsed := 10 * time.Second; elapsed <= grace; elapsed += 10 * time.Second {
+        clock.Advance(10 * time.Second)
+        second.observe(stays)
+        second.monitor.CheckTimeouts()
+    }
+    second.monitor.Sync()
+    reports = second.reports.all()
+    if len(reports) != 1 {
+        t.Fatalf("reported %+v after the grace period, want one logout", reports)
+    }
+    logout := reports[0]
+    if logout.UUID != leaves.UUID || logout.Status != 0 || logout.Reason != presence.ReasonTimeout || !logout.ObservedAt.Equal(checkpointed) {
+        t.Errorf("logout = %+v, want %s logged out by timeout as of %v", logout, leaves.UUID, checkpointed)
+    }
+    present := second.monitor.Present()
+    if len(present) != 1 || present[stays.Address] != stays.UUID {
+        t.Errorf("logged in after the grace period: %v, want only %s", present, stays.Address)
+    }
+
+    // The history has one session per device: the device seen again keeps its open one
+    open, err := devices.OpenSessions("gw-1")
+    if err != nil || len(open) != 1 || open[0].Address != stays.Address || !open[0].StartedAt.Equal(loggedIn) {
+        t.Errorf("open sessions = %+v, %v; want only the first run's session of %s", open, err, stays.Address)
+    }
+    sessions, err := devices.ListSessions(store.SessionFilter{UUID: leaves.UUID})
+    if err != nil || len(sessions) != 1 {
+        t.Fatalf("sessions of %s = %+v, %v; want one", leaves.UUID, sessions, err)
+    }
+    if ended := sessions[0]; ended.EndReason != string(presence.ReasonTimeout) || !ended.EndedAt.Equal(checkpointed) {
+        t.Errorf("session of %s ended %v (%s), want %v (timeout)", leaves.UUID, ended.EndedAt, ended.EndReason, checkpointed)
+    }
+}
+
+// Open a migrated store that lives in memory
+func openMemoryStore(t *testing.T) store.DeviceStore {
+    t.Helper()
+    database, err := db.Open(db.MemoryPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { database.Close() })
+    return db.NewStore(database)
+}
+
+func TestSessionRecorder(t *testing.T) {
+    devices := openMemoryStore(t)
+    start := time.Unix(1700000000, 0)
+    clock := presence.NewManualClock(start)
+    run := startRun(devices, clock)
+    run.monitor.Subscribe(run.sessions.Record)
+    run.monitor.Start()
+    defer run.monitor.Stop()
+
+    near := Sample{Address: "AA:BB:CC:DD:EE:01", UUID: "00000000-0000-4000-8000-000000000001", RSSI: -50}
+    far := Sample{Address: "AA:BB:CC:DD:EE:02", UUID: "00000000-0000-4000-8000-000000000002", RSSI: -70}
+    for i := 0; i < 3; i++ {
+        clock.Advance(time.Second)
+        run.observe(near, far)
+    }
+    clock.Advance(time.Minute)
+    if run.monitor.LoseUUID(far.UUID, presence.ReasonManual) != 1 {
+        t.Fatalf("%s was not logged in", far.UUID)
+    }
+    run.monitor.Sync()
+
+    sessions, err := devices.ListSessions(store.SessionFilter{})
+    if err != nil || len(sessions) != 2 {
+        t.Fatalf("ListSessions = %+v, %v; want two sessions", sessions, err)
+    }
+    byUUID := make(map[string]store.Session)
+    for _, session := range sessions {
+        if session.Gateway != "gw-1" {
+            t.Errorf("session recorded for gateway %q, want gw-1", session.Gateway)
+        }
+        byUUID[session.UUID] = session
+    }
+    if open := byUUID[near.UUID]; !open.Open() || open.Address != near.Address || open.PeakRSSI != -50 {
+        t.Errorf("session of the device still logged in: %+v", open)
+    }
+    if ended := byUUID[far.UUID]; ended.Open() || !ended.EndedAt.Equal(clock.Now()) || ended.EndReason != string(presence.ReasonManual) || ended.PeakRSSI != -70 {
+        t.Errorf("session of the logged out device: %+v", ended)
+    }
+
+    // Sessions open at a moment, and those of one user
+    if open, err := devices.ListSessions(store.SessionFilter{From: clock.Now(), To: clock.Now()}); err != nil || len(open) != 2 {
+        t.Errorf("sessions open at the logout = %+v, %v; want both", open, err)
+    }
+    if later, err := devices.ListSessions(store.SessionFilter{From: clock.Now().Add(time.Second)}); err != nil || len(later) != 1 || later[0].UUID != near.UUID {
+        t.Errorf("sessions open after the logout = %+v, %v; want only %s", later, err, near.UUID)
+    }
+    if mine, err := devices.ListSessions(store.SessionFilter{UUID: far.UUID}); err != nil || len(mine) != 1 {
+        t.Errorf("sessions of %s = %+v, %v; want one", far.UUID, mine, err)
+    }
+}
+
+func TestPruneSessions(t *testing.T) {
+    devices := openMemoryStore(t)
+    now := time.Unix(1700000000, 0)
+    const retention = 24 * time.Hour
+
+    stays := []struct {
+        uuid     string
+        ended    time.Duration // Before now; 0 for a session still open
+        wantKept bool
+    }{
+        {"00000000-0000-4000-8000-000000000001", 48 * time.Hour, false},
+        {"00000000-0000-4000-8000-000000000002", 25 * time.Hour, false},
+        {"00000000-0000-4000-8000-000000000003", 23 * time.Hour, true},
+        {"00000000-0000-4000-8000-000000000004", 0, true},
+    }
+    for _, stay := range stays {
+        id, err := devices.StartSession(store.Session{UUID: stay.uuid, Address: "AA:BB:CC:DD:EE:01", Gateway: "gw-1", StartedAt: now.Add(-72 * time.Hour), PeakRSSI: -60})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if stay.ended > 0 {
+            if err := devices.EndSession(id, now.Add(-stay.ended), string(presence.ReasonTimeout), -60); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+
+    // A cancelled context prunes once and returns
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    PruneSessions(ctx, devices, presence.NewManualClock(now), retention, time.Hour)
+
+    for _, stay := range stays {
+        sessions, err := devices.ListSessions(store.SessionFilter{UUID: stay.uuid})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if kept := len(sessions) == 1; kept != stay.wantKept {
+            t.Errorf("session of %s that ended %v ago kept: %v, want %v", stay.uuid, stay.ended, kept, stay.wantKept)
+        }
+    }
+}
@@ -0,0 +1,207 @@
+package ble
+
+import (
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "sync"
+    "time"
+)
+
+// SimEvent is one scripted advertisement, delivered At after the first scan starts
+type SimEvent struct {
+    At           time.Duration
+    Advertisement
+    ConnectError string // If set, connecting to the device after this event fails with this message
+}
+
+// simEventJSON is the on-disk form of a SimEvent
+type simEventJSON struct {
+    At               string                `json:"at"` // Offset such as "1.5s"
+    Address          string                `json:"address"`
+    LocalName        string                `json:"name"`
+    RSSI             int16                 `json:"rssi"`
+    ServiceUUIDs     []string              `json:"services"`
+    ManufacturerData []simManufacturerJSON `json:"manufacturer,omitempty"`
+    ServiceData      []simServiceDataJSON  `json:"service_data,omitempty"`
+    ConnectError     string                `json:"connect_error,omitempty"`
+}
+
+type simManufacturerJSON struct {
+    CompanyID uint16 `json:"company_id"`
+    Data      string `json:"data"` // Hex encoded payload
+}
+
+type simServiceDataJSON struct {
+    UUID string `json:"uuid"`
+    Data string `json:"data"` // Hex encoded payload
+}
+
+// SimRadio is an in-memory Radio that replays a scripted advertisement timeline.
+// Scan returns once every due event has been delivered, so the scan loop keeps
+// cycling (and checking timeouts) after the script ends.
+type SimRadio struct {
+    events []SimEvent
+
+    mu      sync.Mutex
+    start   time.Time           // When the first scan started
+    next    int                 // Index of the next event to deliver
+    devices map[string]SimEvent // MAC address -> most recent event
+    stop    chan struct{}       // Closed by StopScan
+}
+
+// NewSimRadio creates a simulated radio from a list of events
+func NewSimRadio(events []SimEvent) *SimRadio {
+    sorted := append([]SimEvent(nil), events...)
+    sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].At < sorted[j].At })
+    return &SimRadio{
+        events:  sorted,
+        devices: make(map[string]SimEvent),
+    }
+}
+
+// LoadSimScript reads a JSON array of scripted advertisements
+func LoadSimScript(path string) ([]SimEvent, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read simulation script: %v", err)
+    }
+
+    var raw []simEventJSON
+    if err := json.Unmarshal(data, &raw); err != nil {
+        return nil, fmt.Errorf("failed to parse simulation script: %v", err)
+    }
+
+    events := make([]SimEvent, 0, len(raw))
+    for i, r := range raw {
+        at, err := time.ParseDuration(r.At)
+        if err != nil {
+            return nil, fmt.Errorf("event %d: invalid offset %q: %v", i, r.At, err)
+        }
+        if r.Address == "" {
+            return nil, fmt.Errorf("event %d: address is required", i)
+        }
+        var manufacturerData []ManufacturerData
+        for _, m := range r.ManufacturerData {
+            data, err := hex.DecodeString(m.Data)
+            if err != nil {
+                return nil, fmt.Errorf("event %d: invalid manufacturer data %q: %v", i, m.Data, err)
+            }
+            manufacturerData = append(manufacturerData, ManufacturerData{CompanyID: m.CompanyID, Data: data})
+        }
+        var serviceData []ServiceData
+        for _, d := range r.ServiceData {
+            data, err := hex.DecodeString(d.Data)
+            if err != nil {
+                return nil, fmt.Errorf("event %d: invalid service data %q: %v", i, d.Data, err)
+            }
+            serviceData = append(serviceData, ServiceData{UUID: d.UUID, Data: data})
+        }
+        events = append(events, SimEvent{
+            At: at,
+            Advertisement: Advertisement{
+                Address:          r.Address,
+                LocalName:        r.LocalName,
+                RSSI:             r.RSSI,
+                ServiceUUIDs:     r.ServiceUUIDs,
+                ManufacturerData: manufacturerData,
+                ServiceData:      serviceData,
+            },
+            ConnectError: r.ConnectError,
+        })
+    }
+    return events, nil
+}
+
+// Scan delivers scripted events as their time comes, returning when the
+// script has no more events or StopScan is called
+func (r *SimRadio) Scan(callback func(Advertisement)) error {
+    r.mu.Lock()
+    if r.stop != nil {
+        r.mu.Unlock()
+        return fmt.Errorf("scan already in progress")
+    }
+    if r.start.IsZero() {
+        r.start = time.Now()
+    }
+    stop := make(chan struct{})
+    r.stop = stop
+    r.mu.Unlock()
+
+    defer func() {
+        r.mu.Lock()
+        r.stop = nil
+        r.mu.Unlock()
+    }()
+
+    for {
+        r.mu.Lock()
+        if r.next >= len(r.events) {
+            r.mu.Unlock()
+            return nil
+        }
+        event := r.events[r.next]
+        wait := time.Until(r.start.Add(event.At))
+        r.mu.Unlock()
+
+        if wait > 0 {
+            timer := time.NewTimer(wait)
+            select {
+            case <-stop:
+                timer.Stop()
+                return nil
+            case <-timer.C:
+            }
+        }
+
+        r.mu.Lock()
+        r.next++
+        r.devices[event.Address] = event
+        r.mu.Unlock()
+
+        callback(event.Advertisement)
+    }
+}
+
+// StopScan interrupts a running Scan
+func (r *SimRadio) StopScan() error {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.stop == nil {
+        return fmt.Errorf("no scan in progress")
+    }
+    close(r.stop)
+    r.stop = nil
+    return nil
+}
+
+// Connect returns a peripheral exposing the services of the device's last advertisement
+func (r *SimRadio) Connect(macAddress string) (Peripheral, error) {
+    r.mu.Lock()
+    event, exists := r.devices[macAddress]
+    r.mu.Unlock()
+
+    if !exists {
+        return nil, fmt.Errorf("device %s has not been seen by the scanner", macAddress)
+    }
+    if event.ConnectError != "" {
+        return nil, fmt.Errorf("%s", event.ConnectError)
+    }
+    return &simPeripheral{services: event.ServiceUUIDs}, nil
+}
+
+type simPeripheral struct {
+    services []string
+}
+
+func (p *simPeripheral) DiscoverServices() ([]string, error) {
+    // A real GATT server always exposes the Generic Attribute service as well
+    return append([]string{genericAttributeUUID}, p.services...), nil
+}
+
+func (p *simPeripheral) Disconnect() error {
+    return nil
+}
@@ -0,0 +1,113 @@
+package ble
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+// Trace record event types
+const (
+    TraceScanStart     = "scan_start"    // A scan window started
+    TraceSweep         = "sweep"         // Timeouts were checked and a device timed out
+    TraceAdvertisement = "advertisement" // A device identified by a beacon or advertised UUID, or a named device that was probed
+)
+
+// TraceRecord is one line of a JSON Lines scan trace
+type TraceRecord struct {
+    Time         time.Time `json:"time"`
+    Event        string    `json:"event"`
+    Address      string    `json:"address,omitempty"`
+    LocalName    string    `json:"name,omitempty"`
+    RSSI         int16     `json:"rssi,omitempty"`
+    Services     []string  `json:"services,omitempty"`      // Services discovered after connecting, or advertised UUIDs in passive mode
+    ConnectError string    `json:"connect_error,omitempty"` // Connect or service discovery failure
+}
+
+// TraceWriter appends trace records to a file
+type TraceWriter struct {
+    mu   sync.Mutex
+    file *os.File
+    enc  *json.Encoder
+    last time.Time // Time of the last record written
+}
+
+var (
+    traceMu sync.Mutex
+    trace   *TraceWriter // Active trace, nil when recording is off
+)
+
+// StartTrace records the scan results RestartScan acts on, and its sweeps, to the given file
+func StartTrace(path string) error {
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to open trace file: %v", err)
+    }
+    traceMu.Lock()
+    trace = &TraceWriter{file: file, enc: json.NewEncoder(file)}
+    traceMu.Unlock()
+    return nil
+}
+
+// StopTrace closes the active trace; records made after it are dropped
+func StopTrace() error {
+    traceMu.Lock()
+    defer traceMu.Unlock()
+    if trace == nil {
+        return nil
+    }
+    err := trace.Close()
+    trace = nil
+    return err
+}
+
+// Write one record to the trace. Records come from the scan and sweep
+// goroutines, so a record stamped just before the previous one is moved
+// up to its time to keep the trace in order for Replay.
+func (w *TraceWriter) Write(record TraceRecord) error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if record.Time.Before(w.last) {
+        record.Time = w.last
+    }
+    w.last = record.Time
+    return w.enc.Encode(record)
+}
+
+// Close the trace file
+func (w *TraceWriter) Close() error {
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    return w.file.Close()
+}
+
+// Record to the active trace, if any
+func recordTrace(record TraceRecord) {
+    traceMu.Lock()
+    defer traceMu.Unlock()
+    if trace == nil {
+        return
+    }
+    if err := trace.Write(record); err != nil {
+        log.Printf("Failed to write trace record: %v", err)
+    }
+}
+
+// Build the trace record for a probed advertisement
+func newAdvertisementRecord(t time.Time, result Advertisement, services []string, connectErr error) TraceRecord {
+    record := TraceRecord{
+        Time:      t,
+        Event:     TraceAdvertisement,
+        Address:   result.Address,
+        LocalName: result.LocalName,
+        RSSI:      result.RSSI,
+        Services:  services,
+    }
+    if connectErr != nil {
+        record.ConnectError = connectErr.Error()
+    }
+    return record
+}
@@ -0,0 +1,33 @@
+package ble
+
+import (
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+)
+
+// Run with -race: the scan may still be recording when the trace is stopped
+func TestStopTraceWhileRecording(t *testing.T) {
+    if err := StartTrace(filepath.Join(t.TempDir(), "trace.jsonl")); err != nil {
+        t.Fatal(err)
+    }
+
+    var wg sync.WaitGroup
+    for w := 0; w < 4; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < 200; i++ {
+                recordTrace(TraceRecord{Time: time.Now(), Event: TraceScanStart})
+            }
+        }()
+    }
+    if err := StopTrace(); err != nil {
+        t.Error(err)
+    }
+    wg.Wait()
+    if err := StopTrace(); err != nil {
+        t.Errorf("second StopTrace: %v", err)
+    }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "time"
+    "ble-gateway/ble"
+    "ble-gateway/config"
+    "ble-gateway/db"
+)
+
+// calibrate subcommand: gateway calibrate -uuid <uuid> -distance <meters>
+func runCalibrate(args []string) error {
+    fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: ble-gateway calibrate -uuid <uuid> -distance <meters> [flags]")
+        fmt.Fprintln(fs.Output(), "Records RSSI samples of a device held at a known distance and stores the fitted path-loss constants.")
+        fmt.Fprintln(fs.Output(), "Run it at two or more distances to fit the path loss exponent as well as the 1 m power.")
+        fs.PrintDefaults()
+    }
+    cfg, err := loadConfig(fs, args)
+    if err != nil {
+        return err
+    }
+    fs.StringVar(&cfg.Database, "db", cfg.Database, "path of the gateway database")
+    scanFlags(fs, &cfg.Scan)
+    if err := config.ApplyEnv(fs); err != nil {
+        return err
+    }
+    uuid := fs.String("uuid", "", "UUID of the device being calibrated")
+    distance := fs.Float64("distance", 1, "distance between the device and the gateway in meters")
+    samples := fs.Int("samples", 30, "number of advertisements to record")
+    timeout := fs.Duration("timeout", 2*time.Minute, "give up if the samples are not collected in time")
+    simScript := fs.String("simulate", "", "read advertisements from a JSON script instead of using the BLE adapter")
+    fs.Parse(args)
+
+    if *uuid == "" || fs.NArg() != 0 {
+        fs.Usage()
+        os.Exit(2)
+    }
+    if err := cfg.Scan.Validate(); err != nil {
+        return fmt.Errorf("invalid configuration: %v", err)
+    }
+
+    database, err := db.Open(cfg.Database)
+    if err != nil {
+        return err
+    }
+    defer database.Close()
+
+    radio := newRadio(*simScript)
+    fmt.Printf("Recording %d samples of %s at %.2f m...\n", *samples, *uuid, *distance)
+
+    calibration, err := ble.Calibrate(db.NewStore(database), radio, cfg.Scan, *uuid, *distance, *samples, *timeout)
+    if err != nil {
+        return err
+    }
+    fmt.Printf("Calibration saved: tx_power %.1f dBm, path_loss_exponent %.2f\n", calibration.TxPower, calibration.PathLossExponent)
+    return nil
+}
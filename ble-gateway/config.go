@@ -0,0 +1,111 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+    "ble-gateway/handler"
+    pb "ble-gateway/proto"
+)
+
+// config subcommand: gateway config <check|reload> [flags]
+func runConfig(args []string) error {
+    usage := func(output io.Writer) {
+        fmt.Fprintln(output, "Usage: ble-gateway config <action> [flags]")
+        fmt.Fprintln(output, "Actions:")
+        fmt.Fprintln(output, "  check    load the configuration the gateway would run with from the file, BLE_GATEWAY_*")
+        fmt.Fprintln(output, "           environment variables and flags, validate it, and print the result")
+        fmt.Fprintln(output, "  reload   make a running gateway apply its configuration again through its AdminService")
+    }
+    if len(args) == 0 || (args[0] != "check" && args[0] != "reload") {
+        usage(os.Stderr)
+        os.Exit(2)
+    }
+
+    fs := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+    fs.Usage = func() {
+        usage(fs.Output())
+        fmt.Fprintln(fs.Output(), "Flags:")
+        fs.PrintDefaults()
+    }
+    if args[0] == "reload" {
+        return reloadConfig(fs, args[1:])
+    }
+    return checkConfig(fs, args[1:])
+}
+
+// Validate the configuration and print it
+func checkConfig(fs *flag.FlagSet, args []string) error {
+    cfg, err := readGatewayConfig(fs, args)
+    if err != nil {
+        return err
+    }
+    fs.Parse(args)
+    if fs.NArg() != 0 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    if err := cfg.Validate(); err != nil {
+        return err
+    }
+    if err := cfg.CheckFiles(); err != nil {
+        return err
+    }
+
+    out, err := cfg.YAML()
+    if err != nil {
+        return err
+    }
+    fmt.Print(string(out))
+    fmt.Println("Configuration OK.")
+    return nil
+}
+
+// Ask a running gateway to reload its configuration
+func reloadConfig(fs *flag.FlagSet, args []string) error {
+    address := fs.String("addr", handler.DefaultGatewayAddress, "address of the gateway's gRPC server")
+    secretFile := fs.String("auth-secret-file", "", "file holding the shared secret that signs the call")
+    tlsConfig := &handler.TLSConfig{}
+    upstreamTLSFlags(fs, tlsConfig)
+    fs.Parse(args)
+    if fs.NArg() != 0 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    cfg := handler.ClientConfig{Address: *address, TLS: *tlsConfig, Identity: handler.DefaultIdentity()}
+    if *secretFile != "" {
+        secret, err := handler.LoadSecret(*secretFile)
+        if err != nil {
+            return err
+        }
+        cfg.Secret = secret
+    }
+    client, conn, err := handler.DialAdmin(cfg)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+    res, err := client.ReloadConfig(ctx, &pb.ReloadConfigRequest{})
+    if err != nil {
+        return err
+    }
+
+    if len(res.Applied) == 0 {
+        fmt.Println("Configuration reloaded, no changes applied.")
+    } else {
+        fmt.Printf("Configuration reloaded, applied: %s\n", strings.Join(res.Applied, ", "))
+    }
+    if len(res.RestartRequired) > 0 {
+        fmt.Printf("Take effect after a restart: %s\n", strings.Join(res.RestartRequired, ", "))
+    }
+    return nil
+}
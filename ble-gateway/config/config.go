@@ -0,0 +1,250 @@
+package config
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "reflect"
+    "strings"
+    "time"
+    "gopkg.in/yaml.v3"
+    "ble-gateway/ble"
+    "ble-gateway/db"
+    "ble-gateway/handler"
+)
+
+// Environment variable naming the configuration file
+const PathEnv = "BLE_GATEWAY_CONFIG"
+
+// Prefix of the environment variables that override flags: -enter-rssi is BLE_GATEWAY_ENTER_RSSI
+const EnvPrefix = "BLE_GATEWAY_"
+
+// Config is everything a gateway can be configured with. Values come from
+// the defaults, then the YAML file, then BLE_GATEWAY_* environment
+// variables, then command line flags.
+type Config struct {
+    Database       string           `yaml:"database"`         // SQLite database path
+    Server         Server           `yaml:"server"`           // Connection to the BALogin server
+    Listen         Listen           `yaml:"listen"`           // The gateway's own gRPC server
+    Identity       handler.Identity `yaml:"identity"`         // Reported with every status update
+    AuthSecretFile string           `yaml:"auth_secret_file"` // Shared HMAC secret; no signing if empty
+    Scan           ble.Config       `yaml:"scan"`
+
+    WhitelistCheck   time.Duration `yaml:"whitelist_check"`   // 0: never
+    SessionRetention time.Duration `yaml:"session_retention"` // 0: keep forever
+    ShutdownTimeout  time.Duration `yaml:"shutdown_timeout"`  // Time allowed to log out devices and stop on SIGINT/SIGTERM
+
+    // Devices logged in when the gateway crashed are restored and logged out
+    // unless seen again within RestoreGrace; their last sighting is saved
+    // every CheckpointInterval
+    RestoreGrace       time.Duration `yaml:"restore_grace"`
+    CheckpointInterval time.Duration `yaml:"checkpoint_interval"`
+}
+
+// Server is the connection to the BALogin server
+type Server struct {
+    Address   string            `yaml:"address"`
+    TLS       handler.TLSConfig `yaml:"tls"`
+    Stream    bool              `yaml:"stream"`    // Keep a presence stream open
+    Heartbeat time.Duration     `yaml:"heartbeat"` // Interval between heartbeats on the stream
+    Snapshot  bool              `yaml:"snapshot"`  // Send the full presence state once it is reconciled on startup
+}
+
+// Listen is the gateway's own gRPC server
+type Listen struct {
+    Address string            `yaml:"address"`
+    TLS     handler.TLSConfig `yaml:"tls"`
+
+    // Allocate UUIDs for unauthenticated callers when no auth secret or client CA is set
+    AllowUnauthenticated bool `yaml:"allow_unauthenticated"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() Config {
+    return Config{
+        Database: db.DefaultPath,
+        Server: Server{
+            Address:   handler.DefaultServerAddress,
+            Stream:    true,
+            Heartbeat: handler.DefaultHeartbeatInterval,
+        },
+        Listen:           Listen{Address: handler.DefaultListenAddress},
+        Identity:         handler.DefaultIdentity(),
+        Scan:             ble.DefaultConfig(),
+        WhitelistCheck:   5 * time.Minute,
+        SessionRetention: 90 * 24 * time.Hour,
+        ShutdownTimeout:  10 * time.Second,
+
+        RestoreGrace:       time.Minute,
+        CheckpointInterval: 10 * time.Second,
+    }
+}
+
+// Load reads a YAML configuration file over the defaults; an empty path gives the defaults.
+// Keys the file leaves out keep their default, and unknown keys are an error.
+func Load(path string) (Config, error) {
+    cfg := Default()
+    if path == "" {
+        return cfg, nil
+    }
+
+    file, err := os.Open(path)
+    if err != nil {
+        return Config{}, fmt.Errorf("failed to open config: %v", err)
+    }
+    defer file.Close()
+
+    decoder := yaml.NewDecoder(file)
+    decoder.KnownFields(true)
+    if err := decoder.Decode(&cfg); err != nil && err != io.EOF {
+        return Config{}, fmt.Errorf("invalid config %s: %v", path, err)
+    }
+    return cfg, nil
+}
+
+// Validate checks every setting and reports all problems at once, each
+// prefixed with its key in the configuration file
+func (c Config) Validate() error {
+    var problems []error
+    check := func(key string, err error) {
+        if err != nil {
+            problems = append(problems, fmt.Errorf("%s: %v", key, err))
+        }
+    }
+
+    if c.Database == "" {
+        check("database", errors.New("must not be empty"))
+    }
+    if c.Server.Address == "" {
+        check("server.address", errors.New("must not be empty"))
+    }
+    check("server.tls", c.Server.TLS.Validate())
+    if c.Server.Stream && c.Server.Heartbeat <= 0 {
+        check("server.heartbeat", fmt.Errorf("must be positive, got %s", c.Server.Heartbeat))
+    }
+    if c.Listen.Address == "" {
+        check("listen.address", errors.New("must not be empty"))
+    }
+    check("listen.tls", c.Listen.TLS.Validate())
+    if c.Listen.TLS.CAFile != "" && c.Listen.TLS.CertFile == "" {
+        check("listen.tls", errors.New("a client CA needs the server's own certificate and key"))
+    }
+    if c.Identity.ID == "" {
+        check("identity.id", errors.New("must not be empty"))
+    }
+    check("scan", c.Scan.Validate())
+    if c.WhitelistCheck < 0 {
+        check("whitelist_check", fmt.Errorf("must not be negative, got %s", c.WhitelistCheck))
+    }
+    if c.SessionRetention < 0 {
+        check("session_retention", fmt.Errorf("must not be negative, got %s", c.SessionRetention))
+    }
+    if c.ShutdownTimeout <= 0 {
+        check("shutdown_timeout", fmt.Errorf("must be positive, got %s", c.ShutdownTimeout))
+    }
+    if c.RestoreGrace < 0 {
+        check("restore_grace", fmt.Errorf("must not be negative, got %s", c.RestoreGrace))
+    }
+    if c.CheckpointInterval <= 0 {
+        check("checkpoint_interval", fmt.Errorf("must be positive, got %s", c.CheckpointInterval))
+    }
+    return errors.Join(problems...)
+}
+
+// CheckFiles reports files named by the configuration that cannot be read
+func (c Config) CheckFiles() error {
+    var problems []error
+    readable := func(key string, path string) {
+        if path == "" {
+            return
+        }
+        if file, err := os.Open(path); err != nil {
+            problems = append(problems, fmt.Errorf("%s: %v", key, err))
+        } else {
+            file.Close()
+        }
+    }
+
+    readable("server.tls.cert_file", c.Server.TLS.CertFile)
+    readable("server.tls.key_file", c.Server.TLS.KeyFile)
+    readable("server.tls.ca_file", c.Server.TLS.CAFile)
+    readable("listen.tls.cert_file", c.Listen.TLS.CertFile)
+    readable("listen.tls.key_file", c.Listen.TLS.KeyFile)
+    readable("listen.tls.ca_file", c.Listen.TLS.CAFile)
+    if c.AuthSecretFile != "" {
+        if _, err := handler.LoadSecret(c.AuthSecretFile); err != nil {
+            problems = append(problems, fmt.Errorf("auth_secret_file: %v", err))
+        }
+    }
+    return errors.Join(problems...)
+}
+
+// PathFromArgs finds the -config flag in args before they are parsed, so the
+// file can supply the defaults of the other flags; PathEnv is used otherwise
+func PathFromArgs(args []string) string {
+    for i, arg := range args {
+        if arg == "--" {
+            break
+        }
+        name := strings.TrimLeft(arg, "-")
+        if !strings.HasPrefix(arg, "-") || (name != "config" && !strings.HasPrefix(name, "config=")) {
+            continue
+        }
+        if value, found := strings.CutPrefix(name, "config="); found {
+            return value
+        }
+        if i+1 < len(args) {
+            return args[i+1]
+        }
+    }
+    return os.Getenv(PathEnv)
+}
+
+// ApplyEnv sets every flag of fs that has a BLE_GATEWAY_* environment variable.
+// Call it before fs.Parse, so the command line still wins.
+func ApplyEnv(fs *flag.FlagSet) error {
+    var problems []error
+    fs.VisitAll(func(f *flag.Flag) {
+        name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+        value, set := os.LookupEnv(name)
+        if !set {
+            return
+        }
+        if err := fs.Set(f.Name, value); err != nil {
+            problems = append(problems, fmt.Errorf("%s=%q is not a valid -%s: %v", name, value, f.Name, err))
+        }
+    })
+    return errors.Join(problems...)
+}
+
+// YAML renders the configuration as a file Load accepts
+func (c Config) YAML() ([]byte, error) {
+    return yaml.Marshal(c)
+}
+
+// Diff returns the keys of the settings that differ between two configurations,
+// e.g. "scan.enter_rssi", in the order of the file
+func Diff(old Config, next Config) []string {
+    return diff("", reflect.ValueOf(old), reflect.ValueOf(next))
+}
+
+func diff(prefix string, old reflect.Value, next reflect.Value) []string {
+    var keys []string
+    for i := 0; i < old.NumField(); i++ {
+        key, _, _ := strings.Cut(old.Type().Field(i).Tag.Get("yaml"), ",")
+        if key == "" || key == "-" {
+            continue
+        }
+        key = prefix + key
+
+        a, b := old.Field(i), next.Field(i)
+        if a.Kind() == reflect.Struct {
+            keys = append(keys, diff(key+".", a, b)...)
+        } else if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+            keys = append(keys, key)
+        }
+    }
+    return keys
+}
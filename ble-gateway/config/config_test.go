@@ -0,0 +1,275 @@
+package config
+
+import (
+    "flag"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+    "ble-gateway/ble"
+)
+
+func writeFile(t *testing.T, content string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "gateway.yaml")
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestLoad(t *testing.T) {
+    tests := []struct {
+        name    string
+        content string
+        wantErr string         // Part of the error, if loading fails
+        want    func(*Config) // Changes to the defaults expected after loading
+    }{
+        {name: "empty file", content: "", want: func(*Config) {}},
+        {
+            name: "keys left out keep their default",
+            content: `
+server:
+  address: balogin:50051
+scan:
+  enter_rssi: -70
+  timeout: 45s
+session_retention: 0s
+`,
+            want: func(c *Config) {
+                c.Server.Address = "balogin:50051"
+                c.Scan.EnterRSSI = -70
+                c.Scan.Timeout = 45 * time.Second
+                c.SessionRetention = 0
+            },
+        },
+        {
+            name: "nested sections",
+            content: `
+listen:
+  tls:
+    cert_file: server.pem
+    key_file: server.key
+identity:
+  id: lobby-1
+scan:
+  ignored_services: []
+  default_calibration:
+    tx_power: -65
+`,
+            want: func(c *Config) {
+                c.Listen.TLS.CertFile = "server.pem"
+                c.Listen.TLS.KeyFile = "server.key"
+                c.Identity.ID = "lobby-1"
+                c.Scan.IgnoredServices = []string{}
+                c.Scan.DefaultCalibration.TxPower = -65
+            },
+        },
+        {name: "unknown top level key", content: "databse: ble.db\n", wantErr: "field databse not found"},
+        {name: "unknown nested key", content: "scan:\n  enter_rsi: -70\n", wantErr: "field enter_rsi not found"},
+        {name: "invalid duration", content: "scan:\n  timeout: soon\n", wantErr: "invalid config"},
+        {name: "wrong type", content: "scan:\n  enter_rssi: loud\n", wantErr: "invalid config"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            cfg, err := Load(writeFile(t, tt.content))
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("Load failed: %v", err)
+            }
+            want := Default()
+            tt.want(&want)
+            if !reflect.DeepEqual(cfg, want) {
+                t.Errorf("Load =\n%+v\nwant\n%+v", cfg, want)
+            }
+        })
+    }
+}
+
+func TestLoadWithoutFile(t *testing.T) {
+    cfg, err := Load("")
+    if err != nil || !reflect.DeepEqual(cfg, Default()) {
+        t.Errorf("Load(\"\") = %+v, %v, want the defaults", cfg, err)
+    }
+    if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+        t.Error("missing config file loaded")
+    }
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+    want := Default()
+    want.Scan.Mode = ble.ModePassive
+    want.Listen.TLS.CAFile = "ca.pem"
+    out, err := want.YAML()
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := Load(writeFile(t, string(out)))
+    if err != nil {
+        t.Fatalf("Load of rendered config failed: %v", err)
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("round trip =\n%+v\nwant\n%+v", got, want)
+    }
+}
+
+func TestValidate(t *testing.T) {
+    if err := Default().Validate(); err != nil {
+        t.Fatalf("defaults are invalid: %v", err)
+    }
+
+    tests := []struct {
+        key    string // Key the error must start with
+        change func(*Config)
+    }{
+        {"database", func(c *Config) { c.Database = "" }},
+        {"server.address", func(c *Config) { c.Server.Address = "" }},
+        {"server.tls", func(c *Config) { c.Server.TLS.CertFile = "client.pem" }},
+        {"server.heartbeat", func(c *Config) { c.Server.Heartbeat = 0 }},
+        {"listen.address", func(c *Config) { c.Listen.Address = "" }},
+        {"listen.tls", func(c *Config) { c.Listen.TLS.KeyFile = "server.key" }},
+        {"listen.tls", func(c *Config) { c.Listen.TLS.CAFile = "ca.pem" }},
+        {"identity.id", func(c *Config) { c.Identity.ID = "" }},
+        {"scan", func(c *Config) { c.Scan.Mode = "active" }},
+        {"scan", func(c *Config) { c.Scan.ExitRSSI = c.Scan.EnterRSSI + 1 }},
+        {"whitelist_check", func(c *Config) { c.WhitelistCheck = -time.Second }},
+        {"session_retention", func(c *Config) { c.SessionRetention = -time.Second }},
+        {"shutdown_timeout", func(c *Config) { c.ShutdownTimeout = 0 }},
+        {"restore_grace", func(c *Config) { c.RestoreGrace = -time.Second }},
+        {"checkpoint_interval", func(c *Config) { c.CheckpointInterval = 0 }},
+    }
+    for _, tt := range tests {
+        cfg := Default()
+        tt.change(&cfg)
+        err := cfg.Validate()
+        if err == nil || !strings.HasPrefix(err.Error(), tt.key+": ") {
+            t.Errorf("Validate of an invalid %s = %v, want an error prefixed %q", tt.key, err, tt.key+": ")
+        }
+    }
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+    cfg := Default()
+    cfg.Database = ""
+    cfg.Identity.ID = ""
+    cfg.ShutdownTimeout = 0
+
+    err := cfg.Validate()
+    if err == nil {
+        t.Fatal("invalid config accepted")
+    }
+    lines := strings.Split(err.Error(), "\n")
+    if len(lines) != 3 || !strings.HasPrefix(lines[0], "database:") || !strings.HasPrefix(lines[1], "identity.id:") || !strings.HasPrefix(lines[2], "shutdown_timeout:") {
+        t.Errorf("Validate = %q, want one line each for database, identity.id and shutdown_timeout", err)
+    }
+}
+
+func TestApplyEnv(t *testing.T) {
+    tests := []struct {
+        name    string
+        env     map[string]string
+        wantErr bool
+        rssi    int
+        mode    string
+    }{
+        {name: "unset", rssi: -88, mode: "connect"},
+        {name: "set", env: map[string]string{"BLE_GATEWAY_ENTER_RSSI": "-70", "BLE_GATEWAY_MODE": "passive"}, rssi: -70, mode: "passive"},
+        {name: "other prefix ignored", env: map[string]string{"ENTER_RSSI": "-70", "BLE_GATEWAY_ENTER-RSSI": "-60"}, rssi: -88, mode: "connect"},
+        {name: "invalid value", env: map[string]string{"BLE_GATEWAY_ENTER_RSSI": "loud"}, wantErr: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            for name, value := range tt.env {
+                t.Setenv(name, value)
+            }
+            var rssi int
+            var mode string
+            fs := flag.NewFlagSet("test", flag.ContinueOnError)
+            fs.IntVar(&rssi, "enter-rssi", -88, "")
+            fs.StringVar(&mode, "mode", "connect", "")
+
+            err := ApplyEnv(fs)
+            if tt.wantErr {
+                if err == nil || !strings.Contains(err.Error(), "BLE_GATEWAY_ENTER_RSSI") {
+                    t.Errorf("ApplyEnv error = %v, want one naming BLE_GATEWAY_ENTER_RSSI", err)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("ApplyEnv failed: %v", err)
+            }
+            if rssi != tt.rssi || mode != tt.mode {
+                t.Errorf("ApplyEnv set enter-rssi %d and mode %q, want %d and %q", rssi, mode, tt.rssi, tt.mode)
+            }
+        })
+    }
+}
+
+func TestPathFromArgs(t *testing.T) {
+    tests := []struct {
+        args []string
+        env  string
+        want string
+    }{
+        {nil, "", ""},
+        {nil, "env.yaml", "env.yaml"},
+        {[]string{"-config", "a.yaml"}, "env.yaml", "a.yaml"},
+        {[]string{"--config", "a.yaml"}, "", "a.yaml"},
+        {[]string{"-config=a.yaml"}, "", "a.yaml"},
+        {[]string{"--config=a.yaml"}, "", "a.yaml"},
+        {[]string{"-enter-rssi", "-70", "-config", "a.yaml", "-mode", "passive"}, "", "a.yaml"},
+        {[]string{"-configure", "a.yaml"}, "", ""},
+        {[]string{"config", "a.yaml"}, "", ""},
+        {[]string{"--", "-config", "a.yaml"}, "env.yaml", "env.yaml"},
+        {[]string{"-config"}, "env.yaml", "env.yaml"},
+    }
+    for _, tt := range tests {
+        t.Setenv(PathEnv, tt.env)
+        if got := PathFromArgs(tt.args); got != tt.want {
+            t.Errorf("PathFromArgs(%q) with %s=%q = %q, want %q", tt.args, PathEnv, tt.env, got, tt.want)
+        }
+    }
+}
+
+// reload.go decides what a running gateway applies by these key names
+func TestDiff(t *testing.T) {
+    tests := []struct {
+        change func(*Config)
+        want   []string
+    }{
+        {func(*Config) {}, nil},
+        {func(c *Config) { c.Database = "other.db" }, []string{"database"}},
+        {func(c *Config) { c.Server.Address = "balogin:50051" }, []string{"server.address"}},
+        {func(c *Config) { c.Server.TLS.CAFile = "ca.pem" }, []string{"server.tls.ca_file"}},
+        {func(c *Config) { c.Server.Heartbeat = time.Minute }, []string{"server.heartbeat"}},
+        {func(c *Config) { c.Listen.TLS.CertFile = "server.pem" }, []string{"listen.tls.cert_file"}},
+        {func(c *Config) { c.Identity.Site = "city hall" }, []string{"identity.site"}},
+        {func(c *Config) { c.AuthSecretFile = "secret" }, []string{"auth_secret_file"}},
+        {func(c *Config) { c.Scan.EnterRSSI = -70 }, []string{"scan.enter_rssi"}},
+        {func(c *Config) { c.Scan.IgnoredServices = nil }, []string{"scan.ignored_services"}},
+        {func(c *Config) { c.Scan.DefaultCalibration.TxPower = -65 }, []string{"scan.default_calibration.tx_power"}},
+        {func(c *Config) { c.RestoreGrace = time.Hour }, []string{"restore_grace"}},
+        {
+            func(c *Config) {
+                c.WhitelistCheck = 0
+                c.Scan.Mode = ble.ModePassive
+                c.Server.Address = "balogin:50051"
+            },
+            []string{"server.address", "scan.mode", "whitelist_check"}, // In the order of the file
+        },
+    }
+    for _, tt := range tests {
+        next := Default()
+        tt.change(&next)
+        if got := Diff(Default(), next); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("Diff = %q, want %q", got, tt.want)
+        }
+    }
+}
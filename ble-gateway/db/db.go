@@ -3,61 +3,92 @@ package db
 import (
     "database/sql"
     "fmt"
-    _ "github.com/mattn/go-sqlite3" // SQLite3 driver
+    "time"
+    "ble-gateway/store"
 )
 
-// Open SQLite database
-func openDB() (*sql.DB, error) {
-    db, err := sql.Open("sqlite3", "./ble.db")
+// Find the UUID already allocated for a request key ("" if none)
+func findAllocation(tx *sql.Tx, requestKey string) (string, error) {
+    var uuid string
+    query := `SELECT uuid FROM uuid_allocations WHERE request_key = ?`
+    err := tx.QueryRow(query, requestKey).Scan(&uuid)
+    if err == sql.ErrNoRows {
+        return "", nil
+    }
     if err != nil {
-        return nil, fmt.Errorf("failed to open database: %v", err)
+        return "", fmt.Errorf("failed to look up allocation: %v", err)
     }
-    return db, nil
+    return uuid, nil
 }
 
-// Find UUID with is_active set to 0
-func findInactiveUUID(db *sql.DB) (string, error) {
+// Find UUID with is_active set to 0 that has not been revoked
+func findInactiveUUID(tx *sql.Tx) (string, error) {
     var uuid string
-    query := `SELECT uuid FROM devices WHERE is_active = 0 LIMIT 1`
-    err := db.QueryRow(query).Scan(&uuid)
+    query := `SELECT uuid FROM devices WHERE is_active = 0 AND revoked_at IS NULL LIMIT 1`
+    err := tx.QueryRow(query).Scan(&uuid)
     if err != nil {
         if err == sql.ErrNoRows {
-            return "", fmt.Errorf("There are not enough devices available.")
+            return "", store.ErrExhausted
         }
-        return "", fmt.Errorf("%v", err)
+        return "", fmt.Errorf("failed to find an inactive UUID: %v", err)
     }
     return uuid, nil
 }
 
-// Update is_active value of the UUID to 1
-func updateUUIDStatusToActive(db *sql.DB, uuid string) error {
-    query := `UPDATE devices SET is_active = 1 WHERE uuid = ?`
-    _, err := db.Exec(query, uuid)
+// Update is_active value of the UUID to 1, failing if it is already active
+func updateUUIDStatusToActive(tx *sql.Tx, uuid string) error {
+    query := `UPDATE devices SET is_active = 1 WHERE uuid = ? AND is_active = 0`
+    result, err := tx.Exec(query, uuid)
     if err != nil {
         return fmt.Errorf("failed to update UUID status: %v", err)
     }
+    if updated, err := result.RowsAffected(); err != nil || updated != 1 {
+        return fmt.Errorf("UUID %s was activated concurrently", uuid)
+    }
     return nil
 }
 
-// GetAndActivateUUID: Function to find and activate a UUID
-func GetAndActivateUUID() (string, error) {
-    db, err := openDB()
+// AllocateUUID: Function to find and activate a UUID in one transaction.
+// Calls with the same non-empty requestKey return the same UUID.
+func (s *SQLiteStore) AllocateUUID(requestKey string) (string, error) {
+    tx, err := s.db.Begin()
     if err != nil {
-        return "", fmt.Errorf("failed to open database: %v", err)
+        return "", fmt.Errorf("failed to begin transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    // A retried request gets the UUID it was given before
+    if requestKey != "" {
+        uuid, err := findAllocation(tx, requestKey)
+        if err != nil {
+            return "", err
+        }
+        if uuid != "" {
+            return uuid, nil
+        }
     }
-    defer db.Close()
 
     // Find UUID with is_active set to 0
-    uuid, err := findInactiveUUID(db)
+    uuid, err := findInactiveUUID(tx)
     if err != nil {
-        return "", fmt.Errorf("%v", err)
+        return "", fmt.Errorf("failed to allocate UUID: %w", err)
     }
 
     // Update is_active value of the UUID to 1
-    err = updateUUIDStatusToActive(db, uuid)
+    err = updateUUIDStatusToActive(tx, uuid)
     if err != nil {
         return "", fmt.Errorf("Failed to update UUID status to active: %v", err)
     }
 
+    if requestKey != "" {
+        query := `INSERT INTO uuid_allocations (request_key, uuid, allocated_at) VALUES (?, ?, ?)`
+        if _, err := tx.Exec(query, requestKey, uuid, time.Now()); err != nil {
+            return "", fmt.Errorf("failed to record allocation: %v", err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return "", fmt.Errorf("failed to commit allocation: %v", err)
+    }
     return uuid, nil
 }
@@ -0,0 +1,158 @@
+package db
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+    "ble-gateway/store"
+)
+
+// Open a migrated database in a temporary file
+func openTestStore(t *testing.T) *SQLiteStore {
+    t.Helper()
+    database, err := Open(filepath.Join(t.TempDir(), "ble.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { database.Close() })
+    return NewStore(database)
+}
+
+// Open a migrated database that lives in memory
+func openMemoryStore(t *testing.T) *SQLiteStore {
+    t.Helper()
+    database, err := Open(MemoryPath)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { database.Close() })
+    return NewStore(database)
+}
+
+func testUUID(i int) string {
+    return fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+}
+
+func TestAllocateUUIDParallel(t *testing.T) {
+    const devices = 200
+    s := openTestStore(t)
+    var registry []store.Device
+    for i := 0; i < devices; i++ {
+        registry = append(registry, store.Device{UUID: testUUID(i), Name: fmt.Sprintf("sensor %d", i)})
+    }
+    if _, err := s.ImportDevices(registry); err != nil {
+        t.Fatal(err)
+    }
+
+    // Every request key is sent twice at once, as a retried signup would be
+    results := make([][2]string, devices)
+    var wg sync.WaitGroup
+    errs := make(chan error, 2*devices)
+    for i := 0; i < devices; i++ {
+        for attempt := 0; attempt < 2; attempt++ {
+            wg.Add(1)
+            go func(i, attempt int) {
+                defer wg.Done()
+                uuid, err := s.AllocateUUID(fmt.Sprintf("signup-%d", i))
+                if err != nil {
+                    errs <- err
+                    return
+                }
+                results[i][attempt] = uuid
+            }(i, attempt)
+        }
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Fatalf("allocation failed: %v", err)
+    }
+
+    seen := make(map[string]int)
+    for i, uuids := range results {
+        if uuids[0] != uuids[1] {
+            t.Errorf("signup-%d got %s and %s", i, uuids[0], uuids[1])
+        }
+        if other, exists := seen[uuids[0]]; exists {
+            t.Errorf("%s handed to signup-%d and signup-%d", uuids[0], other, i)
+        }
+        seen[uuids[0]] = i
+    }
+    if len(seen) != devices {
+        t.Errorf("%d UUIDs handed out, want %d", len(seen), devices)
+    }
+
+    active, err := s.ListDevices(store.DeviceFilter{State: store.FilterActive})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(active) != devices {
+        t.Errorf("%d active devices, want %d", len(active), devices)
+    }
+}
+
+func TestAllocateUUIDExhausted(t *testing.T) {
+    s := openTestStore(t)
+    if _, err := s.ImportDevices([]store.Device{{UUID: testUUID(1), Name: "sensor"}}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.AllocateUUID("signup-1"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := s.AllocateUUID("signup-2"); !errors.Is(err, store.ErrExhausted) {
+        t.Errorf("error %v, want ErrExhausted", err)
+    }
+}
+
+func TestOpenReadOnly(t *testing.T) {
+    dir := t.TempDir()
+
+    missing := filepath.Join(dir, "missing.db")
+    if _, err := OpenReadOnly(missing); err == nil {
+        t.Error("opened a missing database")
+    }
+    if _, err := os.Stat(missing); !os.IsNotExist(err) {
+        t.Errorf("missing database was created: %v", err)
+    }
+
+    path := filepath.Join(dir, "ble.db")
+    database, err := Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewStore(database).ImportDevices([]store.Device{{UUID: testUUID(1), Name: "sensor"}}); err != nil {
+        t.Fatal(err)
+    }
+    database.Close()
+
+    readOnly, err := OpenReadOnly(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer readOnly.Close()
+    devices := NewStore(readOnly)
+    if _, err := devices.Device(testUUID(1)); err != nil {
+        t.Errorf("read from the read-only database: %v", err)
+    }
+    if _, err := devices.AllocateUUID(""); err == nil {
+        t.Error("allocated a UUID in a read-only database")
+    }
+
+    // A database from an older gateway is not upgraded
+    old := filepath.Join(dir, "old.db")
+    database, err = Open(old)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := database.Exec(`DELETE FROM schema_migrations WHERE version = (SELECT MAX(version) FROM schema_migrations)`); err != nil {
+        t.Fatal(err)
+    }
+    database.Close()
+    if _, err := OpenReadOnly(old); err == nil || !strings.Contains(err.Error(), "schema version") {
+        t.Errorf("opened a database with an old schema: %v", err)
+    }
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+    "database/sql"
+    "embed"
+    "fmt"
+    "path"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    _ "github.com/mattn/go-sqlite3" // SQLite3 driver
+)
+
+// Default database file, relative to the working directory
+const DefaultPath = "ble.db"
+
+// Path of an in-memory database, for tests and dry runs
+const MemoryPath = ":memory:"
+
+// Schema migrations, applied in order of their numeric prefix
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Databases set up by hand or by older gateways may already have a column
+// that a migration adds; such ALTER statements are skipped
+var addColumnPattern = regexp.MustCompile(`(?i)^ALTER TABLE (\w+) ADD COLUMN (\w+)`)
+
+// Open the gateway database, shared by every package for the life of the process.
+// WAL mode lets the scanner read while the outbox and gRPC handlers write, and
+// transactions take the write lock when they begin, waiting up to 5 seconds for it.
+// The path MemoryPath opens a database that lives only as long as the handle.
+func Open(path string) (*sql.DB, error) {
+    db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate")
+    if err != nil {
+        return nil, fmt.Errorf("failed to open database: %v", err)
+    }
+    if path == MemoryPath {
+        // Every connection would get its own empty database
+        db.SetMaxOpenConns(1)
+        db.SetConnMaxLifetime(0)
+        db.SetConnMaxIdleTime(0)
+    }
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to open database %s: %v", path, err)
+    }
+    if err := migrate(db); err != nil {
+        db.Close()
+        return nil, err
+    }
+    return db, nil
+}
+
+// OpenReadOnly opens an existing gateway database without creating, migrating
+// or writing it, for tools that only read the registry
+func OpenReadOnly(path string) (*sql.DB, error) {
+    db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+    if err != nil {
+        return nil, fmt.Errorf("failed to open database: %v", err)
+    }
+    if err := db.Ping(); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("failed to open database %s: %v", path, err)
+    }
+
+    var current int
+    if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+        db.Close()
+        return nil, fmt.Errorf("%s is not a gateway database: %v", path, err)
+    }
+    latest, err := latestVersion()
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    if current < latest {
+        db.Close()
+        return nil, fmt.Errorf("database %s is at schema version %d, start the gateway once to upgrade it to %d", path, current, latest)
+    }
+    return db, nil
+}
+
+// Apply every migration newer than the recorded schema version
+func migrate(db *sql.DB) error {
+    _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMP NOT NULL
+    )`)
+    if err != nil {
+        return fmt.Errorf("failed to create migration table: %v", err)
+    }
+
+    var current int
+    if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+        return fmt.Errorf("failed to read schema version: %v", err)
+    }
+
+    names, err := migrationFiles.ReadDir("migrations")
+    if err != nil {
+        return fmt.Errorf("failed to read migrations: %v", err)
+    }
+    sort.Slice(names, func(i, j int) bool { return names[i].Name() < names[j].Name() })
+
+    for _, entry := range names {
+        name := entry.Name()
+        version, err := migrationVersion(name)
+        if err != nil {
+            return err
+        }
+        if version <= current {
+            continue
+        }
+
+        script, err := migrationFiles.ReadFile(path.Join("migrations", name))
+        if err != nil {
+            return fmt.Errorf("failed to read migration %s: %v", name, err)
+        }
+        if err := applyMigration(db, version, name, string(script)); err != nil {
+            return fmt.Errorf("migration %s failed: %v", name, err)
+        }
+        fmt.Printf("Applied database migration %s.\n", name)
+    }
+    return nil
+}
+
+// Numeric prefix of a migration file name
+func migrationVersion(name string) (int, error) {
+    version, err := strconv.Atoi(strings.SplitN(name, "_", 2)[0])
+    if err != nil {
+        return 0, fmt.Errorf("migration %s has no version prefix", name)
+    }
+    return version, nil
+}
+
+// Schema version after every migration has been applied
+func latestVersion() (int, error) {
+    names, err := migrationFiles.ReadDir("migrations")
+    if err != nil {
+        return 0, fmt.Errorf("failed to read migrations: %v", err)
+    }
+    latest := 0
+    for _, entry := range names {
+        version, err := migrationVersion(entry.Name())
+        if err != nil {
+            return 0, err
+        }
+        if version > latest {
+            latest = version
+        }
+    }
+    return latest, nil
+}
+
+// Run one migration script and record it, in a single transaction
+func applyMigration(db *sql.DB, version int, name string, script string) error {
+    tx, err := db.Begin()
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, statement := range splitStatements(script) {
+        if match := addColumnPattern.FindStringSubmatch(statement); match != nil {
+            exists, err := columnExists(tx, match[1], match[2])
+            if err != nil {
+                return err
+            }
+            if exists {
+                continue
+            }
+        }
+        if _, err := tx.Exec(statement); err != nil {
+            return fmt.Errorf("%v in %q", err, statement)
+        }
+    }
+
+    query := `INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`
+    if _, err := tx.Exec(query, version, name, time.Now()); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// Split a script into statements, dropping "--" comment lines
+func splitStatements(script string) []string {
+    var lines []string
+    for _, line := range strings.Split(script, "\n") {
+        if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+            lines = append(lines, line)
+        }
+    }
+
+    var statements []string
+    for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+        if statement = strings.TrimSpace(statement); statement != "" {
+            statements = append(statements, statement)
+        }
+    }
+    return statements
+}
+
+func columnExists(tx *sql.Tx, table string, column string) (bool, error) {
+    var count int
+    query := `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+    if err := tx.QueryRow(query, table, column).Scan(&count); err != nil {
+        return false, fmt.Errorf("failed to read columns of %s: %v", table, err)
+    }
+    return count > 0, nil
+}
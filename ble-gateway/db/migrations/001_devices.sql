@@ -0,0 +1,7 @@
+-- UUID registry; databases created by hand from the README already have it
+CREATE TABLE IF NOT EXISTS devices (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    device_name TEXT NOT NULL,
+    uuid TEXT NOT NULL UNIQUE,
+    is_active INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,3 @@
+-- iBeacon/Eddystone ID of off-the-shelf beacons
+ALTER TABLE devices ADD COLUMN beacon_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS devices_beacon_id ON devices (beacon_id);
@@ -0,0 +1,12 @@
+-- Distance calibration written by the calibrate subcommand
+ALTER TABLE devices ADD COLUMN tx_power REAL;
+ALTER TABLE devices ADD COLUMN path_loss_exponent REAL;
+
+CREATE TABLE IF NOT EXISTS calibration_samples (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL,
+    distance REAL NOT NULL,
+    rssi REAL NOT NULL,
+    recorded_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS calibration_samples_uuid ON calibration_samples (uuid);
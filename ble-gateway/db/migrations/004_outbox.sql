@@ -0,0 +1,13 @@
+-- Status reports waiting to be sent to the BALogin server
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+ALTER TABLE outbox ADD COLUMN reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN observed_at TIMESTAMP;
+ALTER TABLE outbox ADD COLUMN rssi INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN distance REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- UUIDs handed out by RequestUnusedUUID, by request key
+CREATE TABLE IF NOT EXISTS uuid_allocations (
+    request_key TEXT PRIMARY KEY,
+    uuid TEXT NOT NULL,
+    allocated_at TIMESTAMP NOT NULL
+);
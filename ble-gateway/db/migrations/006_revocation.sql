@@ -0,0 +1,3 @@
+-- Revoked UUIDs are never handed out again
+ALTER TABLE devices ADD COLUMN revoked_at TIMESTAMP;
+ALTER TABLE devices ADD COLUMN revoke_reason TEXT;
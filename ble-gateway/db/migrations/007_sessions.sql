@@ -0,0 +1,15 @@
+-- Presence history: one row per login, closed by the matching logout.
+-- Times are stored in UTC so they compare in order.
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL,
+    mac_address TEXT NOT NULL,
+    gateway TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP,
+    end_reason TEXT,
+    peak_rssi INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_uuid ON sessions (uuid, started_at);
+CREATE INDEX IF NOT EXISTS sessions_started_at ON sessions (started_at);
+CREATE INDEX IF NOT EXISTS sessions_ended_at ON sessions (ended_at);
@@ -0,0 +1,6 @@
+-- Open sessions are the devices a gateway has logged in. The last sighting
+-- is checkpointed so a gateway that crashed can reconcile them on startup.
+-- Existing sessions were last seen, as far as is known, when they started.
+ALTER TABLE sessions ADD COLUMN last_seen TIMESTAMP;
+UPDATE sessions SET last_seen = started_at;
+CREATE INDEX IF NOT EXISTS sessions_open ON sessions (gateway, ended_at);
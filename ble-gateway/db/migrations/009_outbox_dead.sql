@@ -0,0 +1,3 @@
+-- Reports the server rejected for good are kept aside for inspection
+-- instead of blocking the reports queued after them
+ALTER TABLE outbox ADD COLUMN dead INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,143 @@
+package db
+
+import (
+    "database/sql"
+    "fmt"
+    "strings"
+    "time"
+    "ble-gateway/store"
+)
+
+// ImportDevices adds inactive devices in one transaction; nothing is added if any entry fails
+func (s *SQLiteStore) ImportDevices(devices []store.Device) (int, error) {
+    if err := store.ValidateImport(devices); err != nil {
+        return 0, err
+    }
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return 0, fmt.Errorf("failed to begin transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    for i, device := range devices {
+        var exists int
+        err := tx.QueryRow(`SELECT COUNT(*) FROM devices WHERE uuid = ?`, device.UUID).Scan(&exists)
+        if err != nil {
+            return 0, fmt.Errorf("failed to check UUID %s: %v", device.UUID, err)
+        }
+        if exists > 0 {
+            return 0, fmt.Errorf("entry %d: %w: %s", i+1, store.ErrExists, device.UUID)
+        }
+
+        var beaconID interface{} // NULL unless given, since beacon_id is unique
+        if device.BeaconID != "" {
+            beaconID = device.BeaconID
+        }
+        query := `INSERT INTO devices (device_name, uuid, is_active, beacon_id) VALUES (?, ?, 0, ?)`
+        if _, err := tx.Exec(query, device.Name, device.UUID, beaconID); err != nil {
+            return 0, fmt.Errorf("entry %d: failed to insert %s: %v", i+1, device.UUID, err)
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        return 0, fmt.Errorf("failed to commit import: %v", err)
+    }
+    return len(devices), nil
+}
+
+// ListDevices returns the devices matching filter, ordered by UUID
+func (s *SQLiteStore) ListDevices(filter store.DeviceFilter) ([]store.Device, error) {
+    query := selectDevices + ` WHERE 1 = 1`
+    var args []interface{}
+
+    switch filter.State {
+    case store.FilterAll:
+    case store.FilterActive:
+        query += ` AND is_active = 1`
+    case store.FilterInactive:
+        query += ` AND is_active = 0 AND revoked_at IS NULL`
+    case store.FilterRevoked:
+        query += ` AND revoked_at IS NOT NULL`
+    default:
+        return nil, fmt.Errorf("unknown device filter %q", filter.State)
+    }
+    if filter.NameContains != "" {
+        query += ` AND instr(lower(device_name), lower(?)) > 0`
+        args = append(args, filter.NameContains)
+    }
+    query += ` ORDER BY uuid`
+    if filter.Limit > 0 {
+        query += ` LIMIT ?`
+        args = append(args, filter.Limit)
+    }
+
+    rows, err := s.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list devices: %v", err)
+    }
+    defer rows.Close()
+
+    var devices []store.Device
+    for rows.Next() {
+        device, err := scanDevice(rows)
+        if err != nil {
+            return nil, err
+        }
+        devices = append(devices, device)
+    }
+    return devices, rows.Err()
+}
+
+// Deactivate returns a UUID to the pool of unused UUIDs
+func (s *SQLiteStore) Deactivate(uuid string) error {
+    return s.updateDevice(uuid, `UPDATE devices SET is_active = 0 WHERE uuid = ?`)
+}
+
+// Revoke withdraws a UUID for good; it is never handed out again
+func (s *SQLiteStore) Revoke(uuid string, reason string) error {
+    return s.updateDevice(uuid, `UPDATE devices SET is_active = 0, revoked_at = ?, revoke_reason = ? WHERE uuid = ?`, time.Now(), reason)
+}
+
+// Reassign gives a UUID to a new user and activates it
+func (s *SQLiteStore) Reassign(uuid string, name string) error {
+    if strings.TrimSpace(name) == "" {
+        return store.ErrMissingName
+    }
+    return s.updateDevice(uuid, `UPDATE devices SET device_name = ?, is_active = 1 WHERE uuid = ?`, name)
+}
+
+// Apply an update to a registered, unrevoked UUID, which is passed as the last query argument.
+// Earlier signup allocations of the UUID are forgotten, since it now belongs to someone else.
+func (s *SQLiteStore) updateDevice(uuid string, query string, args ...interface{}) error {
+    uuid, err := store.NormalizeUUID(uuid)
+    if err != nil {
+        return err
+    }
+
+    tx, err := s.db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    var revokedAt sql.NullTime
+    err = tx.QueryRow(`SELECT revoked_at FROM devices WHERE uuid = ?`, uuid).Scan(&revokedAt)
+    if err == sql.ErrNoRows {
+        return fmt.Errorf("%w: %s", store.ErrNotFound, uuid)
+    }
+    if err != nil {
+        return fmt.Errorf("failed to look up %s: %v", uuid, err)
+    }
+    if revokedAt.Valid {
+        return store.RevokedError(uuid, revokedAt.Time)
+    }
+
+    if _, err := tx.Exec(query, append(args, uuid)...); err != nil {
+        return fmt.Errorf("failed to update %s: %v", uuid, err)
+    }
+    if _, err := tx.Exec(`DELETE FROM uuid_allocations WHERE uuid = ?`, uuid); err != nil {
+        return fmt.Errorf("failed to clear allocations of %s: %v", uuid, err)
+    }
+    return tx.Commit()
+}
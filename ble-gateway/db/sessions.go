@@ -0,0 +1,120 @@
+package db
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+    "ble-gateway/store"
+)
+
+// StartSession records a login and returns the session ID
+func (s *SQLiteStore) StartSession(session store.Session) (int64, error) {
+    query := `INSERT INTO sessions (uuid, mac_address, gateway, started_at, peak_rssi, last_seen) VALUES (?, ?, ?, ?, ?, ?)`
+    result, err := s.db.Exec(query, session.UUID, session.Address, session.Gateway, session.StartedAt.UTC(), session.PeakRSSI, session.StartedAt.UTC())
+    if err != nil {
+        return 0, fmt.Errorf("failed to start session: %v", err)
+    }
+    return result.LastInsertId()
+}
+
+// EndSession records the logout that closes a session
+func (s *SQLiteStore) EndSession(id int64, endedAt time.Time, reason string, peakRSSI int) error {
+    query := `UPDATE sessions SET ended_at = ?, end_reason = ?, peak_rssi = MAX(peak_rssi, ?) WHERE id = ?`
+    result, err := s.db.Exec(query, endedAt.UTC(), reason, peakRSSI, id)
+    if err != nil {
+        return fmt.Errorf("failed to end session: %v", err)
+    }
+    if updated, err := result.RowsAffected(); err != nil || updated != 1 {
+        return fmt.Errorf("session %d not found", id)
+    }
+    return nil
+}
+
+// OpenSessions returns the sessions a gateway has not ended, oldest first
+func (s *SQLiteStore) OpenSessions(gateway string) ([]store.Session, error) {
+    query := selectSessions + ` WHERE gateway = ? AND ended_at IS NULL ORDER BY id`
+    rows, err := s.db.Query(query, gateway)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list open sessions: %v", err)
+    }
+    defer rows.Close()
+    return scanSessions(rows)
+}
+
+// MarkSeen checkpoints the last sighting of open sessions in one transaction
+func (s *SQLiteStore) MarkSeen(lastSeen map[int64]time.Time) error {
+    tx, err := s.db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    for id, seen := range lastSeen {
+        if _, err := tx.Exec(`UPDATE sessions SET last_seen = ? WHERE id = ? AND ended_at IS NULL`, seen.UTC(), id); err != nil {
+            return fmt.Errorf("failed to record last sighting: %v", err)
+        }
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to record last sightings: %v", err)
+    }
+    return nil
+}
+
+// ListSessions returns the sessions matching filter, latest first
+func (s *SQLiteStore) ListSessions(filter store.SessionFilter) ([]store.Session, error) {
+    query := selectSessions + ` WHERE 1 = 1`
+    var args []interface{}
+
+    if filter.UUID != "" {
+        query += ` AND uuid = ?`
+        args = append(args, filter.UUID)
+    }
+    if !filter.To.IsZero() {
+        query += ` AND started_at <= ?`
+        args = append(args, filter.To.UTC())
+    }
+    if !filter.From.IsZero() {
+        query += ` AND (ended_at IS NULL OR ended_at >= ?)`
+        args = append(args, filter.From.UTC())
+    }
+    query += ` ORDER BY started_at DESC, id DESC`
+    if filter.Limit > 0 {
+        query += ` LIMIT ?`
+        args = append(args, filter.Limit)
+    }
+
+    rows, err := s.db.Query(query, args...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list sessions: %v", err)
+    }
+    defer rows.Close()
+    return scanSessions(rows)
+}
+
+// Columns read by scanSessions
+const selectSessions = `SELECT id, uuid, mac_address, gateway, started_at, ended_at, COALESCE(end_reason, ''), peak_rssi, last_seen FROM sessions`
+
+func scanSessions(rows *sql.Rows) ([]store.Session, error) {
+    var sessions []store.Session
+    for rows.Next() {
+        var session store.Session
+        var endedAt sql.NullTime
+        err := rows.Scan(&session.ID, &session.UUID, &session.Address, &session.Gateway, &session.StartedAt, &endedAt, &session.EndReason, &session.PeakRSSI, &session.LastSeen)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read session: %v", err)
+        }
+        session.EndedAt = endedAt.Time
+        sessions = append(sessions, session)
+    }
+    return sessions, rows.Err()
+}
+
+// PruneSessions deletes the sessions that ended before a cutoff; open sessions are kept
+func (s *SQLiteStore) PruneSessions(before time.Time) (int, error) {
+    result, err := s.db.Exec(`DELETE FROM sessions WHERE ended_at < ?`, before.UTC())
+    if err != nil {
+        return 0, fmt.Errorf("failed to prune sessions: %v", err)
+    }
+    pruned, err := result.RowsAffected()
+    return int(pruned), err
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+    "database/sql"
+    "fmt"
+    "time"
+    "ble-gateway/store"
+)
+
+// SQLiteStore is the DeviceStore kept in the gateway database
+type SQLiteStore struct {
+    db *sql.DB
+}
+
+var _ store.DeviceStore = (*SQLiteStore)(nil)
+
+// NewStore returns the device registry of a database opened with Open
+func NewStore(db *sql.DB) *SQLiteStore {
+    return &SQLiteStore{db: db}
+}
+
+// Columns read into a store.Device by scanDevice
+const selectDevices = `SELECT uuid, device_name, is_active, COALESCE(beacon_id, ''), revoked_at, COALESCE(revoke_reason, ''),
+    COALESCE(tx_power, 0), COALESCE(path_loss_exponent, 0) FROM devices`
+
+func scanDevice(row interface{ Scan(...interface{}) error }) (store.Device, error) {
+    var device store.Device
+    var revokedAt sql.NullTime
+    err := row.Scan(&device.UUID, &device.Name, &device.Active, &device.BeaconID, &revokedAt, &device.RevokeReason,
+        &device.TxPower, &device.PathLossExponent)
+    if err != nil {
+        return store.Device{}, err
+    }
+    device.RevokedAt = revokedAt.Time
+    return device, nil
+}
+
+// Device looks up one registered UUID
+func (s *SQLiteStore) Device(uuid string) (store.Device, error) {
+    device, err := scanDevice(s.db.QueryRow(selectDevices+` WHERE uuid = ?`, uuid))
+    if err == sql.ErrNoRows {
+        return store.Device{}, fmt.Errorf("%w: %s", store.ErrNotFound, uuid)
+    }
+    if err != nil {
+        return store.Device{}, fmt.Errorf("failed to look up device: %v", err)
+    }
+    return device, nil
+}
+
+// Check the is_active value for a specific UUID
+func (s *SQLiteStore) IsActive(uuid string) (bool, error) {
+    var isActive int
+    query := `SELECT is_active FROM devices WHERE uuid = ?`
+    err := s.db.QueryRow(query, uuid).Scan(&isActive)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return false, nil // If UUID is not in the database, do not connect
+        }
+        return false, fmt.Errorf("failed to check device active status: %v", err)
+    }
+    return isActive == 1, nil // Return true if is_active is 1, otherwise false
+}
+
+// Find the UUID of the device registered with a beacon identity ("" if none)
+func (s *SQLiteStore) FindByBeacon(beaconID string) (string, error) {
+    var uuid string
+    query := `SELECT uuid FROM devices WHERE beacon_id = ?`
+    err := s.db.QueryRow(query, beaconID).Scan(&uuid)
+    if err != nil {
+        if err == sql.ErrNoRows {
+            return "", nil // Unregistered beacon
+        }
+        return "", fmt.Errorf("failed to find device by beacon: %v", err)
+    }
+    return uuid, nil
+}
+
+func (s *SQLiteStore) AddCalibrationSamples(uuid string, samples []store.CalibrationSample) error {
+    tx, err := s.db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    recordedAt := time.Now()
+    for _, sample := range samples {
+        query := `INSERT INTO calibration_samples (uuid, distance, rssi, recorded_at) VALUES (?, ?, ?, ?)`
+        if _, err := tx.Exec(query, uuid, sample.Distance, sample.RSSI, recordedAt); err != nil {
+            return fmt.Errorf("failed to save calibration sample: %v", err)
+        }
+    }
+    return tx.Commit()
+}
+
+func (s *SQLiteStore) CalibrationSamples(uuid string) ([]store.CalibrationSample, error) {
+    rows, err := s.db.Query(`SELECT distance, rssi FROM calibration_samples WHERE uuid = ?`, uuid)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load calibration samples: %v", err)
+    }
+    defer rows.Close()
+
+    var samples []store.CalibrationSample
+    for rows.Next() {
+        var sample store.CalibrationSample
+        if err := rows.Scan(&sample.Distance, &sample.RSSI); err != nil {
+            return nil, fmt.Errorf("failed to read calibration sample: %v", err)
+        }
+        samples = append(samples, sample)
+    }
+    return samples, rows.Err()
+}
+
+func (s *SQLiteStore) SetCalibration(uuid string, txPower float64, exponent float64) error {
+    query := `UPDATE devices SET tx_power = ?, path_loss_exponent = ? WHERE uuid = ?`
+    result, err := s.db.Exec(query, txPower, exponent, uuid)
+    if err != nil {
+        return fmt.Errorf("failed to save calibration: %v", err)
+    }
+    if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+        return fmt.Errorf("%w: %s", store.ErrNotFound, uuid)
+    }
+    return nil
+}
@@ -0,0 +1,19 @@
+package db
+
+import (
+    "testing"
+    "ble-gateway/store"
+    "ble-gateway/store/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) store.DeviceStore {
+        return openTestStore(t)
+    })
+}
+
+func TestSQLiteMemoryStore(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) store.DeviceStore {
+        return openMemoryStore(t)
+    })
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+    "flag"
+    "strings"
+    "ble-gateway/ble"
+    "ble-gateway/config"
+    "ble-gateway/handler"
+)
+
+// Load the configuration file named by -config or BLE_GATEWAY_CONFIG before
+// the other flags are registered, so its values become their defaults
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, error) {
+    path := config.PathFromArgs(args)
+    cfg, err := config.Load(path)
+    if err != nil {
+        return nil, err
+    }
+    fs.String("config", path, "YAML configuration file; BLE_GATEWAY_* environment variables and flags override it")
+    return &cfg, nil
+}
+
+// Read the configuration of a running gateway from the file and the
+// environment, and register its flags on fs, still to be parsed
+func readGatewayConfig(fs *flag.FlagSet, args []string) (*config.Config, error) {
+    cfg, err := loadConfig(fs, args)
+    if err != nil {
+        return nil, err
+    }
+    gatewayFlags(fs, cfg)
+    if err := config.ApplyEnv(fs); err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
+
+// Register the flags of a running gateway that are not part of its configuration
+func runtimeFlags(fs *flag.FlagSet) (simScript *string, tracePath *string) {
+    simScript = fs.String("simulate", "", "replay advertisements from a JSON script instead of using the BLE adapter")
+    tracePath = fs.String("trace", "", "record the scan results the gateway acts on to this JSON Lines file")
+    return simScript, tracePath
+}
+
+// Register every setting of a running gateway on a flag set
+func gatewayFlags(fs *flag.FlagSet, cfg *config.Config) {
+    fs.StringVar(&cfg.Database, "db", cfg.Database, "path of the gateway database")
+    fs.StringVar(&cfg.Server.Address, "server", cfg.Server.Address, "address of the BALogin gRPC server")
+    fs.StringVar(&cfg.Listen.Address, "listen", cfg.Listen.Address, "listen address of the gateway's own gRPC server")
+    scanFlags(fs, &cfg.Scan)
+    upstreamTLSFlags(fs, &cfg.Server.TLS)
+    listenTLSFlags(fs, &cfg.Listen.TLS)
+    fs.BoolVar(&cfg.Listen.AllowUnauthenticated, "allow-unauthenticated", cfg.Listen.AllowUnauthenticated, "let any caller allocate UUIDs when neither -auth-secret-file nor -listen-client-ca is set")
+    identityFlags(fs, &cfg.Identity)
+    fs.StringVar(&cfg.AuthSecretFile, "auth-secret-file", cfg.AuthSecretFile, "file holding the shared secret that signs gRPC calls in both directions")
+    fs.BoolVar(&cfg.Server.Stream, "stream", cfg.Server.Stream, "keep a presence stream open to the BALogin server for reports, heartbeats and commands")
+    fs.DurationVar(&cfg.Server.Heartbeat, "heartbeat", cfg.Server.Heartbeat, "interval between heartbeats on the presence stream")
+    fs.BoolVar(&cfg.Server.Snapshot, "snapshot", cfg.Server.Snapshot, "send the full presence state to the BALogin server once it is reconciled on startup")
+    fs.DurationVar(&cfg.SessionRetention, "session-retention", cfg.SessionRetention, "delete login sessions that ended longer ago than this (0: keep forever)")
+    fs.DurationVar(&cfg.RestoreGrace, "restore-grace", cfg.RestoreGrace, "time devices logged in before a crash have to be seen again before they are logged out")
+    fs.DurationVar(&cfg.CheckpointInterval, "checkpoint-interval", cfg.CheckpointInterval, "interval between saves of when logged in devices were last seen")
+    fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time allowed on SIGINT or SIGTERM to report logouts and stop before giving up")
+    fs.DurationVar(&cfg.WhitelistCheck, "whitelist-check", cfg.WhitelistCheck, "interval between checks of the whitelist cache against the database (0: never)")
+}
+
+// Register the scan loop settings on a flag set
+func scanFlags(fs *flag.FlagSet, cfg *ble.Config) {
+    fs.StringVar(&cfg.Mode, "mode", cfg.Mode, "presence detection mode: connect (GATT service discovery) or passive (advertisement only)")
+    fs.DurationVar(&cfg.ScanWindow, "scan-window", cfg.ScanWindow, "length of each scan before it is stopped and restarted")
+    fs.DurationVar(&cfg.ScanInterval, "scan-interval", cfg.ScanInterval, "pause between two scan windows")
+    fs.DurationVar(&cfg.SweepInterval, "sweep-interval", cfg.SweepInterval, "interval between checks for devices that have not been seen within the timeout")
+    fs.DurationVar(&cfg.Timeout, "presence-timeout", cfg.Timeout, "log out devices not seen for this long")
+    fs.Var((*stringList)(&cfg.IgnoredServices), "ignored-services", "comma separated service UUIDs that never identify a user")
+    fs.StringVar(&cfg.Filter, "rssi-filter", cfg.Filter, "RSSI smoothing: none, average, median or kalman")
+    fs.IntVar(&cfg.FilterWindow, "rssi-window", cfg.FilterWindow, "samples in the moving average and median filters")
+    fs.Float64Var(&cfg.KalmanProcessNoise, "kalman-q", cfg.KalmanProcessNoise, "kalman filter process noise")
+    fs.Float64Var(&cfg.KalmanMeasurementNoise, "kalman-r", cfg.KalmanMeasurementNoise, "kalman filter measurement noise")
+    fs.IntVar(&cfg.EnterRSSI, "enter-rssi", cfg.EnterRSSI, "filtered RSSI (dBm) above which a user is logged in")
+    fs.IntVar(&cfg.ExitRSSI, "exit-rssi", cfg.ExitRSSI, "filtered RSSI (dBm) below which a user is logged out")
+    fs.IntVar(&cfg.DwellCount, "dwell", cfg.DwellCount, "consecutive filtered samples required before logging in or out")
+    fs.Float64Var(&cfg.EnterDistance, "enter-distance", cfg.EnterDistance, "estimated distance (m) within which a user is logged in; 0 uses the RSSI thresholds")
+    fs.Float64Var(&cfg.ExitDistance, "exit-distance", cfg.ExitDistance, "estimated distance (m) beyond which a user is logged out")
+    fs.Float64Var(&cfg.DefaultCalibration.TxPower, "tx-power", cfg.DefaultCalibration.TxPower, "RSSI (dBm) at 1 m for devices without a stored calibration")
+    fs.Float64Var(&cfg.DefaultCalibration.PathLossExponent, "path-loss", cfg.DefaultCalibration.PathLossExponent, "path loss exponent for devices without a stored calibration")
+}
+
+// stringList is a comma separated flag value
+type stringList []string
+
+func (l *stringList) String() string {
+    if l == nil {
+        return ""
+    }
+    return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+    *l = nil
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            *l = append(*l, item)
+        }
+    }
+    return nil
+}
+
+// Register the certificate paths of the connection to the BALogin server
+func upstreamTLSFlags(fs *flag.FlagSet, cfg *handler.TLSConfig) {
+    fs.StringVar(&cfg.CAFile, "server-ca", cfg.CAFile, "CA bundle (PEM) to verify the BALogin server with; enables TLS")
+    fs.StringVar(&cfg.CertFile, "client-cert", cfg.CertFile, "this gateway's client certificate (PEM) for mutual TLS")
+    fs.StringVar(&cfg.KeyFile, "client-key", cfg.KeyFile, "private key (PEM) of -client-cert")
+    fs.StringVar(&cfg.ServerName, "server-name", cfg.ServerName, "expected name in the BALogin server certificate, if it differs from the -server host")
+}
+
+// Register the certificate paths of the gateway's own gRPC server
+func listenTLSFlags(fs *flag.FlagSet, cfg *handler.TLSConfig) {
+    fs.StringVar(&cfg.CertFile, "listen-cert", cfg.CertFile, "certificate (PEM) of the gateway's gRPC server; enables TLS")
+    fs.StringVar(&cfg.KeyFile, "listen-key", cfg.KeyFile, "private key (PEM) of -listen-cert")
+    fs.StringVar(&cfg.CAFile, "listen-client-ca", cfg.CAFile, "CA bundle (PEM) that client certificates must chain to; enables mutual TLS")
+}
+
+// Register the gateway identity reported with every status update
+func identityFlags(fs *flag.FlagSet, id *handler.Identity) {
+    fs.StringVar(&id.ID, "gateway-id", id.ID, "unique ID of this gateway, reported with every status update")
+    fs.StringVar(&id.Site, "site", id.Site, "institution or building of this gateway")
+    fs.StringVar(&id.Location, "location", id.Location, "room or kiosk label of this gateway")
+}
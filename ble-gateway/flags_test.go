@@ -0,0 +1,144 @@
+package main
+
+import (
+    "flag"
+    "io"
+    "path/filepath"
+    "testing"
+    "ble-gateway/config"
+)
+
+// Read the configuration the way main does, from args and the environment
+func parseGatewayConfig(t *testing.T, args []string) (*config.Config, error) {
+    t.Helper()
+    fs := flag.NewFlagSet("test", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    cfg, err := readGatewayConfig(fs, args)
+    if err != nil {
+        return nil, err
+    }
+    runtimeFlags(fs)
+    if err := fs.Parse(args); err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
+
+func TestConfigPrecedence(t *testing.T) {
+    dir := t.TempDir()
+    file := filepath.Join(dir, "gateway.yaml")
+    writeConfig(t, file, "server:\n  address: file:50051\nscan:\n  enter_rssi: -80\n  mode: passive\n")
+    other := filepath.Join(dir, "other.yaml")
+    writeConfig(t, other, "server:\n  address: other:50051\n")
+
+    tests := []struct {
+        name    string
+        env     map[string]string
+        args    []string
+        address string
+        rssi    int
+        mode    string
+    }{
+        {
+            name:    "defaults",
+            address: "localhost:50051",
+            rssi:    -88,
+            mode:    "connect",
+        },
+        {
+            name:    "file over defaults",
+            args:    []string{"-config", file},
+            address: "file:50051",
+            rssi:    -80,
+            mode:    "passive",
+        },
+        {
+            name:    "file named by the environment",
+            env:     map[string]string{config.PathEnv: file},
+            address: "file:50051",
+            rssi:    -80,
+            mode:    "passive",
+        },
+        {
+            name:    "-config over the environment's file",
+            env:     map[string]string{config.PathEnv: other},
+            args:    []string{"-config=" + file},
+            address: "file:50051",
+            rssi:    -80,
+            mode:    "passive",
+        },
+        {
+            name:    "environment over file",
+            env:     map[string]string{"BLE_GATEWAY_ENTER_RSSI": "-70", "BLE_GATEWAY_SERVER": "env:50051"},
+            args:    []string{"-config", file},
+            address: "env:50051",
+            rssi:    -70,
+            mode:    "passive",
+        },
+        {
+            name:    "flags over environment",
+            env:     map[string]string{"BLE_GATEWAY_ENTER_RSSI": "-70", "BLE_GATEWAY_SERVER": "env:50051"},
+            args:    []string{"-config", file, "-enter-rssi", "-60", "-mode", "connect"},
+            address: "env:50051",
+            rssi:    -60,
+            mode:    "connect",
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv(config.PathEnv, "")
+            for name, value := range tt.env {
+                t.Setenv(name, value)
+            }
+            cfg, err := parseGatewayConfig(t, tt.args)
+            if err != nil {
+                t.Fatalf("failed to read config: %v", err)
+            }
+            if cfg.Server.Address != tt.address || cfg.Scan.EnterRSSI != tt.rssi || cfg.Scan.Mode != tt.mode {
+                t.Errorf("server %s, enter RSSI %d, mode %s; want %s, %d, %s", cfg.Server.Address, cfg.Scan.EnterRSSI, cfg.Scan.Mode, tt.address, tt.rssi, tt.mode)
+            }
+        })
+    }
+}
+
+func TestReadGatewayConfigErrors(t *testing.T) {
+    dir := t.TempDir()
+    unknown := filepath.Join(dir, "unknown.yaml")
+    writeConfig(t, unknown, "scan:\n  enter_rsi: -70\n")
+
+    tests := []struct {
+        name string
+        env  map[string]string
+        args []string
+    }{
+        {name: "missing file", args: []string{"-config", filepath.Join(dir, "missing.yaml")}},
+        {name: "unknown key", args: []string{"-config", unknown}},
+        {name: "invalid environment value", env: map[string]string{"BLE_GATEWAY_DWELL": "twice"}},
+        {name: "invalid flag value", args: []string{"-dwell", "twice"}},
+        {name: "unknown flag", args: []string{"-dwel", "2"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            t.Setenv(config.PathEnv, "")
+            for name, value := range tt.env {
+                t.Setenv(name, value)
+            }
+            if _, err := parseGatewayConfig(t, tt.args); err == nil {
+                t.Error("invalid configuration read")
+            }
+        })
+    }
+}
+
+func TestStringList(t *testing.T) {
+    var list stringList
+    if err := list.Set(" a, ,b,c "); err != nil {
+        t.Fatal(err)
+    }
+    if got := list.String(); got != "a,b,c" {
+        t.Errorf("list = %q, want a,b,c", got)
+    }
+    if err := list.Set(""); err != nil || len(list) != 0 {
+        t.Errorf("empty value gave %q, want an empty list", list)
+    }
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+    "context"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "ble-gateway/proto"
+)
+
+// ReloadFunc re-reads and applies the gateway configuration. It returns the
+// keys of the changed settings now in effect and of those that need a restart;
+// on error nothing was changed.
+type ReloadFunc func() (applied []string, restartRequired []string, err error)
+
+// AdminServiceServer structure definition
+type adminServer struct {
+    pb.UnimplementedAdminServiceServer
+    reload ReloadFunc
+}
+
+// ReloadConfig: Function to apply the configuration without restarting
+func (s *adminServer) ReloadConfig(ctx context.Context, req *pb.ReloadConfigRequest) (*pb.ReloadConfigResponse, error) {
+    applied, restartRequired, err := s.reload()
+    if err != nil {
+        return nil, status.Errorf(codes.FailedPrecondition, "configuration not reloaded: %v", err)
+    }
+    return &pb.ReloadConfigResponse{Applied: applied, RestartRequired: restartRequired}, nil
+}
+
+// DialAdmin connects to the AdminService of a gateway
+func DialAdmin(cfg ClientConfig) (pb.AdminServiceClient, *grpc.ClientConn, error) {
+    conn, err := dial(cfg)
+    if err != nil {
+        return nil, nil, err
+    }
+    return pb.NewAdminServiceClient(conn), conn, nil
+}
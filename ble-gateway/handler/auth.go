@@ -0,0 +1,251 @@
+package handler
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/proto"
+    pb "ble-gateway/proto"
+)
+
+// gRPC metadata keys carrying the caller's identity and signature
+const (
+    metadataGatewayID = "x-gateway-id"
+    metadataSite      = "x-gateway-site"
+    metadataLocation  = "x-gateway-location"
+    metadataTimestamp = "x-auth-timestamp"
+    metadataNonce     = "x-auth-nonce"
+    metadataSignature = "x-auth-signature"
+)
+
+// Maximum difference between the signing time and the server clock
+const maxClockSkew = 5 * time.Minute
+
+// Longest nonce accepted, in characters
+const maxNonceLength = 64
+
+// Identity names the gateway in every report and signed call
+type Identity struct {
+    ID       string `yaml:"id"`       // Unique gateway ID
+    Site     string `yaml:"site"`     // Institution or building
+    Location string `yaml:"location"` // Room or kiosk label
+}
+
+// DefaultIdentity uses the host name as the gateway ID
+func DefaultIdentity() Identity {
+    hostname, err := os.Hostname()
+    if err != nil {
+        hostname = "ble-gateway"
+    }
+    return Identity{ID: hostname}
+}
+
+func (id Identity) proto() *pb.GatewayIdentity {
+    return &pb.GatewayIdentity{Id: id.ID, Site: id.Site, Location: id.Location}
+}
+
+// LoadSecret reads the shared HMAC secret from a file; surrounding whitespace is ignored
+func LoadSecret(path string) ([]byte, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read secret file: %v", err)
+    }
+    secret := []byte(strings.TrimSpace(string(data)))
+    if len(secret) < 16 {
+        return nil, fmt.Errorf("secret in %s is shorter than 16 bytes", path)
+    }
+    return secret, nil
+}
+
+// HMAC-SHA256 over the method, the identity, the signing time, the nonce and the request digest
+func sign(secret []byte, method string, id Identity, timestamp string, nonce string, digest string) string {
+    mac := hmac.New(sha256.New, secret)
+    fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n%s", method, id.ID, id.Site, id.Location, timestamp, nonce, digest)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Hex SHA-256 of the deterministically marshalled request; a stream is
+// opened without a request, so it is signed with the digest of an empty body
+func payloadDigest(req interface{}) (string, error) {
+    var body []byte
+    if msg, ok := req.(proto.Message); ok {
+        var err error
+        body, err = proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+        if err != nil {
+            return "", fmt.Errorf("failed to marshal request: %v", err)
+        }
+    }
+    sum := sha256.Sum256(body)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+// Random value that makes every signature unique
+func newNonce() (string, error) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        return "", fmt.Errorf("failed to create nonce: %v", err)
+    }
+    return hex.EncodeToString(nonce), nil
+}
+
+// Attach the identity and, when a secret is configured, its signature over req to an outgoing call
+func signContext(ctx context.Context, method string, req interface{}, id Identity, secret []byte) (context.Context, error) {
+    pairs := []string{
+        metadataGatewayID, id.ID,
+        metadataSite, id.Site,
+        metadataLocation, id.Location,
+    }
+    if len(secret) > 0 {
+        digest, err := payloadDigest(req)
+        if err != nil {
+            return nil, err
+        }
+        nonce, err := newNonce()
+        if err != nil {
+            return nil, err
+        }
+        timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+        pairs = append(pairs,
+            metadataTimestamp, timestamp,
+            metadataNonce, nonce,
+            metadataSignature, sign(secret, method, id, timestamp, nonce, digest),
+        )
+    }
+    return metadata.AppendToOutgoingContext(ctx, pairs...), nil
+}
+
+// Client interceptor signing every unary call
+func signingInterceptor(id Identity, secret []byte) grpc.UnaryClientInterceptor {
+    return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+        ctx, err := signContext(ctx, method, req, id, secret)
+        if err != nil {
+            return err
+        }
+        return invoker(ctx, method, req, reply, cc, opts...)
+    }
+}
+
+// Client interceptor signing every streaming call
+func signingStreamInterceptor(id Identity, secret []byte) grpc.StreamClientInterceptor {
+    return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+        ctx, err := signContext(ctx, method, nil, id, secret)
+        if err != nil {
+            return nil, err
+        }
+        return streamer(ctx, desc, cc, method, opts...)
+    }
+}
+
+// nonceCache remembers the nonces of accepted calls for as long as their
+// timestamps are valid, so a captured call cannot be replayed
+type nonceCache struct {
+    mu     sync.Mutex
+    seen   map[string]time.Time // Nonce -> time it can be forgotten
+    pruned time.Time
+}
+
+func newNonceCache() *nonceCache {
+    return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// Record a nonce; reports false if it was already used
+func (c *nonceCache) use(nonce string, now time.Time) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if now.Sub(c.pruned) > time.Minute {
+        for seen, expires := range c.seen {
+            if now.After(expires) {
+                delete(c.seen, seen)
+            }
+        }
+        c.pruned = now
+    }
+    if _, used := c.seen[nonce]; used {
+        return false
+    }
+    // A timestamp is accepted up to maxClockSkew on either side of the clock
+    c.seen[nonce] = now.Add(2 * maxClockSkew)
+    return true
+}
+
+// Check the signed metadata of an incoming call over req and return the caller's identity
+func verify(ctx context.Context, method string, req interface{}, secret []byte, nonces *nonceCache) (Identity, error) {
+    md, ok := metadata.FromIncomingContext(ctx)
+    if !ok {
+        return Identity{}, status.Error(codes.Unauthenticated, "missing credentials")
+    }
+    get := func(key string) string {
+        if values := md.Get(key); len(values) > 0 {
+            return values[0]
+        }
+        return ""
+    }
+
+    id := Identity{ID: get(metadataGatewayID), Site: get(metadataSite), Location: get(metadataLocation)}
+    timestamp, nonce, signature := get(metadataTimestamp), get(metadataNonce), get(metadataSignature)
+    if id.ID == "" || timestamp == "" || nonce == "" || signature == "" {
+        return id, status.Error(codes.Unauthenticated, "missing credentials")
+    }
+    if len(nonce) > maxNonceLength {
+        return id, status.Error(codes.Unauthenticated, "invalid nonce")
+    }
+
+    seconds, err := strconv.ParseInt(timestamp, 10, 64)
+    if err != nil {
+        return id, status.Error(codes.Unauthenticated, "invalid timestamp")
+    }
+    if skew := time.Since(time.Unix(seconds, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+        return id, status.Error(codes.Unauthenticated, "timestamp outside the allowed window")
+    }
+
+    digest, err := payloadDigest(req)
+    if err != nil {
+        return id, status.Error(codes.Internal, err.Error())
+    }
+    expected := sign(secret, method, id, timestamp, nonce, digest)
+    if !hmac.Equal([]byte(expected), []byte(signature)) {
+        return id, status.Error(codes.Unauthenticated, "invalid signature")
+    }
+    if !nonces.use(nonce, time.Now()) {
+        return id, status.Error(codes.Unauthenticated, "replayed call")
+    }
+    return id, nil
+}
+
+// Server interceptor rejecting calls without a valid signature
+func authInterceptor(secret []byte, nonces *nonceCache) grpc.UnaryServerInterceptor {
+    return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+        id, err := verify(ctx, info.FullMethod, req, secret, nonces)
+        if err != nil {
+            log.Printf("Rejected %s from %q: %v", info.FullMethod, id.ID, err)
+            return nil, err
+        }
+        return handler(ctx, req)
+    }
+}
+
+// Server interceptor rejecting streams without a valid signature
+func authStreamInterceptor(secret []byte, nonces *nonceCache) grpc.StreamServerInterceptor {
+    return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+        id, err := verify(stream.Context(), info.FullMethod, nil, secret, nonces)
+        if err != nil {
+            log.Printf("Rejected %s from %q: %v", info.FullMethod, id.ID, err)
+            return err
+        }
+        return handler(srv, stream)
+    }
+}
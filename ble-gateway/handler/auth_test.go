@@ -0,0 +1,114 @@
+package handler
+
+import (
+    "context"
+    "strconv"
+    "testing"
+    "time"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/status"
+    pb "ble-gateway/proto"
+)
+
+const testMethod = "/ble.DeviceService/RequestUnusedUUID"
+
+var (
+    testSecret   = []byte("0123456789abcdef0123456789abcdef")
+    testIdentity = Identity{ID: "gw-test", Site: "Main Library", Location: "2F entrance"}
+)
+
+// Sign req as the client would and return the metadata the server receives
+func signedMetadata(t *testing.T, req interface{}) metadata.MD {
+    t.Helper()
+    ctx, err := signContext(context.Background(), testMethod, req, testIdentity, testSecret)
+    if err != nil {
+        t.Fatal(err)
+    }
+    md, _ := metadata.FromOutgoingContext(ctx)
+    return md
+}
+
+// Pass a call with md and req through the server interceptor
+func callWith(interceptor grpc.UnaryServerInterceptor, md metadata.MD, req interface{}) error {
+    ctx := metadata.NewIncomingContext(context.Background(), md)
+    _, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+        return &pb.Response{Message: "success"}, nil
+    })
+    return err
+}
+
+func TestAuthInterceptor(t *testing.T) {
+    req := &pb.UUIDRequest{Uuid: "signup-1"}
+    stale := signedMetadata(t, req)
+    stale.Set(metadataTimestamp, strconv.FormatInt(time.Now().Add(-2*maxClockSkew).Unix(), 10))
+    otherMethod := signedMetadata(t, req)
+    otherIdentity := signedMetadata(t, req)
+    otherIdentity.Set(metadataGatewayID, "gw-other")
+    unsigned := signedMetadata(t, req)
+    unsigned.Delete(metadataSignature)
+    noNonce := signedMetadata(t, req)
+    noNonce.Delete(metadataNonce)
+
+    tests := []struct {
+        name   string
+        md     metadata.MD
+        req    interface{}
+        method string
+        code   codes.Code
+    }{
+        {"valid", signedMetadata(t, req), req, testMethod, codes.OK},
+        {"tampered payload", signedMetadata(t, req), &pb.UUIDRequest{Uuid: "signup-2"}, testMethod, codes.Unauthenticated},
+        {"other method", otherMethod, req, "/ble.RegistryService/RevokeDevice", codes.Unauthenticated},
+        {"other identity", otherIdentity, req, testMethod, codes.Unauthenticated},
+        {"stale timestamp", stale, req, testMethod, codes.Unauthenticated},
+        {"missing signature", unsigned, req, testMethod, codes.Unauthenticated},
+        {"missing nonce", noNonce, req, testMethod, codes.Unauthenticated},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            interceptor := authInterceptor(testSecret, newNonceCache())
+            ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+            _, err := interceptor(ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+                return nil, nil
+            })
+            if code := status.Code(err); code != tt.code {
+                t.Errorf("code %v (%v), want %v", code, err, tt.code)
+            }
+        })
+    }
+}
+
+func TestAuthInterceptorRejectsReplay(t *testing.T) {
+    interceptor := authInterceptor(testSecret, newNonceCache())
+    req := &pb.UUIDRequest{Uuid: "signup-1"}
+
+    md := signedMetadata(t, req)
+    if err := callWith(interceptor, md, req); err != nil {
+        t.Fatalf("first call rejected: %v", err)
+    }
+    if code := status.Code(callWith(interceptor, md, req)); code != codes.Unauthenticated {
+        t.Errorf("replayed call: code %v, want Unauthenticated", code)
+    }
+
+    // A fresh signature of the same request is a new call
+    if err := callWith(interceptor, signedMetadata(t, req), req); err != nil {
+        t.Errorf("second signed call rejected: %v", err)
+    }
+}
+
+func TestNonceCacheForgetsExpiredNonces(t *testing.T) {
+    cache := newNonceCache()
+    now := time.Now()
+    if !cache.use("a", now) || cache.use("a", now.Add(maxClockSkew)) {
+        t.Fatal("nonce not remembered within the skew window")
+    }
+    later := now.Add(2*maxClockSkew + 2*time.Minute)
+    if !cache.use("b", later) {
+        t.Fatal("new nonce rejected")
+    }
+    if _, kept := cache.seen["a"]; kept {
+        t.Error("expired nonce kept")
+    }
+}
@@ -2,47 +2,146 @@ package handler
 
 import (
     "context"
+    "errors"
     "fmt"
     "log"
     "net"
-    "ble-gateway/db"
+    "ble-gateway/store"
     "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
     pb "ble-gateway/proto"
 )
 
 // DeviceServiceServer structure definition
 type server struct {
     pb.UnimplementedDeviceServiceServer
+    devices  store.DeviceStore
+    writable bool // UUIDs are only handed out to authenticated callers, unless AllowUnauthenticated
 }
 
+// Returned by the calls that change the registry when no caller can be authenticated
+var errUnauthenticatedWrites = status.Error(codes.PermissionDenied, "registry changes need an auth secret or client certificates configured on the gateway")
+
+// Returned by RequestUnusedUUID when UUIDs are neither authenticated nor explicitly allowed without
+var errAllocationDisabled = status.Error(codes.PermissionDenied, "UUID allocation needs an auth secret or client certificates configured on the gateway, or -allow-unauthenticated")
+
 // RequestUnusedUUID: Function called when a UUID request is made to the server
 func (s *server) RequestUnusedUUID(ctx context.Context, req *pb.UUIDRequest) (*pb.Response, error) {
     fmt.Println("Request to server.")
+    if !s.writable {
+        return nil, errAllocationDisabled
+    }
 
     // Call the service to activate and process the UUID
-    uuid, err := db.GetAndActivateUUID()
+    uuid, err := s.devices.AllocateUUID(req.Uuid) // The signup UUID doubles as the request key
+    if errors.Is(err, store.ErrExhausted) {
+        return nil, status.Error(codes.ResourceExhausted, err.Error())
+    }
     if err != nil {
         log.Printf("Failed to handle UUID: %v", err)
-        return &pb.Response{Message: "Failed to process request"}, err
+        return nil, status.Error(codes.Internal, "failed to process request")
     }
 
     responseMessage := fmt.Sprintf("%s", uuid)
     return &pb.Response{Message: responseMessage}, nil
 }
 
-// ServiceServer: Function to start the gRPC server
-func ServiceServer() {
+// Default listen address of the gateway's own gRPC server
+const DefaultListenAddress = ":50052"
+
+// ServerConfig describes the gateway's own gRPC server
+type ServerConfig struct {
+    Address string            // Listen address, DefaultListenAddress if empty
+    Devices store.DeviceStore // Device registry
+    TLS     TLSConfig         // TLS when enabled, mutual TLS when it also has a CA file
+    Secret  []byte            // Shared HMAC secret every call must be signed with; no check if empty
+
+    // Hand out UUIDs to any caller when neither Secret nor a client CA is set,
+    // as gateways did before allocation required authentication
+    AllowUnauthenticated bool
+
+    OnRegistryChange func()     // Called after the registry has been changed through RegistryService
+    Reload           ReloadFunc // Serves AdminService.ReloadConfig; the service is not offered if nil or without authentication
+}
+
+// Authenticated reports whether every caller is authenticated, by the shared secret or a verified client certificate
+func (cfg ServerConfig) Authenticated() bool {
+    return len(cfg.Secret) > 0 || cfg.TLS.CAFile != ""
+}
+
+// AllocatesUUIDs reports whether RequestUnusedUUID and the stream's AssignUUID hand out UUIDs
+func (cfg ServerConfig) AllocatesUUIDs() bool {
+    return cfg.Authenticated() || cfg.AllowUnauthenticated
+}
+
+// Server is the running gRPC server of the gateway
+type Server struct {
+    grpc *grpc.Server
+    done chan struct{} // Closed when Serve has returned
+}
+
+// ServiceServer: Function to start the gRPC server; it serves in the background until Shutdown
+func ServiceServer(cfg ServerConfig) *Server {
     // Set up gRPC server listener
-    lis, err := net.Listen("tcp", ":50052") // Waiting on port 50052
+    if cfg.Address == "" {
+        cfg.Address = DefaultListenAddress
+    }
+    lis, err := net.Listen("tcp", cfg.Address)
     if err != nil {
         log.Fatalf("Failed to listen: %v", err)
     }
 
-    grpcServer := grpc.NewServer()
-    pb.RegisterDeviceServiceServer(grpcServer, &server{}) // Register the service handler
+    var options []grpc.ServerOption
+    if cfg.TLS.Enabled() {
+        creds, err := serverCredentials(cfg.TLS)
+        if err != nil {
+            log.Fatalf("Failed to set up TLS: %v", err)
+        }
+        options = append(options, grpc.Creds(creds))
+    }
+    if len(cfg.Secret) > 0 {
+        nonces := newNonceCache() // Shared, so a unary call cannot be replayed as a stream
+        options = append(options,
+            grpc.UnaryInterceptor(authInterceptor(cfg.Secret, nonces)),
+            grpc.StreamInterceptor(authStreamInterceptor(cfg.Secret, nonces)),
+        )
+    }
+    switch {
+    case cfg.Authenticated():
+    case cfg.AllowUnauthenticated:
+        log.Printf("WARNING: -allow-unauthenticated is set: any client that can reach %s may allocate UUIDs; registry changes stay disabled", cfg.Address)
+    default:
+        log.Printf("No auth secret or client CA configured: UUID allocation and registry changes are disabled")
+    }
 
-    fmt.Println("Running on port 50052...") // Notify that the server is running
-    if err := grpcServer.Serve(lis); err != nil {
-        log.Fatalf("Failed to serve: %v", err)
+    grpcServer := grpc.NewServer(options...)
+    pb.RegisterDeviceServiceServer(grpcServer, &server{devices: cfg.Devices, writable: cfg.AllocatesUUIDs()}) // Register the service handler
+    pb.RegisterRegistryServiceServer(grpcServer, &registryServer{devices: cfg.Devices, writable: cfg.Authenticated(), changed: cfg.OnRegistryChange})
+    if cfg.Reload != nil {
+        if cfg.Authenticated() {
+            pb.RegisterAdminServiceServer(grpcServer, &adminServer{reload: cfg.Reload})
+        } else {
+            log.Printf("No auth secret or client CA configured: AdminService is not offered, reload with SIGHUP instead")
+        }
     }
+
+    s := &Server{grpc: grpcServer, done: make(chan struct{})}
+    go func() {
+        defer close(s.done)
+        fmt.Printf("Running on %s...\n", cfg.Address) // Notify that the server is running
+        if err := grpcServer.Serve(lis); err != nil {
+            log.Fatalf("Failed to serve: %v", err)
+        }
+    }()
+    return s
+}
+
+// Shutdown stops accepting calls and waits for those in progress to finish;
+// once ctx is done, the remaining calls are cancelled
+func (s *Server) Shutdown(ctx context.Context) {
+    stop := context.AfterFunc(ctx, s.grpc.Stop)
+    defer stop()
+    s.grpc.GracefulStop()
+    <-s.done
 }
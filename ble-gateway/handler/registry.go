@@ -0,0 +1,149 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+    pb "ble-gateway/proto"
+    "ble-gateway/store"
+)
+
+// Default address of a gateway's own gRPC server
+const DefaultGatewayAddress = "localhost:50052"
+
+// RegistryServiceServer structure definition
+type registryServer struct {
+    pb.UnimplementedRegistryServiceServer
+    devices  store.DeviceStore
+    writable bool   // Changes are only accepted from authenticated callers
+    changed  func() // Called after every successful change, may be nil
+}
+
+// ImportDevices: Function to add devices to the registry
+func (s *registryServer) ImportDevices(ctx context.Context, req *pb.ImportDevicesRequest) (*pb.ImportDevicesResponse, error) {
+    if !s.writable {
+        return nil, errUnauthenticatedWrites
+    }
+    devices := make([]store.Device, 0, len(req.Devices))
+    for _, device := range req.Devices {
+        devices = append(devices, store.Device{UUID: device.Uuid, Name: device.DeviceName, BeaconID: device.BeaconId})
+    }
+
+    imported, err := s.devices.ImportDevices(devices)
+    if err != nil {
+        return nil, registryError("import devices", err)
+    }
+    fmt.Printf("Imported %d devices.\n", imported)
+    s.notify()
+    return &pb.ImportDevicesResponse{Imported: uint32(imported)}, nil
+}
+
+// ListDevices: Function to list registered devices
+func (s *registryServer) ListDevices(ctx context.Context, req *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+    filter := store.DeviceFilter{NameContains: req.NameContains, Limit: int(req.Limit)}
+    switch req.Filter {
+    case pb.DeviceFilter_DEVICE_FILTER_ACTIVE:
+        filter.State = store.FilterActive
+    case pb.DeviceFilter_DEVICE_FILTER_INACTIVE:
+        filter.State = store.FilterInactive
+    case pb.DeviceFilter_DEVICE_FILTER_REVOKED:
+        filter.State = store.FilterRevoked
+    }
+
+    devices, err := s.devices.ListDevices(filter)
+    if err != nil {
+        return nil, registryError("list devices", err)
+    }
+
+    res := &pb.ListDevicesResponse{}
+    for _, device := range devices {
+        entry := &pb.Device{
+            Uuid:         device.UUID,
+            DeviceName:   device.Name,
+            Active:       device.Active,
+            BeaconId:     device.BeaconID,
+            Revoked:      device.Revoked(),
+            RevokeReason: device.RevokeReason,
+        }
+        if device.Revoked() {
+            entry.RevokedAt = timestamppb.New(device.RevokedAt)
+        }
+        res.Devices = append(res.Devices, entry)
+    }
+    return res, nil
+}
+
+// DeactivateDevice: Function to return a UUID to the pool
+func (s *registryServer) DeactivateDevice(ctx context.Context, req *pb.DeviceRequest) (*pb.Response, error) {
+    if !s.writable {
+        return nil, errUnauthenticatedWrites
+    }
+    if err := s.devices.Deactivate(req.Uuid); err != nil {
+        return nil, registryError("deactivate device", err)
+    }
+    s.notify()
+    return &pb.Response{Message: "success"}, nil
+}
+
+// RevokeDevice: Function to withdraw a UUID for good
+func (s *registryServer) RevokeDevice(ctx context.Context, req *pb.RevokeDeviceRequest) (*pb.Response, error) {
+    if !s.writable {
+        return nil, errUnauthenticatedWrites
+    }
+    if err := s.devices.Revoke(req.Uuid, req.Reason); err != nil {
+        return nil, registryError("revoke device", err)
+    }
+    s.notify()
+    return &pb.Response{Message: "success"}, nil
+}
+
+// ReassignDevice: Function to give a UUID to a new user
+func (s *registryServer) ReassignDevice(ctx context.Context, req *pb.ReassignDeviceRequest) (*pb.Response, error) {
+    if !s.writable {
+        return nil, errUnauthenticatedWrites
+    }
+    if err := s.devices.Reassign(req.Uuid, req.DeviceName); err != nil {
+        return nil, registryError("reassign device", err)
+    }
+    s.notify()
+    return &pb.Response{Message: "success"}, nil
+}
+
+func (s *registryServer) notify() {
+    if s.changed != nil {
+        s.changed()
+    }
+}
+
+// Map a registry error onto a gRPC status
+func registryError(action string, err error) error {
+    code := codes.Internal
+    switch {
+    case errors.Is(err, store.ErrInvalidUUID), errors.Is(err, store.ErrMissingName), errors.Is(err, store.ErrInvalidBeacon):
+        code = codes.InvalidArgument
+    case errors.Is(err, store.ErrNotFound):
+        code = codes.NotFound
+    case errors.Is(err, store.ErrExists):
+        code = codes.AlreadyExists
+    case errors.Is(err, store.ErrRevoked):
+        code = codes.FailedPrecondition
+    }
+    if code == codes.Internal {
+        log.Printf("Failed to %s: %v", action, err)
+    }
+    return status.Errorf(code, "failed to %s: %v", action, err)
+}
+
+// DialRegistry connects to the RegistryService of a gateway
+func DialRegistry(cfg ClientConfig) (pb.RegistryServiceClient, *grpc.ClientConn, error) {
+    conn, err := dial(cfg)
+    if err != nil {
+        return nil, nil, err
+    }
+    return pb.NewRegistryServiceClient(conn), conn, nil
+}
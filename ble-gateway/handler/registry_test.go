@@ -0,0 +1,84 @@
+package handler
+
+import (
+    "context"
+    "testing"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "ble-gateway/proto"
+    "ble-gateway/store"
+)
+
+const testUUID = "123e4567-e89b-12d3-a456-426614174000"
+
+func TestServerConfigAuthenticated(t *testing.T) {
+    tests := []struct {
+        cfg          ServerConfig
+        want         bool
+        wantAllocate bool
+    }{
+        {ServerConfig{}, false, false},
+        {ServerConfig{TLS: TLSConfig{CertFile: "server.pem", KeyFile: "server.key"}}, false, false},
+        {ServerConfig{Secret: []byte("0123456789abcdef")}, true, true},
+        {ServerConfig{TLS: TLSConfig{CertFile: "server.pem", KeyFile: "server.key", CAFile: "ca.pem"}}, true, true},
+        {ServerConfig{AllowUnauthenticated: true}, false, true},
+    }
+    for _, tt := range tests {
+        if got := tt.cfg.Authenticated(); got != tt.want {
+            t.Errorf("Authenticated() of %+v = %v, want %v", tt.cfg, got, tt.want)
+        }
+        if got := tt.cfg.AllocatesUUIDs(); got != tt.wantAllocate {
+            t.Errorf("AllocatesUUIDs() of %+v = %v, want %v", tt.cfg, got, tt.wantAllocate)
+        }
+    }
+}
+
+func TestRegistryWritesNeedAuthentication(t *testing.T) {
+    ctx := context.Background()
+    devices := store.NewMemoryStore(store.Device{UUID: testUUID, Name: "sensor"})
+    registry := &registryServer{devices: devices}
+    signup := &server{devices: devices}
+
+    calls := map[string]func() error{
+        "ImportDevices": func() error {
+            _, err := registry.ImportDevices(ctx, &pb.ImportDevicesRequest{Devices: []*pb.Device{{Uuid: "223e4567-e89b-12d3-a456-426614174000", DeviceName: "new"}}})
+            return err
+        },
+        "DeactivateDevice": func() error {
+            _, err := registry.DeactivateDevice(ctx, &pb.DeviceRequest{Uuid: testUUID})
+            return err
+        },
+        "RevokeDevice": func() error {
+            _, err := registry.RevokeDevice(ctx, &pb.RevokeDeviceRequest{Uuid: testUUID})
+            return err
+        },
+        "ReassignDevice": func() error {
+            _, err := registry.ReassignDevice(ctx, &pb.ReassignDeviceRequest{Uuid: testUUID, DeviceName: "other"})
+            return err
+        },
+        "RequestUnusedUUID": func() error {
+            _, err := signup.RequestUnusedUUID(ctx, &pb.UUIDRequest{Uuid: "signup-1"})
+            return err
+        },
+    }
+    for name, call := range calls {
+        if code := status.Code(call()); code != codes.PermissionDenied {
+            t.Errorf("%s without authentication: code %v, want PermissionDenied", name, code)
+        }
+    }
+
+    if _, err := registry.ListDevices(ctx, &pb.ListDevicesRequest{}); err != nil {
+        t.Errorf("ListDevices without authentication: %v", err)
+    }
+    if devices, _ := devices.ListDevices(store.DeviceFilter{}); len(devices) != 1 || devices[0].Name != "sensor" || devices[0].Revoked() {
+        t.Errorf("registry changed by unauthenticated calls: %+v", devices)
+    }
+}
+
+func TestRequestUnusedUUIDExhausted(t *testing.T) {
+    signup := &server{devices: store.NewMemoryStore(), writable: true}
+    _, err := signup.RequestUnusedUUID(context.Background(), &pb.UUIDRequest{Uuid: "signup-1"})
+    if code := status.Code(err); code != codes.ResourceExhausted {
+        t.Errorf("code %v (%v), want ResourceExhausted", code, err)
+    }
+}
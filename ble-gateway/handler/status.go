@@ -2,44 +2,319 @@ package handler
 
 import (
     "context"
+    "fmt"
     "log"
+    "net"
+    "sync"
     "time"
     "google.golang.org/grpc"
+    "google.golang.org/grpc/backoff"
+    "google.golang.org/grpc/connectivity"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/protobuf/types/known/timestamppb"
+    "ble-gateway/presence"
     pb "ble-gateway/proto"
 )
 
-// gRPC server address
-const BaloginServerAddress = "localhost:50051" // for testing
+// Default BALogin server address
+const DefaultServerAddress = "localhost:50051" // for testing
 
-// Function to create a gRPC client
-func ServiceClient() pb.DeviceServiceClient {
-    conn, err := grpc.Dial(BaloginServerAddress, grpc.WithInsecure(), grpc.WithBlock())
+// Version of the DeviceStatus messages sent by this gateway
+const deviceStatusVersion = 2
+
+// DeviceReport is one login or logout of a device
+type DeviceReport struct {
+    UUID       string
+    Status     int32           // 0: logged out, 1: logged in
+    Reason     presence.Reason // Why the transition happened
+    ObservedAt time.Time       // When the transition was decided
+    RSSI       int             // Last filtered RSSI in dBm
+    Distance   float64         // Estimated distance in meters
+}
+
+// Build the versioned DeviceStatus message; uuid and status stay set for older servers
+func (r DeviceReport) proto(gateway *pb.GatewayIdentity) *pb.DeviceStatus {
+    state := pb.PresenceState_PRESENCE_STATE_ABSENT
+    if r.Status == 1 {
+        state = pb.PresenceState_PRESENCE_STATE_PRESENT
+    }
+
+    report := &pb.DeviceStatus{
+        Uuid:     r.UUID,
+        Status:   r.Status,
+        Gateway:  gateway,
+        Version:  deviceStatusVersion,
+        State:    state,
+        LastRssi: int32(r.RSSI),
+        Distance: r.Distance,
+        Reason:   statusReason(r.Reason, r.Status),
+    }
+    if !r.ObservedAt.IsZero() {
+        report.ObservedAt = timestamppb.New(r.ObservedAt)
+    }
+    return report
+}
+
+// Map a presence reason onto the reason codes of the proto
+func statusReason(reason presence.Reason, status int32) pb.StatusReason {
+    switch reason {
+    case presence.ReasonSignal:
+        if status == 1 {
+            return pb.StatusReason_STATUS_REASON_IN_RANGE
+        }
+        return pb.StatusReason_STATUS_REASON_WEAK_SIGNAL
+    case presence.ReasonTimeout:
+        return pb.StatusReason_STATUS_REASON_TIMEOUT
+    case presence.ReasonInactive:
+        return pb.StatusReason_STATUS_REASON_DEACTIVATED
+    case presence.ReasonConnectFailed:
+        return pb.StatusReason_STATUS_REASON_CONNECT_FAILURE
+    case presence.ReasonManual:
+        return pb.StatusReason_STATUS_REASON_MANUAL
+    case presence.ReasonShutdown:
+        return pb.StatusReason_STATUS_REASON_GATEWAY_SHUTDOWN
+    default:
+        return pb.StatusReason_STATUS_REASON_UNSPECIFIED
+    }
+}
+
+// Client is a managed connection to the BALogin server. It connects in the
+// background, reconnects with exponential backoff whenever the connection
+// drops, and holds status reports until the connection is ready.
+type Client struct {
+    identity Identity
+
+    connMu sync.Mutex
+    cfg    ClientConfig
+    conn   *connection // Replaced when the server address changes
+
+    streamMu sync.Mutex
+    stream   *presenceStream // Open presence stream, nil while it is down
+}
+
+// connection is one gRPC connection to a server address
+type connection struct {
+    address string
+    conn    *grpc.ClientConn
+    service pb.DeviceServiceClient
+    cancel  context.CancelFunc // Stops the state watcher
+}
+
+// ClientConfig describes the connection to the BALogin server
+type ClientConfig struct {
+    Address  string
+    TLS      TLSConfig // TLS when enabled, mutual TLS when it also has a client certificate
+    Identity Identity  // Attached to every report and call
+    Secret   []byte    // Shared HMAC secret signing every call; calls are unsigned if empty
+
+    dialer func(ctx context.Context, address string) (net.Conn, error) // Replaces TCP in tests
+}
+
+// Function to create a gRPC client without waiting for the server
+func NewClient(cfg ClientConfig) (*Client, error) {
+    conn, err := connect(cfg)
+    if err != nil {
+        return nil, err
+    }
+    return &Client{identity: cfg.Identity, cfg: cfg, conn: conn}, nil
+}
+
+// Create a connection that starts connecting now rather than on the first report
+func connect(cfg ClientConfig) (*connection, error) {
+    conn, err := dial(cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    c := &connection{
+        address: cfg.Address,
+        conn:    conn,
+        service: pb.NewDeviceServiceClient(conn),
+        cancel:  cancel,
+    }
+    conn.Connect()
+    go c.watchState(ctx)
+    return c, nil
+}
+
+// Create a connection with the configured transport security, signing and reconnect backoff
+func dial(cfg ClientConfig) (*grpc.ClientConn, error) {
+    address := cfg.Address
+    creds := insecure.NewCredentials()
+    if cfg.TLS.Enabled() {
+        var err error
+        if creds, err = clientCredentials(cfg.TLS, address); err != nil {
+            return nil, fmt.Errorf("failed to set up TLS for %s: %v", address, err)
+        }
+    }
+
+    options := []grpc.DialOption{
+        grpc.WithTransportCredentials(creds),
+        grpc.WithUnaryInterceptor(signingInterceptor(cfg.Identity, cfg.Secret)),
+        grpc.WithStreamInterceptor(signingStreamInterceptor(cfg.Identity, cfg.Secret)),
+        grpc.WithConnectParams(grpc.ConnectParams{
+            Backoff: backoff.Config{
+                BaseDelay:  1 * time.Second,
+                Multiplier: 1.6,
+                Jitter:     0.2,
+                MaxDelay:   30 * time.Second,
+            },
+            MinConnectTimeout: 5 * time.Second,
+        }),
+    }
+    if cfg.dialer != nil {
+        options = append(options, grpc.WithContextDialer(cfg.dialer))
+    }
+    conn, err := grpc.NewClient(address, options...)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create gRPC client for %s: %v", address, err)
+    }
+    return conn, nil
+}
+
+// Return the current connection
+func (c *Client) connection() *connection {
+    c.connMu.Lock()
+    defer c.connMu.Unlock()
+    return c.conn
+}
+
+// Address returns the address of the BALogin server
+func (c *Client) Address() string {
+    return c.connection().address
+}
+
+// SetAddress switches to another BALogin server. Calls in progress on the old
+// connection fail and are retried by their callers, and the presence stream is
+// reopened on the new one.
+func (c *Client) SetAddress(address string) error {
+    c.connMu.Lock()
+    defer c.connMu.Unlock()
+
+    cfg := c.cfg
+    cfg.Address = address
+    conn, err := connect(cfg)
+    if err != nil {
+        return err
+    }
+    old := c.conn
+    c.cfg, c.conn = cfg, conn
+
+    log.Printf("Switched BALogin server from %s to %s", old.address, address)
+    old.close()
+    return nil
+}
+
+// State returns the current connection state
+func (c *Client) State() connectivity.State {
+    return c.connection().conn.GetState()
+}
+
+// WaitForReady blocks until the connection is ready or ctx is done
+func (c *Client) WaitForReady(ctx context.Context) error {
+    for {
+        conn := c.connection()
+        state := conn.conn.GetState()
+        if state == connectivity.Ready {
+            return nil
+        }
+        if state == connectivity.Shutdown {
+            if conn != c.connection() {
+                continue // Replaced by SetAddress
+            }
+            return fmt.Errorf("connection to %s is closed", conn.address)
+        }
+        if state == connectivity.Idle {
+            conn.conn.Connect()
+        }
+        if !conn.conn.WaitForStateChange(ctx, state) {
+            return fmt.Errorf("connection to %s not ready (%s): %v", conn.address, state, ctx.Err())
+        }
+    }
+}
+
+// SendDeviceStatus sends the report on the presence stream if it is open,
+// otherwise it waits for the connection and makes a unary call
+func (c *Client) SendDeviceStatus(ctx context.Context, report DeviceReport) error {
+    message := report.proto(c.identity.proto())
+    if stream := c.currentStream(); stream != nil {
+        err := stream.sendAndWait(ctx, &pb.GatewayMessage{Body: &pb.GatewayMessage_Status{Status: message}})
+        if err != nil {
+            log.Printf("Failed to send device status on the presence stream: %v", err)
+            return err
+        }
+        log.Printf("Device status for %s acknowledged on the presence stream", report.UUID)
+        return nil
+    }
+
+    if err := c.WaitForReady(ctx); err != nil {
+        return err
+    }
+    return sendDeviceStatus(ctx, c.connection().service, message)
+}
+
+// SyncPresence sends the full list of logged in users once the connection is ready
+func (c *Client) SyncPresence(ctx context.Context, takenAt time.Time, present []DeviceReport) error {
+    gateway := c.identity.proto()
+    snapshot := &pb.PresenceSnapshot{Gateway: gateway, TakenAt: timestamppb.New(takenAt)}
+    for _, report := range present {
+        snapshot.Present = append(snapshot.Present, report.proto(gateway))
+    }
+
+    if err := c.WaitForReady(ctx); err != nil {
+        return err
+    }
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+    defer cancel()
+    res, err := c.connection().service.SyncPresence(ctx, snapshot)
     if err != nil {
-        log.Printf("Failed to connect to gRPC server: %v", err)
-        return nil // Return nil if the connection fails, allowing the caller to handle the error
+        return err
     }
-    return pb.NewDeviceServiceClient(conn)
+    log.Printf("Presence snapshot of %d users sent: %s", len(present), res.Message)
+    return nil
+}
+
+// Close the connection
+func (c *Client) Close() error {
+    return c.connection().close()
+}
+
+func (c *connection) close() error {
+    c.cancel()
+    return c.conn.Close()
+}
+
+// Log every connection state change
+func (c *connection) watchState(ctx context.Context) {
+    state := c.conn.GetState()
+    for c.conn.WaitForStateChange(ctx, state) {
+        state = c.conn.GetState()
+        log.Printf("Connection to BALogin server %s: %s", c.address, state)
+    }
+}
+
+// Function to send a full device report via gRPC
+func SendDeviceReport(client pb.DeviceServiceClient, report DeviceReport) error {
+    return sendDeviceStatus(context.Background(), client, report.proto(nil))
 }
 
-// Function to send device status via gRPC
-func SendDeviceStatus(client pb.DeviceServiceClient, uuid string, status int32) {
+func sendDeviceStatus(ctx context.Context, client pb.DeviceServiceClient, report *pb.DeviceStatus) error {
     if client == nil {
         log.Printf("Client is not initialized")
-        return
+        return fmt.Errorf("client is not initialized")
     }
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // Set a 5-second timeout
+    ctx, cancel := context.WithTimeout(ctx, 5*time.Second) // Set a 5-second timeout, or less if ctx ends sooner
     defer cancel()
 
     // Send BLE device status to the server
-    res, err := client.SendDeviceStatus(ctx, &pb.DeviceStatus{
-        Uuid:   uuid,
-        Status: status,
-    })
+    res, err := client.SendDeviceStatus(ctx, report)
 
     if err != nil {
         log.Printf("Failed to send device status: %v", err)
-        return
+        return err
     }
     log.Printf("Response from server: %s\n", res.Message)
+    return nil
 }
@@ -0,0 +1,170 @@
+package handler
+
+import (
+    "context"
+    "fmt"
+    "net"
+    "sync"
+    "testing"
+    "time"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/connectivity"
+    "google.golang.org/grpc/test/bufconn"
+    pb "ble-gateway/proto"
+)
+
+// hangingClient is a DeviceService that answers only when the call is cancelled
+type hangingClient struct {
+    pb.DeviceServiceClient
+}
+
+func (c hangingClient) SendDeviceStatus(ctx context.Context, in *pb.DeviceStatus, opts ...grpc.CallOption) (*pb.Response, error) {
+    <-ctx.Done()
+    return nil, ctx.Err()
+}
+
+func TestSendDeviceStatusHonorsDeadline(t *testing.T) {
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+
+    start := time.Now()
+    if err := sendDeviceStatus(ctx, hangingClient{}, &pb.DeviceStatus{Uuid: testUUID}); err == nil {
+        t.Fatal("send to a hanging server succeeded")
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("send returned after %v, past the caller's 50ms deadline", elapsed)
+    }
+}
+
+// testNetwork connects clients to in-process servers by address
+type testNetwork struct {
+    mu        sync.Mutex
+    listeners map[string]*bufconn.Listener
+}
+
+func newTestNetwork() *testNetwork {
+    return &testNetwork{listeners: make(map[string]*bufconn.Listener)}
+}
+
+func (n *testNetwork) dial(ctx context.Context, address string) (net.Conn, error) {
+    n.mu.Lock()
+    lis := n.listeners[address]
+    n.mu.Unlock()
+    if lis == nil {
+        return nil, fmt.Errorf("connection to %s refused", address)
+    }
+    return lis.DialContext(ctx)
+}
+
+// statusServer is a DeviceService that records the reports it receives
+type statusServer struct {
+    pb.UnimplementedDeviceServiceServer
+    received chan *pb.DeviceStatus
+}
+
+func (s *statusServer) SendDeviceStatus(ctx context.Context, in *pb.DeviceStatus) (*pb.Response, error) {
+    s.received <- in
+    return &pb.Response{Message: "ok"}, nil
+}
+
+// Serve a DeviceService on address until the test ends or the returned server is stopped
+func (n *testNetwork) serve(t *testing.T, address string) (*grpc.Server, *statusServer) {
+    t.Helper()
+    lis := bufconn.Listen(1 << 16)
+    service := &statusServer{received: make(chan *pb.DeviceStatus, 16)}
+    server := grpc.NewServer()
+    pb.RegisterDeviceServiceServer(server, service)
+    go server.Serve(lis)
+    t.Cleanup(server.Stop)
+
+    n.mu.Lock()
+    n.listeners[address] = lis
+    n.mu.Unlock()
+    return server, service
+}
+
+func (n *testNetwork) client(t *testing.T, address string) *Client {
+    t.Helper()
+    client, err := NewClient(ClientConfig{Address: "passthrough:///" + address, Identity: Identity{ID: "gw-1"}, dialer: n.dial})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { client.Close() })
+    return client
+}
+
+// Send a report and check that service received it
+func sendAndReceive(t *testing.T, client *Client, service *statusServer, uuid string) {
+    t.Helper()
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := client.SendDeviceStatus(ctx, DeviceReport{UUID: uuid, Status: 1}); err != nil {
+        t.Fatalf("send of %s failed: %v", uuid, err)
+    }
+    select {
+    case received := <-service.received:
+        if received.Uuid != uuid || received.Gateway.GetId() != "gw-1" {
+            t.Errorf("server received %v, want %s from gw-1", received, uuid)
+        }
+    default:
+        t.Fatalf("report of %s did not reach the server", uuid)
+    }
+}
+
+func TestClientWaitForReady(t *testing.T) {
+    network := newTestNetwork()
+    client := network.client(t, "server-a")
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    if err := client.WaitForReady(ctx); err == nil {
+        t.Fatal("ready without a server")
+    }
+
+    // Once the server is up, the client connects on its next attempt
+    network.serve(t, "server-a")
+    ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := client.WaitForReady(ctx); err != nil {
+        t.Fatalf("not ready once the server is up: %v", err)
+    }
+    if state := client.State(); state != connectivity.Ready {
+        t.Errorf("state %s, want READY", state)
+    }
+
+    client.Close()
+    if err := client.WaitForReady(context.Background()); err == nil {
+        t.Error("closed client ready")
+    }
+}
+
+func TestClientReconnects(t *testing.T) {
+    network := newTestNetwork()
+    server, service := network.serve(t, "server-a")
+    client := network.client(t, "server-a")
+    sendAndReceive(t, client, service, testUUID)
+
+    // The server restarts; reports reach it again once the client has reconnected
+    server.Stop()
+    _, service = network.serve(t, "server-a")
+    sendAndReceive(t, client, service, "223e4567-e89b-12d3-a456-426614174000")
+}
+
+func TestClientSetAddress(t *testing.T) {
+    network := newTestNetwork()
+    _, first := network.serve(t, "server-a")
+    _, second := network.serve(t, "server-b")
+    client := network.client(t, "server-a")
+    sendAndReceive(t, client, first, testUUID)
+
+    if err := client.SetAddress("passthrough:///server-b"); err != nil {
+        t.Fatalf("SetAddress failed: %v", err)
+    }
+    if address := client.Address(); address != "passthrough:///server-b" {
+        t.Errorf("Address() = %s after SetAddress, want passthrough:///server-b", address)
+    }
+    sendAndReceive(t, client, second, "223e4567-e89b-12d3-a456-426614174000")
+    if len(first.received) != 0 {
+        t.Error("report sent to the old server after SetAddress")
+    }
+}
@@ -0,0 +1,298 @@
+package handler
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "sync"
+    "time"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/types/known/timestamppb"
+    pb "ble-gateway/proto"
+)
+
+// Presence stream timing
+const (
+    DefaultHeartbeatInterval = 30 * time.Second
+    ackTimeout               = 10 * time.Second // Wait for the server to acknowledge a report or heartbeat
+    streamInitialBackoff     = 1 * time.Second
+    streamMaxBackoff         = 30 * time.Second
+    unsupportedRetryDelay    = 5 * time.Minute // Retry delay when the server has no PresenceStream
+)
+
+// StreamHandlers connect the commands received on the presence stream to the gateway.
+// A nil handler makes the command fail with "not supported".
+type StreamHandlers struct {
+    ForceLogout      func(uuid string) error
+    RefreshWhitelist func() error
+    AssignUUID       func(requestID string) (string, error) // Returns the activated UUID
+    Stats            func() (present, queued int)           // Counters sent with every heartbeat
+}
+
+// presenceStream is one open PresenceStream call
+type presenceStream struct {
+    stream     pb.DeviceService_PresenceStreamClient
+    ackTimeout time.Duration
+
+    sendMu sync.Mutex // Serializes Send, which is not safe for concurrent use
+
+    mu       sync.Mutex
+    sequence uint64
+    pending  map[uint64]chan error // Sequence -> waiter for its ack
+    err      error                 // Set once the stream has failed
+}
+
+func newPresenceStream(stream pb.DeviceService_PresenceStreamClient) *presenceStream {
+    return &presenceStream{
+        stream:     stream,
+        ackTimeout: ackTimeout,
+        pending:    make(map[uint64]chan error),
+    }
+}
+
+// Send a message; if acked is set, the server's ack (or the stream's failure) is delivered to it
+func (s *presenceStream) send(msg *pb.GatewayMessage, acked chan error) (uint64, error) {
+    s.sendMu.Lock()
+    defer s.sendMu.Unlock()
+
+    s.mu.Lock()
+    if s.err != nil {
+        s.mu.Unlock()
+        return 0, s.err
+    }
+    s.sequence++
+    msg.Sequence = s.sequence
+    if acked != nil {
+        s.pending[msg.Sequence] = acked
+    }
+    s.mu.Unlock()
+
+    if err := s.stream.Send(msg); err != nil {
+        s.forget(msg.Sequence)
+        return 0, err
+    }
+    return msg.Sequence, nil
+}
+
+// Send a message and wait for the server to acknowledge it
+func (s *presenceStream) sendAndWait(ctx context.Context, msg *pb.GatewayMessage) error {
+    acked := make(chan error, 1)
+    sequence, err := s.send(msg, acked)
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, s.ackTimeout)
+    defer cancel()
+    select {
+    case err := <-acked:
+        return err
+    case <-ctx.Done():
+        s.forget(sequence)
+        return fmt.Errorf("no ack for message %d: %v", sequence, ctx.Err())
+    }
+}
+
+func (s *presenceStream) forget(sequence uint64) {
+    s.mu.Lock()
+    delete(s.pending, sequence)
+    s.mu.Unlock()
+}
+
+func (s *presenceStream) ack(sequence uint64) {
+    s.mu.Lock()
+    acked, exists := s.pending[sequence]
+    delete(s.pending, sequence)
+    s.mu.Unlock()
+    if exists {
+        acked <- nil
+    }
+}
+
+// Fail the stream and every message still waiting for an ack
+func (s *presenceStream) fail(err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.err != nil {
+        return
+    }
+    s.err = err
+    for sequence, acked := range s.pending {
+        acked <- err
+        delete(s.pending, sequence)
+    }
+}
+
+// Return the open presence stream, or nil
+func (c *Client) currentStream() *presenceStream {
+    c.streamMu.Lock()
+    defer c.streamMu.Unlock()
+    return c.stream
+}
+
+func (c *Client) setStream(s *presenceStream) {
+    c.streamMu.Lock()
+    c.stream = s
+    c.streamMu.Unlock()
+}
+
+// RunStream keeps a presence stream to the server open until ctx is done.
+// While it is open, status reports are sent on it instead of as unary calls,
+// and the server's commands are executed with handlers.
+func (c *Client) RunStream(ctx context.Context, handlers StreamHandlers, heartbeat time.Duration) {
+    backoff := streamInitialBackoff
+    for {
+        opened, err := c.runStream(ctx, handlers, heartbeat)
+        if ctx.Err() != nil {
+            return
+        }
+        if opened {
+            backoff = streamInitialBackoff
+        }
+
+        var delay time.Duration
+        delay, backoff = retryDelay(err, backoff)
+        if status.Code(err) == codes.Unimplemented {
+            log.Printf("BALogin server %s does not support the presence stream, using unary calls (retrying in %v)", c.Address(), delay)
+        } else {
+            log.Printf("Presence stream to %s closed: %v (reconnecting in %v)", c.Address(), err, delay)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(delay):
+        }
+    }
+}
+
+// Return how long to wait before reopening a stream that failed with err, and
+// the backoff after that. A server without PresenceStream is asked again only
+// rarely, and does not grow the backoff.
+func retryDelay(err error, backoff time.Duration) (delay time.Duration, next time.Duration) {
+    if status.Code(err) == codes.Unimplemented {
+        return unsupportedRetryDelay, backoff
+    }
+    if next = backoff * 2; next > streamMaxBackoff {
+        next = streamMaxBackoff
+    }
+    return backoff, next
+}
+
+// Open one stream and serve it until it fails; opened reports whether the server answered
+func (c *Client) runStream(ctx context.Context, handlers StreamHandlers, heartbeat time.Duration) (opened bool, err error) {
+    if err := c.WaitForReady(ctx); err != nil {
+        return false, err
+    }
+
+    conn := c.connection()
+
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    raw, err := conn.service.PresenceStream(ctx)
+    if err != nil {
+        return false, err
+    }
+    return c.serve(ctx, newPresenceStream(raw), handlers, heartbeat, conn.address)
+}
+
+// Send heartbeats on an opened stream and handle what the server sends until it fails
+func (c *Client) serve(ctx context.Context, s *presenceStream, handlers StreamHandlers, heartbeat time.Duration, address string) (opened bool, err error) {
+    defer func() {
+        c.setStream(nil)
+        s.fail(fmt.Errorf("presence stream closed"))
+    }()
+
+    received := make(chan error, 1)
+    go func() {
+        received <- c.receive(s, handlers)
+    }()
+
+    ticker := time.NewTicker(heartbeat)
+    defer ticker.Stop()
+    for {
+        // The first heartbeat's ack shows the server supports the stream
+        if err := s.sendAndWait(ctx, newHeartbeat(handlers)); err != nil {
+            if err == io.EOF {
+                err = <-received // The stream has ended, Recv has its status
+            }
+            return opened, err
+        }
+        if !opened {
+            opened = true
+            c.setStream(s)
+            log.Printf("Presence stream to %s open", address)
+        }
+
+        select {
+        case err := <-received:
+            return opened, err
+        case <-ctx.Done():
+            return opened, ctx.Err()
+        case <-ticker.C:
+        }
+    }
+}
+
+// Handle acks and commands until the stream fails
+func (c *Client) receive(s *presenceStream, handlers StreamHandlers) error {
+    for {
+        msg, err := s.stream.Recv()
+        if err != nil {
+            s.fail(err)
+            return err
+        }
+
+        switch body := msg.Body.(type) {
+        case *pb.ServerMessage_Ack:
+            s.ack(body.Ack.Sequence)
+        case *pb.ServerMessage_Command:
+            go execute(s, handlers, body.Command)
+        }
+    }
+}
+
+// Run one command and report its result on the stream
+func execute(s *presenceStream, handlers StreamHandlers, command *pb.Command) {
+    result := &pb.CommandResult{CommandId: command.Id, Success: true}
+    err := fmt.Errorf("not supported")
+
+    switch action := command.Action.(type) {
+    case *pb.Command_ForceLogout:
+        if handlers.ForceLogout != nil {
+            err = handlers.ForceLogout(action.ForceLogout.Uuid)
+        }
+    case *pb.Command_RefreshWhitelist:
+        if handlers.RefreshWhitelist != nil {
+            err = handlers.RefreshWhitelist()
+        }
+    case *pb.Command_AssignUuid:
+        if handlers.AssignUUID != nil {
+            result.Message, err = handlers.AssignUUID(action.AssignUuid.RequestId)
+        }
+    }
+
+    if err != nil {
+        result.Success = false
+        result.Message = err.Error()
+        log.Printf("Command %s failed: %v", command.Id, err)
+    } else {
+        fmt.Printf("Command %s executed.\n", command.Id)
+    }
+
+    if _, err := s.send(&pb.GatewayMessage{Body: &pb.GatewayMessage_Result{Result: result}}, nil); err != nil {
+        log.Printf("Failed to send result of command %s: %v", command.Id, err)
+    }
+}
+
+func newHeartbeat(handlers StreamHandlers) *pb.GatewayMessage {
+    heartbeat := &pb.Heartbeat{SentAt: timestamppb.Now()}
+    if handlers.Stats != nil {
+        present, queued := handlers.Stats()
+        heartbeat.PresentDevices = uint32(present)
+        heartbeat.QueuedReports = uint32(queued)
+    }
+    return &pb.GatewayMessage{Body: &pb.GatewayMessage_Heartbeat{Heartbeat: heartbeat}}
+}
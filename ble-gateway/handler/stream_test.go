@@ -0,0 +1,275 @@
+package handler
+
+import (
+    "context"
+    "errors"
+    "io"
+    "sync"
+    "testing"
+    "time"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    pb "ble-gateway/proto"
+)
+
+// fakeStream is a PresenceStream client whose server side is driven by the test
+type fakeStream struct {
+    grpc.ClientStream
+
+    sent     chan *pb.GatewayMessage
+    received chan *pb.ServerMessage
+
+    closeOnce sync.Once
+    closed    chan struct{}
+    err       error // Returned by Recv once closed
+}
+
+func newFakeStream() *fakeStream {
+    return &fakeStream{
+        sent:     make(chan *pb.GatewayMessage, 16),
+        received: make(chan *pb.ServerMessage, 16),
+        closed:   make(chan struct{}),
+    }
+}
+
+func (f *fakeStream) Send(msg *pb.GatewayMessage) error {
+    select {
+    case <-f.closed:
+        return io.EOF
+    case f.sent <- msg:
+        return nil
+    }
+}
+
+func (f *fakeStream) Recv() (*pb.ServerMessage, error) {
+    select {
+    case <-f.closed:
+        return nil, f.err
+    case msg := <-f.received:
+        return msg, nil
+    }
+}
+
+// End the stream from the server side; Recv returns err from then on
+func (f *fakeStream) close(err error) {
+    f.closeOnce.Do(func() {
+        f.err = err
+        close(f.closed)
+    })
+}
+
+func (f *fakeStream) ack(sequence uint64) {
+    f.received <- &pb.ServerMessage{Body: &pb.ServerMessage_Ack{Ack: &pb.Ack{Sequence: sequence}}}
+}
+
+// Wait for the gateway's next message
+func (f *fakeStream) next(t *testing.T) *pb.GatewayMessage {
+    t.Helper()
+    select {
+    case msg := <-f.sent:
+        return msg
+    case <-time.After(time.Second):
+        t.Fatal("gateway sent nothing")
+        return nil
+    }
+}
+
+// Send a status report and return the channel its result arrives on
+func sendStatus(s *presenceStream, uuid string) <-chan error {
+    result := make(chan error, 1)
+    go func() {
+        result <- s.sendAndWait(context.Background(), &pb.GatewayMessage{Body: &pb.GatewayMessage_Status{Status: &pb.DeviceStatus{Uuid: uuid}}})
+    }()
+    return result
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+    t.Helper()
+    select {
+    case err := <-result:
+        return err
+    case <-time.After(time.Second):
+        t.Fatal("send is still waiting for its ack")
+        return nil
+    }
+}
+
+func TestStreamAcksMatchedBySequence(t *testing.T) {
+    fake := newFakeStream()
+    defer fake.close(io.EOF)
+    s := newPresenceStream(fake)
+    go (&Client{}).receive(s, StreamHandlers{})
+
+    first := sendStatus(s, "first")
+    firstMsg := fake.next(t)
+    second := sendStatus(s, "second")
+    secondMsg := fake.next(t)
+    if firstMsg.Sequence == secondMsg.Sequence {
+        t.Fatalf("both messages sent with sequence %d", firstMsg.Sequence)
+    }
+
+    // An ack releases only the message with its sequence
+    fake.ack(secondMsg.Sequence)
+    if err := waitResult(t, second); err != nil {
+        t.Errorf("acked send failed: %v", err)
+    }
+    select {
+    case err := <-first:
+        t.Fatalf("send returned (%v) on the ack of another message", err)
+    case <-time.After(50 * time.Millisecond):
+    }
+
+    fake.ack(secondMsg.Sequence + 100) // Unknown sequences are ignored
+    fake.ack(firstMsg.Sequence)
+    if err := waitResult(t, first); err != nil {
+        t.Errorf("acked send failed: %v", err)
+    }
+}
+
+func TestStreamFailReleasesWaiters(t *testing.T) {
+    fake := newFakeStream()
+    s := newPresenceStream(fake)
+
+    var results []<-chan error
+    for _, uuid := range []string{"a", "b", "c"} {
+        results = append(results, sendStatus(s, uuid))
+        fake.next(t)
+    }
+
+    broken := errors.New("connection reset")
+    s.fail(broken)
+    for i, result := range results {
+        if err := waitResult(t, result); !errors.Is(err, broken) {
+            t.Errorf("send %d returned %v, want %v", i, err, broken)
+        }
+    }
+    if _, err := s.send(&pb.GatewayMessage{}, nil); !errors.Is(err, broken) {
+        t.Errorf("send on a failed stream returned %v, want %v", err, broken)
+    }
+}
+
+func TestStreamHeartbeatTimeout(t *testing.T) {
+    fake := newFakeStream()
+    defer fake.close(io.EOF)
+    s := newPresenceStream(fake)
+    s.ackTimeout = 50 * time.Millisecond
+
+    client := &Client{}
+    opened, err := client.serve(context.Background(), s, StreamHandlers{}, time.Hour, "test")
+    if opened || err == nil {
+        t.Fatalf("serve without acks = (%v, %v), want a failure before the stream opens", opened, err)
+    }
+    if msg := fake.next(t); msg.GetHeartbeat() == nil {
+        t.Errorf("first message is %v, want a heartbeat", msg)
+    }
+    if client.currentStream() != nil {
+        t.Error("reports are sent on a stream the server never acknowledged")
+    }
+}
+
+func TestStreamOpensOnHeartbeatAck(t *testing.T) {
+    fake := newFakeStream()
+    s := newPresenceStream(fake)
+    client := &Client{}
+
+    served := make(chan error, 1)
+    go func() {
+        _, err := client.serve(context.Background(), s, StreamHandlers{Stats: func() (int, int) { return 3, 2 }}, time.Hour, "test")
+        served <- err
+    }()
+
+    heartbeat := fake.next(t).GetHeartbeat()
+    if heartbeat == nil || heartbeat.PresentDevices != 3 || heartbeat.QueuedReports != 2 {
+        t.Fatalf("heartbeat = %v, want 3 present devices and 2 queued reports", heartbeat)
+    }
+    fake.ack(1)
+    deadline := time.Now().Add(time.Second)
+    for client.currentStream() != s {
+        if time.Now().After(deadline) {
+            t.Fatal("stream not used for reports after its heartbeat was acknowledged")
+        }
+        time.Sleep(time.Millisecond)
+    }
+
+    fake.close(io.EOF)
+    if err := <-served; err != io.EOF {
+        t.Errorf("serve returned %v, want the stream's error", err)
+    }
+    if client.currentStream() != nil {
+        t.Error("closed stream still used for reports")
+    }
+}
+
+func TestStreamUnimplementedFallback(t *testing.T) {
+    fake := newFakeStream()
+    fake.close(status.Error(codes.Unimplemented, "unknown method PresenceStream"))
+    client := &Client{}
+
+    opened, err := client.serve(context.Background(), newPresenceStream(fake), StreamHandlers{}, time.Hour, "test")
+    if opened || status.Code(err) != codes.Unimplemented {
+        t.Fatalf("serve = (%v, %v), want Unimplemented before the stream opens", opened, err)
+    }
+    if client.currentStream() != nil {
+        t.Error("reports are sent on a stream the server does not support")
+    }
+
+    // The server is asked again only after the long delay, and the backoff does not grow
+    delay, next := retryDelay(err, streamInitialBackoff)
+    if delay != unsupportedRetryDelay || next != streamInitialBackoff {
+        t.Errorf("retryDelay(Unimplemented) = (%v, %v), want (%v, %v)", delay, next, unsupportedRetryDelay, streamInitialBackoff)
+    }
+    delay, next = retryDelay(io.EOF, streamInitialBackoff)
+    if delay != streamInitialBackoff || next != 2*streamInitialBackoff {
+        t.Errorf("retryDelay(EOF) = (%v, %v), want (%v, %v)", delay, next, streamInitialBackoff, 2*streamInitialBackoff)
+    }
+    if _, next = retryDelay(io.EOF, streamMaxBackoff); next != streamMaxBackoff {
+        t.Errorf("backoff grew past %v to %v", streamMaxBackoff, next)
+    }
+}
+
+func TestStreamCommandResults(t *testing.T) {
+    failed := errors.New("nothing to do")
+    handlers := StreamHandlers{
+        ForceLogout: func(uuid string) error {
+            if uuid != testUUID {
+                return failed
+            }
+            return nil
+        },
+        RefreshWhitelist: func() error { return nil },
+        AssignUUID: func(requestID string) (string, error) {
+            return testUUID, nil
+        },
+    }
+
+    tests := []struct {
+        name        string
+        handlers    StreamHandlers
+        command     *pb.Command
+        wantSuccess bool
+        wantMessage string
+    }{
+        {"force logout", handlers, &pb.Command{Action: &pb.Command_ForceLogout{ForceLogout: &pb.ForceLogout{Uuid: testUUID}}}, true, ""},
+        {"force logout failing", handlers, &pb.Command{Action: &pb.Command_ForceLogout{ForceLogout: &pb.ForceLogout{Uuid: "other"}}}, false, "nothing to do"},
+        {"refresh whitelist", handlers, &pb.Command{Action: &pb.Command_RefreshWhitelist{RefreshWhitelist: &pb.RefreshWhitelist{}}}, true, ""},
+        {"assign uuid", handlers, &pb.Command{Action: &pb.Command_AssignUuid{AssignUuid: &pb.AssignUUID{RequestId: "signup-1"}}}, true, testUUID},
+        {"no handler", StreamHandlers{}, &pb.Command{Action: &pb.Command_RefreshWhitelist{RefreshWhitelist: &pb.RefreshWhitelist{}}}, false, "not supported"},
+        {"unknown action", handlers, &pb.Command{}, false, "not supported"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            fake := newFakeStream()
+            tt.command.Id = "command-" + tt.name
+            execute(newPresenceStream(fake), tt.handlers, tt.command)
+
+            result := fake.next(t).GetResult()
+            if result == nil {
+                t.Fatal("no command result sent")
+            }
+            if result.CommandId != tt.command.Id || result.Success != tt.wantSuccess || result.Message != tt.wantMessage {
+                t.Errorf("result = %+v, want id %q, success %v, message %q", result, tt.command.Id, tt.wantSuccess, tt.wantMessage)
+            }
+        })
+    }
+}
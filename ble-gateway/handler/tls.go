@@ -0,0 +1,228 @@
+package handler
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "strings"
+    "sync"
+    "time"
+    "google.golang.org/grpc/credentials"
+)
+
+// How often certificate files are checked for changes
+const certReloadInterval = 10 * time.Second
+
+// TLSConfig holds the certificate paths for one side of a gRPC connection.
+// TLS is off when all paths are empty.
+type TLSConfig struct {
+    CertFile   string `yaml:"cert_file"`   // Own certificate chain (PEM)
+    KeyFile    string `yaml:"key_file"`    // Private key of CertFile (PEM)
+    CAFile     string `yaml:"ca_file"`     // CA bundle used to verify the peer (PEM); on a server, setting it requires client certificates
+    ServerName string `yaml:"server_name"` // Client only: expected server name, if it differs from the dialed host
+}
+
+// Enabled reports whether any TLS setting is present
+func (c TLSConfig) Enabled() bool {
+    return c.CertFile != "" || c.KeyFile != "" || c.CAFile != ""
+}
+
+// Validate checks that the settings are complete
+func (c TLSConfig) Validate() error {
+    if (c.CertFile == "") != (c.KeyFile == "") {
+        return fmt.Errorf("certificate and key must be given together")
+    }
+    return nil
+}
+
+// certStore holds certificates loaded from disk and reloads them when the
+// files change, so certificates can be rotated without a restart
+type certStore struct {
+    cfg TLSConfig
+
+    mu       sync.Mutex
+    cert     *tls.Certificate
+    pool     *x509.CertPool
+    modTimes map[string]time.Time
+    checked  time.Time
+}
+
+func newCertStore(cfg TLSConfig) (*certStore, error) {
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    s := &certStore{cfg: cfg}
+    if err := s.load(); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+// Read every configured file
+func (s *certStore) load() error {
+    modTimes := make(map[string]time.Time)
+    for _, path := range []string{s.cfg.CertFile, s.cfg.KeyFile, s.cfg.CAFile} {
+        if path == "" {
+            continue
+        }
+        info, err := os.Stat(path)
+        if err != nil {
+            return fmt.Errorf("failed to read %s: %v", path, err)
+        }
+        modTimes[path] = info.ModTime()
+    }
+
+    var cert *tls.Certificate
+    if s.cfg.CertFile != "" {
+        loaded, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+        if err != nil {
+            return fmt.Errorf("failed to load certificate: %v", err)
+        }
+        cert = &loaded
+    }
+
+    var pool *x509.CertPool
+    if s.cfg.CAFile != "" {
+        pem, err := os.ReadFile(s.cfg.CAFile)
+        if err != nil {
+            return fmt.Errorf("failed to read CA file: %v", err)
+        }
+        pool = x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return fmt.Errorf("no certificates found in CA file %s", s.cfg.CAFile)
+        }
+    }
+
+    s.cert, s.pool, s.modTimes = cert, pool, modTimes
+    return nil
+}
+
+// Return the current certificate and CA pool, reloading them if the files changed.
+// A failed reload keeps the previous certificates.
+func (s *certStore) current() (*tls.Certificate, *x509.CertPool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if time.Since(s.checked) < certReloadInterval {
+        return s.cert, s.pool
+    }
+    s.checked = time.Now()
+
+    for path, modTime := range s.modTimes {
+        info, err := os.Stat(path)
+        if err != nil || info.ModTime().Equal(modTime) {
+            continue
+        }
+        if err := s.load(); err != nil {
+            log.Printf("Failed to reload certificates, keeping the previous ones: %v", err)
+        } else {
+            log.Printf("Reloaded certificates (%s changed)", path)
+        }
+        break
+    }
+    return s.cert, s.pool
+}
+
+// Client transport credentials: verifies the server dialed at address against
+// the CA file (or the system roots) and presents the client certificate for mutual TLS
+func clientCredentials(cfg TLSConfig, address string) (credentials.TransportCredentials, error) {
+    store, err := newCertStore(cfg)
+    if err != nil {
+        return nil, err
+    }
+    serverName := expectedServerName(cfg, address)
+    if serverName == "" {
+        return nil, fmt.Errorf("no server name to verify the certificate of %q against, set the server name", address)
+    }
+
+    tlsConfig := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        ServerName: serverName,
+        GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+            cert, _ := store.current()
+            if cert == nil {
+                return &tls.Certificate{}, nil // No client certificate configured
+            }
+            return cert, nil
+        },
+    }
+
+    if cfg.CAFile != "" {
+        // Verify against the current CA pool ourselves so a rotated CA takes effect
+        // without reconnecting; the built-in verification only knows a fixed pool
+        tlsConfig.InsecureSkipVerify = true
+        tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+            _, pool := store.current()
+            // state.ServerName is empty when dialing an IP address, so the name is fixed up front
+            return verifyChain(state, pool, x509.ExtKeyUsageServerAuth, serverName)
+        }
+    }
+    return credentials.NewTLS(tlsConfig), nil
+}
+
+// Name the server certificate must hold: the configured server name, or the
+// host of address (an IP address is checked against the IP SANs)
+func expectedServerName(cfg TLSConfig, address string) string {
+    if cfg.ServerName != "" {
+        return cfg.ServerName
+    }
+    if i := strings.Index(address, ":///"); i >= 0 {
+        address = address[i+4:] // Drop a resolver scheme such as dns:///
+    }
+    host, _, err := net.SplitHostPort(address)
+    if err != nil {
+        return address // No port
+    }
+    return host
+}
+
+// Server transport credentials: presents the server certificate and, when a
+// CA file is configured, requires and verifies client certificates (mutual TLS)
+func serverCredentials(cfg TLSConfig) (credentials.TransportCredentials, error) {
+    if cfg.CertFile == "" {
+        return nil, fmt.Errorf("a server certificate and key are required for TLS")
+    }
+    store, err := newCertStore(cfg)
+    if err != nil {
+        return nil, err
+    }
+
+    tlsConfig := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+            cert, pool := store.current()
+            config := &tls.Config{
+                MinVersion:   tls.VersionTLS12,
+                Certificates: []tls.Certificate{*cert},
+                NextProtos:   []string{"h2"},
+            }
+            if pool != nil {
+                config.ClientCAs = pool
+                config.ClientAuth = tls.RequireAndVerifyClientCert
+            }
+            return config, nil
+        },
+    }
+    return credentials.NewTLS(tlsConfig), nil
+}
+
+// Verify the peer's certificate chain against pool
+func verifyChain(state tls.ConnectionState, pool *x509.CertPool, usage x509.ExtKeyUsage, serverName string) error {
+    if len(state.PeerCertificates) == 0 {
+        return fmt.Errorf("peer presented no certificate")
+    }
+    intermediates := x509.NewCertPool()
+    for _, cert := range state.PeerCertificates[1:] {
+        intermediates.AddCert(cert)
+    }
+    _, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+        Roots:         pool,
+        Intermediates: intermediates,
+        DNSName:       serverName,
+        KeyUsages:     []x509.ExtKeyUsage{usage},
+    })
+    return err
+}
@@ -0,0 +1,273 @@
+package handler
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// testCA issues certificates for the TLS tests
+type testCA struct {
+    cert *x509.Certificate
+    key  *ecdsa.PrivateKey
+    file string // CA certificate (PEM)
+}
+
+var serial int64
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serial++
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(serial),
+        Subject:               pkix.Name{CommonName: name},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    file := filepath.Join(dir, name+".pem")
+    writePEM(t, file, "CERTIFICATE", der)
+    return &testCA{cert: cert, key: key, file: file}
+}
+
+// Issue a certificate and key for names (DNS names or IP addresses); returns the file paths
+func (ca *testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage, notAfter time.Time, names ...string) (certFile, keyFile string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serial++
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(serial),
+        Subject:      pkix.Name{CommonName: name},
+        NotBefore:    time.Now().Add(-2 * time.Hour),
+        NotAfter:     notAfter,
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+    }
+    for _, n := range names {
+        if ip := net.ParseIP(n); ip != nil {
+            template.IPAddresses = append(template.IPAddresses, ip)
+        } else {
+            template.DNSNames = append(template.DNSNames, n)
+        }
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    certFile = filepath.Join(dir, name+".pem")
+    keyFile = filepath.Join(dir, name+".key")
+    writePEM(t, certFile, "CERTIFICATE", der)
+    writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+    return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+    t.Helper()
+    if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// Run one handshake over loopback TCP and return the client's and the server's error
+func handshake(t *testing.T, server, client TLSConfig, address string) (clientErr, serverErr error) {
+    t.Helper()
+    serverCreds, err := serverCredentials(server)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    lis, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer lis.Close()
+    port := lis.Addr().(*net.TCPAddr).Port
+    address = strings.ReplaceAll(address, "PORT", strconv.Itoa(port))
+
+    clientCreds, err := clientCredentials(client, address)
+    if err != nil {
+        return err, nil
+    }
+
+    served := make(chan error, 1)
+    go func() {
+        conn, err := lis.Accept()
+        if err != nil {
+            served <- err
+            return
+        }
+        defer conn.Close()
+        conn.SetDeadline(time.Now().Add(5 * time.Second))
+        secure, _, err := serverCreds.ServerHandshake(conn)
+        if err == nil {
+            // TLS 1.3 checks the client certificate after the client is done, so read to see the verdict
+            _, err = secure.Read(make([]byte, 1))
+        }
+        served <- err
+    }()
+
+    conn, err := net.Dial("tcp", lis.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    secure, _, clientErr := clientCreds.ClientHandshake(ctx, address, conn)
+    if clientErr == nil {
+        secure.Write([]byte{0})
+        defer secure.Close()
+    } else {
+        conn.Close()
+    }
+    serverErr = <-served
+    return clientErr, serverErr
+}
+
+func TestTLSHandshake(t *testing.T) {
+    dir := t.TempDir()
+    ca := newTestCA(t, dir, "ca")
+    otherCA := newTestCA(t, dir, "other-ca")
+    valid := time.Now().Add(time.Hour)
+
+    serverCert, serverKey := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth, valid, "gateway.test", "127.0.0.1")
+    otherHostCert, otherHostKey := ca.issue(t, dir, "other-host", x509.ExtKeyUsageServerAuth, valid, "other.example")
+    foreignCert, foreignKey := otherCA.issue(t, dir, "foreign", x509.ExtKeyUsageServerAuth, valid, "gateway.test", "127.0.0.1")
+    expiredCert, expiredKey := ca.issue(t, dir, "expired", x509.ExtKeyUsageServerAuth, time.Now().Add(-time.Hour), "gateway.test", "127.0.0.1")
+    clientCert, clientKey := ca.issue(t, dir, "client", x509.ExtKeyUsageClientAuth, valid)
+
+    tests := []struct {
+        name       string
+        server     TLSConfig
+        client     TLSConfig
+        address    string
+        clientFail bool
+        serverFail bool
+    }{
+        {
+            name:    "valid by IP",
+            server:  TLSConfig{CertFile: serverCert, KeyFile: serverKey},
+            client:  TLSConfig{CAFile: ca.file},
+            address: "127.0.0.1:PORT",
+        },
+        {
+            name:    "valid by server name",
+            server:  TLSConfig{CertFile: serverCert, KeyFile: serverKey},
+            client:  TLSConfig{CAFile: ca.file, ServerName: "gateway.test"},
+            address: "127.0.0.1:PORT",
+        },
+        {
+            name:       "wrong host dialed by IP",
+            server:     TLSConfig{CertFile: otherHostCert, KeyFile: otherHostKey},
+            client:     TLSConfig{CAFile: ca.file},
+            address:    "127.0.0.1:PORT",
+            clientFail: true,
+        },
+        {
+            name:       "wrong host dialed by name",
+            server:     TLSConfig{CertFile: otherHostCert, KeyFile: otherHostKey},
+            client:     TLSConfig{CAFile: ca.file, ServerName: "gateway.test"},
+            address:    "127.0.0.1:PORT",
+            clientFail: true,
+        },
+        {
+            name:       "no host to check",
+            server:     TLSConfig{CertFile: serverCert, KeyFile: serverKey},
+            client:     TLSConfig{CAFile: ca.file},
+            address:    ":PORT",
+            clientFail: true,
+        },
+        {
+            name:       "wrong CA",
+            server:     TLSConfig{CertFile: foreignCert, KeyFile: foreignKey},
+            client:     TLSConfig{CAFile: ca.file},
+            address:    "127.0.0.1:PORT",
+            clientFail: true,
+        },
+        {
+            name:       "expired certificate",
+            server:     TLSConfig{CertFile: expiredCert, KeyFile: expiredKey},
+            client:     TLSConfig{CAFile: ca.file},
+            address:    "127.0.0.1:PORT",
+            clientFail: true,
+        },
+        {
+            name:    "client certificate",
+            server:  TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: ca.file},
+            client:  TLSConfig{CAFile: ca.file, CertFile: clientCert, KeyFile: clientKey},
+            address: "127.0.0.1:PORT",
+        },
+        {
+            name:       "client certificate missing",
+            server:     TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: ca.file},
+            client:     TLSConfig{CAFile: ca.file},
+            address:    "127.0.0.1:PORT",
+            serverFail: true,
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            clientErr, serverErr := handshake(t, tt.server, tt.client, tt.address)
+            if (clientErr != nil) != tt.clientFail {
+                t.Errorf("client error = %v, want failure %v", clientErr, tt.clientFail)
+            }
+            if !tt.clientFail && (serverErr != nil) != tt.serverFail {
+                t.Errorf("server error = %v, want failure %v", serverErr, tt.serverFail)
+            }
+        })
+    }
+}
+
+func TestExpectedServerName(t *testing.T) {
+    tests := []struct {
+        cfg     TLSConfig
+        address string
+        want    string
+    }{
+        {TLSConfig{}, "balogin.example.org:50051", "balogin.example.org"},
+        {TLSConfig{}, "10.0.0.5:50051", "10.0.0.5"},
+        {TLSConfig{}, "[::1]:50051", "::1"},
+        {TLSConfig{}, "dns:///balogin.example.org:50051", "balogin.example.org"},
+        {TLSConfig{}, "balogin.example.org", "balogin.example.org"},
+        {TLSConfig{ServerName: "balogin.internal"}, "10.0.0.5:50051", "balogin.internal"},
+        {TLSConfig{}, ":50051", ""},
+    }
+    for _, tt := range tests {
+        if got := expectedServerName(tt.cfg, tt.address); got != tt.want {
+            t.Errorf("expectedServerName(%+v, %q) = %q, want %q", tt.cfg, tt.address, got, tt.want)
+        }
+    }
+}
@@ -1,31 +1,360 @@
 package main
 
 import (
+    "context"
+    "flag"
     "fmt"
     "log"
+    "os"
+    "os/signal"
+    "syscall"
+    "time"
     "tinygo.org/x/bluetooth"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
     "ble-gateway/handler"
     "ble-gateway/ble"
+    "ble-gateway/db"
+    "ble-gateway/outbox"
+    "ble-gateway/presence"
+    "ble-gateway/store"
 )
 
 var adapter = bluetooth.DefaultAdapter
 
+// Interval between deletions of sessions older than -session-retention
+const sessionPruneInterval = time.Hour
+
+// Retry delays of the startup presence snapshot
+const (
+    snapshotInitialBackoff = 1 * time.Second
+    snapshotMaxBackoff     = 30 * time.Second
+)
+
 func main() {
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "replay":
+            if err := runReplay(os.Args[2:]); err != nil {
+                log.Fatalf("Replay failed: %v", err)
+            }
+            return
+        case "calibrate":
+            if err := runCalibrate(os.Args[2:]); err != nil {
+                log.Fatalf("Calibration failed: %v", err)
+            }
+            return
+        case "registry":
+            if err := runRegistry(os.Args[2:]); err != nil {
+                log.Fatalf("Registry command failed: %v", err)
+            }
+            return
+        case "sessions":
+            if err := runSessions(os.Args[2:]); err != nil {
+                log.Fatalf("Sessions command failed: %v", err)
+            }
+            return
+        case "config":
+            if err := runConfig(os.Args[2:]); err != nil {
+                log.Fatalf("Config command failed:\n%v", err)
+            }
+            return
+        }
+    }
+
+    cfg, err := readGatewayConfig(flag.CommandLine, os.Args[1:])
+    if err != nil {
+        log.Fatalf("Invalid configuration: %v", err)
+    }
+    simScript, tracePath := runtimeFlags(flag.CommandLine)
+    flag.Parse()
+    if err := cfg.Validate(); err != nil {
+        log.Fatalf("Invalid configuration:\n%v", err)
+    }
+
     fmt.Println("Starting program...")
 
-    must("enable BLE stack", adapter.Enable())
-    fmt.Println("BLE adapter initialized.")
+    // SIGINT and SIGTERM cancel ctx, which starts the shutdown
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    // One database handle shared by the scanner, the outbox and the gRPC server
+    database, err := db.Open(cfg.Database)
+    must("open database", err)
+
+    // Active UUIDs are looked up in memory from the scan callback
+    devices, err := store.NewWhitelist(db.NewStore(database))
+    must("load whitelist", err)
+    fmt.Printf("Whitelist holds %d active UUIDs.\n", devices.Stats().Size)
+
+    radio := newRadio(*simScript)
+
+    if *tracePath != "" {
+        must("start scan trace", ble.StartTrace(*tracePath))
+    }
+
+    var secret []byte
+    if cfg.AuthSecretFile != "" {
+        secret, err = handler.LoadSecret(cfg.AuthSecretFile)
+        must("load auth secret", err)
+    }
+
+    client, err := handler.NewClient(handler.ClientConfig{
+        Address:  cfg.Server.Address,
+        TLS:      cfg.Server.TLS,
+        Identity: cfg.Identity,
+        Secret:   secret,
+    })
+    must("create gRPC client", err)
 
-    client := handler.ServiceClient()
-    fmt.Println("gRPC client created.")
+    // Status reports go through the outbox so none are lost while the server is unreachable
+    reports := outbox.New(database, client.SendDeviceStatus)
+    reports.Start()
+
+    monitor := ble.NewStatusMonitor(cfg.Scan, presence.SystemClock, func(report handler.DeviceReport) {
+        if err := reports.Enqueue(report); err != nil {
+            log.Printf("Failed to queue device status: %v", err)
+        }
+    })
+    // Sessions left open by a crash are adopted, and their devices logged in again
+    // until they are seen or the grace period runs out
+    sessions := ble.NewSessionRecorder(devices, cfg.Identity.ID)
+    restored, err := sessions.Restore()
+    must("restore presence state", err)
+    monitor.Subscribe(sessions.Record)
+    monitor.Start()
+    if len(restored) > 0 {
+        monitor.Restore(restored, cfg.RestoreGrace)
+        fmt.Printf("Restored %d logged in devices, waiting %v for them to be seen again.\n", len(restored), cfg.RestoreGrace)
+        // Their UUIDs may have been deactivated while the gateway was down
+        if err := ble.RefreshWhitelist(devices, monitor); err != nil {
+            log.Printf("Failed to refresh whitelist: %v", err)
+        }
+    }
+    go sessions.Checkpoint(ctx, monitor, cfg.CheckpointInterval)
+
+    if cfg.SessionRetention > 0 {
+        go ble.PruneSessions(ctx, devices, presence.SystemClock, cfg.SessionRetention, sessionPruneInterval)
+    }
+
+    serverCfg := handler.ServerConfig{
+        Address:              cfg.Listen.Address,
+        Devices:              devices,
+        TLS:                  cfg.Listen.TLS,
+        Secret:               secret,
+        AllowUnauthenticated: cfg.Listen.AllowUnauthenticated,
+        OnRegistryChange: func() {
+            // Log out devices whose UUID was deactivated or revoked
+            if err := ble.RefreshWhitelist(devices, monitor); err != nil {
+                log.Printf("Failed to refresh whitelist: %v", err)
+            }
+        },
+    }
+
+    // The stream outlives ctx, so the final logout reports can still go over it
+    streamCtx, stopStream := context.WithCancel(context.Background())
+    defer stopStream()
+    if cfg.Server.Stream {
+        go client.RunStream(streamCtx, streamHandlers(devices, monitor, reports, serverCfg.AllocatesUUIDs()), cfg.Server.Heartbeat)
+    }
+
+    if cfg.Server.Snapshot {
+        // Restored devices not seen again are logged out by the first sweep after the grace period
+        go syncPresence(ctx, client, monitor, reports, cfg.RestoreGrace+cfg.Scan.SweepInterval)
+    }
+
+    if cfg.WhitelistCheck > 0 {
+        go checkWhitelist(ctx, devices, monitor, cfg.WhitelistCheck)
+    }
 
     fmt.Println("Starting BLE scan...")
-    go ble.RestartScan(client)
+    scanStopped := make(chan struct{})
+    go func() {
+        ble.RestartScan(ctx, devices, radio, monitor)
+        close(scanStopped)
+    }()
+
+    // SIGHUP and AdminService.ReloadConfig apply a changed configuration without a restart
+    reload := &reloader{args: os.Args[1:], current: *cfg, radio: radio, monitor: monitor, client: client}
+    go reload.watchSignals()
 
     fmt.Println("Waiting for server request...")
-    go handler.ServiceServer()
-    
-    select {}
+    serverCfg.Reload = func() ([]string, []string, error) {
+        return reload.reloadFrom("AdminService")
+    }
+    server := handler.ServiceServer(serverCfg)
+
+    <-ctx.Done()
+    stop() // A second signal kills the process
+    fmt.Println("Shutting down (press Ctrl-C again to force)...")
+
+    deadline, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+    defer cancel()
+    shutdown(deadline, scanStopped, monitor, reports, server)
+
+    stopStream()
+    if err := client.Close(); err != nil {
+        log.Printf("Failed to close gRPC client: %v", err)
+    }
+    if err := ble.StopTrace(); err != nil {
+        log.Printf("Failed to close scan trace: %v", err)
+    }
+    if err := database.Close(); err != nil {
+        log.Printf("Failed to close database: %v", err)
+    }
+    fmt.Println("Gateway stopped.")
+}
+
+// Stop scanning, log out every present device and deliver the logouts, and
+// stop the gRPC server, giving up on each step once deadline is done.
+// Logouts not delivered in time stay in the outbox for the next start.
+func shutdown(deadline context.Context, scanStopped <-chan struct{}, monitor *ble.Monitor, reports *outbox.Outbox, server *handler.Server) {
+    select {
+    case <-scanStopped:
+        fmt.Println("BLE scan stopped.")
+    case <-deadline.Done():
+        log.Printf("BLE scan did not stop in time")
+    }
+
+    loggedOut := monitor.LoseAll(presence.ReasonShutdown)
+    monitor.Stop() // Delivers the logouts to the session log and the outbox
+    fmt.Printf("Logged out %d devices.\n", loggedOut)
+
+    queued, err := reports.Drain(deadline)
+    if err != nil {
+        log.Printf("Failed to check outbox: %v", err)
+    } else if queued > 0 {
+        log.Printf("%d reports not delivered, they stay queued for the next start", queued)
+    } else {
+        fmt.Println("All reports delivered.")
+    }
+    reports.Close()
+
+    server.Shutdown(deadline)
+    fmt.Println("gRPC server stopped.")
+}
+
+// Commands the BALogin server can send on the presence stream. UUIDs are
+// only assigned when writable, as RequestUnusedUUID only hands them out then.
+func streamHandlers(devices *store.Whitelist, monitor *ble.Monitor, reports *outbox.Outbox, writable bool) handler.StreamHandlers {
+    return handler.StreamHandlers{
+        ForceLogout: func(uuid string) error {
+            // Sessions are tracked under the normalized UUID
+            normalized, err := store.NormalizeUUID(uuid)
+            if err != nil {
+                return err
+            }
+            if monitor.LoseUUID(normalized, presence.ReasonManual) == 0 {
+                return fmt.Errorf("%s is not logged in", uuid)
+            }
+            return nil
+        },
+        RefreshWhitelist: func() error {
+            // The server may have changed the registry behind the cache
+            if err := devices.Reload(); err != nil {
+                return err
+            }
+            return ble.RefreshWhitelist(devices, monitor)
+        },
+        AssignUUID: func(requestID string) (string, error) {
+            if !writable {
+                return "", fmt.Errorf("UUID allocation needs an auth secret or client certificates configured on the gateway, or -allow-unauthenticated")
+            }
+            return devices.AllocateUUID(requestID)
+        },
+        Stats: func() (int, int) {
+            queued, err := reports.Depth()
+            if err != nil {
+                log.Printf("Failed to count queued reports: %v", err)
+            }
+            return len(monitor.Present()), queued
+        },
+    }
+}
+
+// Once the presence state is reconciled after delay, deliver the queued
+// reports and send the server the full list of logged in users, retrying until it is accepted
+func syncPresence(ctx context.Context, client *handler.Client, monitor *ble.Monitor, reports *outbox.Outbox, delay time.Duration) {
+    select {
+    case <-ctx.Done():
+        return
+    case <-time.After(delay):
+    }
+
+    backoff := snapshotInitialBackoff
+    for {
+        // The snapshot must not be overtaken by reports still queued
+        monitor.Sync()
+        if _, err := reports.Drain(ctx); err != nil {
+            log.Printf("Failed to check outbox: %v", err)
+        }
+        err := client.SyncPresence(ctx, time.Now(), ble.SnapshotReports(monitor.Snapshot()))
+        if err == nil || ctx.Err() != nil {
+            return
+        }
+        if status.Code(err) == codes.Unimplemented {
+            log.Printf("BALogin server does not accept presence snapshots")
+            return
+        }
+
+        log.Printf("Failed to send presence snapshot: %v (retrying in %v)", err, backoff)
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(backoff):
+        }
+        if backoff *= 2; backoff > snapshotMaxBackoff {
+            backoff = snapshotMaxBackoff
+        }
+    }
+}
+
+// Periodically compare the whitelist cache with the database and reload it on drift, until ctx is done
+func checkWhitelist(ctx context.Context, devices *store.Whitelist, monitor *ble.Monitor, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+
+        missing, extra, stale, err := devices.Check()
+        if err != nil {
+            log.Printf("Failed to check whitelist: %v", err)
+            continue
+        }
+
+        stats := devices.Stats()
+        fmt.Printf("Whitelist: %d active UUIDs, %d hits, %d misses.\n", stats.Size, stats.Hits, stats.Misses)
+        if len(missing) == 0 && len(extra) == 0 && len(stale) == 0 {
+            continue
+        }
+
+        log.Printf("Whitelist out of date (missing %v, extra %v, stale %v), reloading", missing, extra, stale)
+        if err := devices.Reload(); err != nil {
+            log.Printf("Failed to reload whitelist: %v", err)
+            continue
+        }
+        if err := ble.RefreshWhitelist(devices, monitor); err != nil {
+            log.Printf("Failed to refresh whitelist: %v", err)
+        }
+    }
+}
+
+// Create the BLE radio: the system adapter, or a simulated one when a script is given
+func newRadio(simScript string) ble.Radio {
+    if simScript != "" {
+        events, err := ble.LoadSimScript(simScript)
+        must("load simulation script", err)
+        fmt.Printf("Simulating %d advertisements from %s.\n", len(events), simScript)
+        return ble.NewSimRadio(events)
+    }
+
+    must("enable BLE stack", adapter.Enable())
+    fmt.Println("BLE adapter initialized.")
+    return ble.NewBlueZRadio(adapter)
 }
 
 func must(action string, err error) {
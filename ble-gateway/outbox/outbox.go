@@ -0,0 +1,224 @@
+package outbox
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "time"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "ble-gateway/handler"
+    "ble-gateway/presence"
+)
+
+// Retry delays after a failed send
+const (
+    initialBackoff = 1 * time.Second
+    maxBackoff     = 2 * time.Minute
+)
+
+// Interval between checks of the queue while draining it
+const drainPollInterval = 100 * time.Millisecond
+
+// SendFunc delivers one status report to the server. It may block until
+// the server is reachable; ctx is cancelled when the outbox is closed.
+type SendFunc func(ctx context.Context, report handler.DeviceReport) error
+
+// Outbox persists device status reports before they are sent, so reports
+// made while the server is unreachable are delivered once it comes back.
+// Only the latest report per UUID is kept: a newer report supersedes any
+// report for the same UUID that has not been delivered yet. A report the
+// server rejects for good is marked dead and skipped.
+type Outbox struct {
+    db   *sql.DB
+    send SendFunc
+
+    initialBackoff time.Duration // Retry delays, shortened by tests
+    maxBackoff     time.Duration
+
+    wake chan struct{} // Signals the sender that a report was queued
+    stop chan struct{}
+    done chan struct{}
+}
+
+// New creates an outbox that keeps its reports in the gateway database
+func New(db *sql.DB, send SendFunc) *Outbox {
+    return &Outbox{
+        db:             db,
+        send:           send,
+        initialBackoff: initialBackoff,
+        maxBackoff:     maxBackoff,
+        wake:           make(chan struct{}, 1),
+        stop:           make(chan struct{}),
+        done:           make(chan struct{}),
+    }
+}
+
+// Enqueue stores a report, replacing any undelivered report for the same UUID
+func (o *Outbox) Enqueue(report handler.DeviceReport) error {
+    tx, err := o.db.Begin()
+    if err != nil {
+        return fmt.Errorf("failed to begin transaction: %v", err)
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec(`DELETE FROM outbox WHERE uuid = ? AND dead = 0`, report.UUID); err != nil {
+        return fmt.Errorf("failed to collapse superseded reports: %v", err)
+    }
+    query := `INSERT INTO outbox (uuid, status, created_at, reason, observed_at, rssi, distance) VALUES (?, ?, ?, ?, ?, ?, ?)`
+    _, err = tx.Exec(query, report.UUID, report.Status, time.Now(), string(report.Reason), report.ObservedAt, report.RSSI, report.Distance)
+    if err != nil {
+        return fmt.Errorf("failed to queue report: %v", err)
+    }
+    if err := tx.Commit(); err != nil {
+        return fmt.Errorf("failed to queue report: %v", err)
+    }
+
+    select {
+    case o.wake <- struct{}{}:
+    default: // The sender has already been woken
+    }
+    return nil
+}
+
+// Depth returns the number of undelivered reports, not counting dead ones
+func (o *Outbox) Depth() (int, error) {
+    var depth int
+    if err := o.db.QueryRow(`SELECT COUNT(*) FROM outbox WHERE dead = 0`).Scan(&depth); err != nil {
+        return 0, fmt.Errorf("failed to count queued reports: %v", err)
+    }
+    return depth, nil
+}
+
+// Dead returns the number of reports the server rejected for good
+func (o *Outbox) Dead() (int, error) {
+    var dead int
+    if err := o.db.QueryRow(`SELECT COUNT(*) FROM outbox WHERE dead = 1`).Scan(&dead); err != nil {
+        return 0, fmt.Errorf("failed to count dead reports: %v", err)
+    }
+    return dead, nil
+}
+
+// Drain waits until every queued report has been delivered or ctx is done,
+// and returns the number of reports still queued
+func (o *Outbox) Drain(ctx context.Context) (int, error) {
+    ticker := time.NewTicker(drainPollInterval)
+    defer ticker.Stop()
+    for {
+        depth, err := o.Depth()
+        if err != nil || depth == 0 {
+            return depth, err
+        }
+        select {
+        case <-ctx.Done():
+            return depth, nil
+        case <-ticker.C:
+        }
+    }
+}
+
+// Start the background sender
+func (o *Outbox) Start() {
+    go o.run()
+}
+
+// Close stops the sender; queued reports stay in the database
+func (o *Outbox) Close() {
+    close(o.stop)
+    <-o.done
+}
+
+// Deliver queued reports oldest first, backing off exponentially while sends fail
+func (o *Outbox) run() {
+    defer close(o.done)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    go func() {
+        <-o.stop
+        cancel()
+    }()
+
+    backoff := time.Duration(0) // Current retry delay, 0 after a successful send
+    for {
+        delivered, err := o.sendNext(ctx)
+        if err != nil {
+            if backoff == 0 {
+                backoff = o.initialBackoff
+            } else if backoff *= 2; backoff > o.maxBackoff {
+                backoff = o.maxBackoff
+            }
+
+            depth, _ := o.Depth()
+            log.Printf("Outbox: %v (%d reports pending, retrying in %v)", err, depth, backoff)
+            select {
+            case <-o.stop:
+                return
+            case <-time.After(backoff):
+            }
+            continue
+        }
+        backoff = 0
+
+        if delivered {
+            continue
+        }
+
+        // Queue is empty: wait for the next report
+        select {
+        case <-o.stop:
+            return
+        case <-o.wake:
+        }
+    }
+}
+
+// Send the oldest live report; delivered is false if the queue was empty.
+// A report the server rejects for good is marked dead and counts as delivered.
+func (o *Outbox) sendNext(ctx context.Context) (delivered bool, err error) {
+    var id int64
+    var report handler.DeviceReport
+    var reason string
+    var observedAt sql.NullTime // NULL for reports queued by an older gateway
+    query := `SELECT id, uuid, status, reason, observed_at, rssi, distance FROM outbox WHERE dead = 0 ORDER BY id LIMIT 1`
+    err = o.db.QueryRow(query).Scan(&id, &report.UUID, &report.Status, &reason, &observedAt, &report.RSSI, &report.Distance)
+    if err == sql.ErrNoRows {
+        return false, nil
+    }
+    if err != nil {
+        return false, fmt.Errorf("failed to read queued report: %v", err)
+    }
+
+    report.Reason = presence.Reason(reason)
+    report.ObservedAt = observedAt.Time
+
+    if sendErr := o.send(ctx, report); sendErr != nil {
+        dead := rejected(sendErr)
+        query := `UPDATE outbox SET attempts = attempts + 1, last_error = ?, dead = ? WHERE id = ?`
+        if _, err := o.db.Exec(query, sendErr.Error(), dead, id); err != nil {
+            log.Printf("Outbox: failed to record send attempt: %v", err)
+            dead = false // Still queued, so back off before the next attempt
+        }
+        if dead {
+            log.Printf("Outbox: server rejected report for %s, moved it aside: %v", report.UUID, sendErr)
+            return true, nil
+        }
+        return false, fmt.Errorf("failed to send report for %s: %v", report.UUID, sendErr)
+    }
+
+    // A newer report for the same UUID may have replaced this row meanwhile; it is sent next
+    if _, err := o.db.Exec(`DELETE FROM outbox WHERE id = ?`, id); err != nil {
+        return false, fmt.Errorf("failed to remove delivered report: %v", err)
+    }
+    return true, nil
+}
+
+// Whether the server refused a report in a way a retry cannot fix
+func rejected(err error) bool {
+    switch status.Code(err) {
+    case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied, codes.FailedPrecondition, codes.OutOfRange:
+        return true
+    }
+    return false
+}
@@ -0,0 +1,244 @@
+package outbox
+
+import (
+    "context"
+    "database/sql"
+    "path/filepath"
+    "sync"
+    "testing"
+    "time"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "ble-gateway/db"
+    "ble-gateway/handler"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+    t.Helper()
+    database, err := db.Open(filepath.Join(t.TempDir(), "ble.db"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { database.Close() })
+    return database
+}
+
+// server records delivered reports and fails sends with the queued errors first
+type server struct {
+    mu        sync.Mutex
+    errs      []error
+    attempts  []time.Time
+    delivered chan handler.DeviceReport
+}
+
+func newServer(errs ...error) *server {
+    return &server{errs: errs, delivered: make(chan handler.DeviceReport, 100)}
+}
+
+func (s *server) send(ctx context.Context, report handler.DeviceReport) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.attempts = append(s.attempts, time.Now())
+    if len(s.errs) > 0 {
+        err := s.errs[0]
+        s.errs = s.errs[1:]
+        return err
+    }
+    s.delivered <- report
+    return nil
+}
+
+// Wait for the next n delivered reports
+func (s *server) next(t *testing.T, n int) []handler.DeviceReport {
+    t.Helper()
+    var reports []handler.DeviceReport
+    for len(reports) < n {
+        select {
+        case report := <-s.delivered:
+            reports = append(reports, report)
+        case <-time.After(5 * time.Second):
+            t.Fatalf("%d of %d reports delivered", len(reports), n)
+        }
+    }
+    return reports
+}
+
+func enqueue(t *testing.T, o *Outbox, uuid string, status int32) {
+    t.Helper()
+    if err := o.Enqueue(handler.DeviceReport{UUID: uuid, Status: status}); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func depth(t *testing.T, o *Outbox) int {
+    t.Helper()
+    depth, err := o.Depth()
+    if err != nil {
+        t.Fatal(err)
+    }
+    return depth
+}
+
+func TestEnqueueReplacesOlderReport(t *testing.T) {
+    srv := newServer()
+    o := New(openTestDB(t), srv.send)
+
+    enqueue(t, o, "a", 1)
+    enqueue(t, o, "b", 1)
+    enqueue(t, o, "a", 0)
+    if n := depth(t, o); n != 2 {
+        t.Fatalf("depth %d, want 2", n)
+    }
+
+    o.Start()
+    defer o.Close()
+    reports := srv.next(t, 2)
+    if reports[0].UUID != "b" || reports[1].UUID != "a" || reports[1].Status != 0 {
+        t.Errorf("delivered %+v, want b's login then a's logout", reports)
+    }
+    if n := depth(t, o); n != 0 {
+        t.Errorf("depth %d after delivery", n)
+    }
+}
+
+func TestDeliversInOrderAcrossUUIDs(t *testing.T) {
+    srv := newServer()
+    o := New(openTestDB(t), srv.send)
+    uuids := []string{"c", "a", "d", "b"}
+    for _, uuid := range uuids {
+        enqueue(t, o, uuid, 1)
+    }
+
+    o.Start()
+    defer o.Close()
+    for i, report := range srv.next(t, len(uuids)) {
+        if report.UUID != uuids[i] {
+            t.Errorf("report %d for %s, want %s", i, report.UUID, uuids[i])
+        }
+    }
+}
+
+func TestRetriesWithBackoff(t *testing.T) {
+    unavailable := status.Error(codes.Unavailable, "connection refused")
+    srv := newServer(unavailable, unavailable, unavailable)
+    o := New(openTestDB(t), srv.send)
+    o.initialBackoff, o.maxBackoff = 20*time.Millisecond, 40*time.Millisecond
+    enqueue(t, o, "a", 1)
+
+    o.Start()
+    defer o.Close()
+    srv.next(t, 1)
+
+    srv.mu.Lock()
+    defer srv.mu.Unlock()
+    want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 40 * time.Millisecond}
+    if len(srv.attempts) != len(want)+1 {
+        t.Fatalf("%d attempts, want %d", len(srv.attempts), len(want)+1)
+    }
+    for i, min := range want {
+        if gap := srv.attempts[i+1].Sub(srv.attempts[i]); gap < min {
+            t.Errorf("retry %d after %v, want at least %v", i+1, gap, min)
+        }
+    }
+}
+
+func TestRejectedReportIsMovedAside(t *testing.T) {
+    srv := newServer(status.Error(codes.InvalidArgument, "unknown UUID"))
+    o := New(openTestDB(t), srv.send)
+    enqueue(t, o, "bad", 1)
+    enqueue(t, o, "good", 1)
+
+    o.Start()
+    defer o.Close()
+    if reports := srv.next(t, 1); reports[0].UUID != "good" {
+        t.Fatalf("delivered %+v, want the report queued behind the rejected one", reports)
+    }
+    if dead, err := o.Dead(); err != nil || dead != 1 {
+        t.Errorf("Dead() = %d, %v; want 1", dead, err)
+    }
+    if n := depth(t, o); n != 0 {
+        t.Errorf("depth %d, want 0: dead reports are not queued", n)
+    }
+
+    // A newer report for the same UUID is sent and keeps the dead one for inspection
+    enqueue(t, o, "bad", 0)
+    if reports := srv.next(t, 1); reports[0].UUID != "bad" || reports[0].Status != 0 {
+        t.Errorf("delivered %+v, want the newer report", reports)
+    }
+    if dead, _ := o.Dead(); dead != 1 {
+        t.Errorf("%d dead reports after a newer report, want 1", dead)
+    }
+}
+
+func TestRestartSendsQueuedReports(t *testing.T) {
+    database := openTestDB(t)
+
+    // The server is down for the whole first run
+    failed := make(chan struct{}, 1)
+    down := New(database, func(ctx context.Context, report handler.DeviceReport) error {
+        select {
+        case failed <- struct{}{}:
+        default:
+        }
+        return status.Error(codes.Unavailable, "connection refused")
+    })
+    enqueue(t, down, "a", 1)
+    enqueue(t, down, "b", 1)
+    down.Start()
+    <-failed
+    down.Close()
+
+    var attempts int
+    if err := database.QueryRow(`SELECT attempts FROM outbox WHERE uuid = 'a'`).Scan(&attempts); err != nil || attempts == 0 {
+        t.Errorf("failed attempts recorded: %d, %v", attempts, err)
+    }
+
+    srv := newServer()
+    restarted := New(database, srv.send)
+    restarted.Start()
+    defer restarted.Close()
+    reports := srv.next(t, 2)
+    if reports[0].UUID != "a" || reports[1].UUID != "b" {
+        t.Errorf("delivered %+v after restart, want a then b", reports)
+    }
+}
+
+func TestDrainStopsAtDeadline(t *testing.T) {
+    // Sends block until the outbox is closed
+    o := New(openTestDB(t), func(ctx context.Context, report handler.DeviceReport) error {
+        <-ctx.Done()
+        return ctx.Err()
+    })
+    o.Start()
+    defer o.Close()
+    enqueue(t, o, "a", 1)
+    enqueue(t, o, "b", 1)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    queued, err := o.Drain(ctx)
+    if err != nil || queued != 2 {
+        t.Errorf("Drain = %d, %v; want 2 still queued", queued, err)
+    }
+    if waited := time.Since(start); waited > 2*time.Second {
+        t.Errorf("Drain returned %v after its deadline", waited)
+    }
+}
+
+func TestDrainReturnsOnceDelivered(t *testing.T) {
+    srv := newServer()
+    o := New(openTestDB(t), srv.send)
+    o.Start()
+    defer o.Close()
+    enqueue(t, o, "a", 1)
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if queued, err := o.Drain(ctx); err != nil || queued != 0 {
+        t.Errorf("Drain = %d, %v; want 0", queued, err)
+    }
+    if ctx.Err() != nil {
+        t.Error("Drain waited for its deadline")
+    }
+}
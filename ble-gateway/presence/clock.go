@@ -0,0 +1,52 @@
+package presence
+
+import (
+    "sync"
+    "time"
+)
+
+// Clock supplies the current time, so tests and replays can run on a virtual clock
+type Clock interface {
+    Now() time.Time
+}
+
+// ClockFunc adapts a function to the Clock interface
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+    return f()
+}
+
+// SystemClock is the wall clock
+var SystemClock Clock = ClockFunc(time.Now)
+
+// ManualClock is a virtual clock that only moves when told to. It is safe for concurrent use.
+type ManualClock struct {
+    mu sync.Mutex
+    t  time.Time
+}
+
+// NewManualClock creates a virtual clock set to t
+func NewManualClock(t time.Time) *ManualClock {
+    return &ManualClock{t: t}
+}
+
+func (c *ManualClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.t
+}
+
+// Set moves the clock to t
+func (c *ManualClock) Set(t time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.t = t
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.t = c.t.Add(d)
+}
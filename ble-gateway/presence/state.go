@@ -0,0 +1,187 @@
+package presence
+
+import "time"
+
+// State of a device as seen by one gateway
+type State int
+
+const (
+    Unknown     State = iota // Never evaluated
+    Approaching              // Close enough, waiting for the dwell count
+    Present                  // Logged in
+    Leaving                  // Signal weak, waiting for the dwell count
+    Absent                   // Logged out, or never got close enough
+)
+
+func (s State) String() string {
+    switch s {
+    case Approaching:
+        return "approaching"
+    case Present:
+        return "present"
+    case Leaving:
+        return "leaving"
+    case Absent:
+        return "absent"
+    default:
+        return "unknown"
+    }
+}
+
+// Zone classifies one (filtered) signal sample against the enter/exit thresholds
+type Zone int
+
+const (
+    Between Zone = iota // Between the thresholds: keep the current state
+    Near                // On the login side of the enter threshold
+    Far                 // On the logout side of the exit threshold
+)
+
+// Reason explains why a transition happened
+type Reason string
+
+const (
+    ReasonSignal        Reason = "signal"         // Driven by observed signal strength
+    ReasonTimeout       Reason = "timeout"        // Not seen within the timeout
+    ReasonInactive      Reason = "inactive"       // UUID is not active in the registry
+    ReasonConnectFailed Reason = "connect_failed" // Could not connect to the device
+    ReasonManual        Reason = "manual"         // Logged out by an operator
+    ReasonShutdown      Reason = "shutdown"       // The gateway is stopping
+)
+
+// Observation is one sighting of a device with an active UUID
+type Observation struct {
+    Address  string // MAC address
+    UUID     string
+    RSSI     int     // Filtered RSSI in dBm
+    Distance float64 // Estimated distance in meters
+    Zone     Zone
+}
+
+// Event is emitted for every state transition
+type Event struct {
+    Address  string
+    UUID     string
+    From     State
+    To       State
+    Reason   Reason
+    RSSI     int     // Last filtered RSSI
+    PeakRSSI int     // Strongest filtered RSSI since the device began approaching
+    Distance float64 // Last estimated distance in meters
+    Time     time.Time
+}
+
+// IsLogin reports whether the event logs the user in
+func (e Event) IsLogin() bool {
+    return e.To == Present && e.From == Approaching
+}
+
+// IsLogout reports whether the event logs the user out
+func (e Event) IsLogout() bool {
+    return e.To == Absent && (e.From == Present || e.From == Leaving)
+}
+
+// machine is the state of one device
+type machine struct {
+    address  string
+    uuid     string
+    state    State
+    lastSeen time.Time
+    rssi     int
+    peak     int // Strongest RSSI since entering Approaching
+    distance float64
+    near     int // Consecutive Near samples while approaching
+    far      int // Consecutive Far samples while leaving
+
+    graceUntil time.Time // Set while a restored device waits to be seen again
+}
+
+// observe applies one sighting and returns the resulting transitions
+func (m *machine) observe(obs Observation, dwell int, t time.Time) []Event {
+    m.lastSeen = t
+    m.graceUntil = time.Time{}
+    m.rssi = obs.RSSI
+    m.distance = obs.Distance
+    if m.state != Present && m.state != Leaving {
+        m.uuid = obs.UUID // The UUID is fixed once logged in
+    }
+    if m.state != Unknown && m.state != Absent && m.rssi > m.peak {
+        m.peak = m.rssi
+    }
+
+    var events []Event
+    switch m.state {
+    case Unknown, Absent:
+        switch obs.Zone {
+        case Near:
+            events = append(events, m.transition(Approaching, ReasonSignal, t))
+        case Far:
+            if m.state == Unknown {
+                events = append(events, m.transition(Absent, ReasonSignal, t))
+            }
+        }
+    case Approaching:
+        switch obs.Zone {
+        case Near:
+            m.near++
+        case Between:
+            m.near = 0
+        case Far:
+            events = append(events, m.transition(Absent, ReasonSignal, t))
+        }
+    case Present:
+        if obs.Zone == Far {
+            events = append(events, m.transition(Leaving, ReasonSignal, t))
+        }
+    case Leaving:
+        if obs.Zone == Far {
+            m.far++
+        } else {
+            events = append(events, m.transition(Present, ReasonSignal, t))
+        }
+    }
+
+    if m.state == Approaching && m.near >= dwell {
+        events = append(events, m.transition(Present, ReasonSignal, t))
+    }
+    if m.state == Leaving && m.far >= dwell {
+        events = append(events, m.transition(Absent, ReasonSignal, t))
+    }
+    return events
+}
+
+// lose moves the device straight to Absent
+func (m *machine) lose(reason Reason, t time.Time) []Event {
+    if m.state == Absent || m.state == Unknown {
+        return nil
+    }
+    return []Event{m.transition(Absent, reason, t)}
+}
+
+func (m *machine) transition(to State, reason Reason, t time.Time) Event {
+    if to == Approaching {
+        m.peak = m.rssi // A new session begins
+    }
+    event := Event{
+        Address:  m.address,
+        UUID:     m.uuid,
+        From:     m.state,
+        To:       to,
+        Reason:   reason,
+        RSSI:     m.rssi,
+        PeakRSSI: m.peak,
+        Distance: m.distance,
+        Time:     t,
+    }
+    m.state = to
+
+    // Entering Approaching or Leaving counts the sample that caused it
+    m.near, m.far = 0, 0
+    if to == Approaching {
+        m.near = 1
+    }
+    if to == Leaving {
+        m.far = 1
+    }
+    return event
+}
@@ -0,0 +1,83 @@
+package presence
+
+import (
+    "fmt"
+    "sync"
+)
+
+// LogEvent prints a transition
+func LogEvent(e Event) {
+    fmt.Printf("Device %s (%s): %s -> %s (%s, RSSI %d)\n", e.UUID, e.Address, e.From, e.To, e.Reason, e.RSSI)
+}
+
+// Metrics counts transitions; Record can be used as a subscriber
+type Metrics struct {
+    mu          sync.Mutex
+    logins      int
+    logouts     int
+    present     int
+    transitions map[string]int // "from->to" -> count
+    reasons     map[Reason]int // Logout reason -> count
+}
+
+// MetricsSnapshot is a point-in-time copy of the counters
+type MetricsSnapshot struct {
+    Logins      int
+    Logouts     int
+    Present     int
+    Transitions map[string]int
+    Reasons     map[Reason]int
+}
+
+// NewMetrics creates zeroed counters
+func NewMetrics() *Metrics {
+    return &Metrics{
+        transitions: make(map[string]int),
+        reasons:     make(map[Reason]int),
+    }
+}
+
+// Record counts one transition
+func (m *Metrics) Record(e Event) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.transitions[e.From.String()+"->"+e.To.String()]++
+    if e.IsLogin() {
+        m.logins++
+        m.present++
+    }
+    if e.IsLogout() {
+        m.logouts++
+        m.present--
+        m.reasons[e.Reason]++
+    }
+}
+
+// Restored counts devices put back in the Present state without a login
+func (m *Metrics) Restored(n int) {
+    m.mu.Lock()
+    m.present += n
+    m.mu.Unlock()
+}
+
+// Snapshot copies the current counters
+func (m *Metrics) Snapshot() MetricsSnapshot {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    snapshot := MetricsSnapshot{
+        Logins:      m.logins,
+        Logouts:     m.logouts,
+        Present:     m.present,
+        Transitions: make(map[string]int, len(m.transitions)),
+        Reasons:     make(map[Reason]int, len(m.reasons)),
+    }
+    for k, v := range m.transitions {
+        snapshot.Transitions[k] = v
+    }
+    for k, v := range m.reasons {
+        snapshot.Reasons[k] = v
+    }
+    return snapshot
+}
@@ -0,0 +1,155 @@
+package presence
+
+import "time"
+
+// Config holds the presence timing settings
+type Config struct {
+    DwellCount int           // Consecutive Near/Far samples needed to log in/out
+    Timeout    time.Duration // Log out a device not seen for this long
+}
+
+// Tracker runs one state machine per device address and emits every
+// transition to its subscribers. It is not safe for concurrent use.
+type Tracker struct {
+    cfg         Config
+    clock       Clock
+    devices     map[string]*machine
+    subscribers []func(Event)
+}
+
+// NewTracker creates an empty tracker
+func NewTracker(cfg Config, clock Clock) *Tracker {
+    return &Tracker{
+        cfg:     cfg,
+        clock:   clock,
+        devices: make(map[string]*machine),
+    }
+}
+
+// SetConfig changes the timing settings; devices keep their state, and
+// dwell counts in progress are checked against the new count
+func (t *Tracker) SetConfig(cfg Config) {
+    t.cfg = cfg
+}
+
+// Subscribe registers a function called synchronously for every transition
+func (t *Tracker) Subscribe(fn func(Event)) {
+    t.subscribers = append(t.subscribers, fn)
+}
+
+// Observe applies a sighting of a device with an active UUID
+func (t *Tracker) Observe(obs Observation) {
+    m, exists := t.devices[obs.Address]
+    if !exists {
+        m = &machine{address: obs.Address}
+        t.devices[obs.Address] = m
+    }
+    t.emit(m.observe(obs, t.cfg.DwellCount, t.clock.Now()))
+}
+
+// Lose marks a device absent immediately, e.g. when its UUID is no longer active
+func (t *Tracker) Lose(address string, reason Reason) {
+    if m, exists := t.devices[address]; exists {
+        t.emit(m.lose(reason, t.clock.Now()))
+    }
+}
+
+// Restored is a device that was logged in when the gateway last stopped
+type Restored struct {
+    Address  string
+    UUID     string
+    LastSeen time.Time
+    RSSI     int // Strongest RSSI of the session
+}
+
+// Restore puts a device back in the Present state without a login event.
+// Unless it is seen again before grace has passed, it is logged out at the
+// next Tick after that, as of the time it was last seen.
+func (t *Tracker) Restore(r Restored, grace time.Duration) {
+    t.devices[r.Address] = &machine{
+        address:    r.Address,
+        uuid:       r.UUID,
+        state:      Present,
+        lastSeen:   r.LastSeen,
+        rssi:       r.RSSI,
+        peak:       r.RSSI,
+        graceUntil: t.clock.Now().Add(grace),
+    }
+}
+
+// Tick logs out devices not seen within the timeout and forgets absent ones;
+// it returns how many devices were logged out or forgotten
+func (t *Tracker) Tick() int {
+    currentTime := t.clock.Now()
+    changed := 0
+    for address, m := range t.devices {
+        if !m.graceUntil.IsZero() {
+            if currentTime.Before(m.graceUntil) {
+                continue
+            }
+            m.graceUntil = time.Time{}
+            if m.state == Present || m.state == Leaving {
+                t.emit(m.lose(ReasonTimeout, m.lastSeen))
+                changed++
+                continue
+            }
+        }
+        if currentTime.Sub(m.lastSeen) <= t.cfg.Timeout {
+            continue
+        }
+        changed++
+        if m.state == Absent || m.state == Unknown {
+            delete(t.devices, address)
+            continue
+        }
+        t.emit(m.lose(ReasonTimeout, currentTime))
+    }
+    return changed
+}
+
+// State returns the current state of a device
+func (t *Tracker) State(address string) State {
+    if m, exists := t.devices[address]; exists {
+        return m.state
+    }
+    return Unknown
+}
+
+// Snapshot is the state of one logged in device
+type Snapshot struct {
+    Address  string
+    UUID     string
+    LastSeen time.Time
+    RSSI     int     // Last filtered RSSI
+    Distance float64 // Last estimated distance in meters
+}
+
+// Snapshot returns the logged in devices
+func (t *Tracker) Snapshot() []Snapshot {
+    var snapshot []Snapshot
+    for address, m := range t.devices {
+        if m.state == Present || m.state == Leaving {
+            snapshot = append(snapshot, Snapshot{Address: address, UUID: m.uuid, LastSeen: m.lastSeen, RSSI: m.rssi, Distance: m.distance})
+        }
+    }
+    return snapshot
+}
+
+// Present returns the logged in devices as MAC address -> UUID
+func (t *Tracker) Present() map[string]string {
+    present := make(map[string]string)
+    for address, m := range t.devices {
+        if m.state == Present || m.state == Leaving {
+            present[address] = m.uuid
+        }
+    }
+    return present
+}
+
+func (t *Tracker) emit(events []Event) {
+    for _, event := range events {
+        for _, fn := range t.subscribers {
+            fn(event)
+        }
+    }
+}
@@ -0,0 +1,195 @@
+package presence
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+const testAddress = "AA:BB:CC:DD:EE:01"
+
+// One step of a transition test: move the clock, apply an action, check the result
+type step struct {
+    advance time.Duration
+    action  string // "near", "between", "far", "tick" or "lose"
+    want    State
+    events  string // Transitions emitted by the step, as "from->to reason" joined by ", "
+}
+
+func describe(events []Event) string {
+    var parts []string
+    for _, e := range events {
+        parts = append(parts, fmt.Sprintf("%s->%s %s", e.From, e.To, e.Reason))
+    }
+    return strings.Join(parts, ", ")
+}
+
+// Run steps against a fresh tracker; setup may restore devices first
+func runSteps(t *testing.T, cfg Config, setup func(*Tracker), steps []step) {
+    t.Helper()
+    clock := NewManualClock(time.Unix(1700000000, 0))
+    tracker := NewTracker(cfg, clock)
+    var events []Event
+    tracker.Subscribe(func(e Event) {
+        events = append(events, e)
+    })
+    if setup != nil {
+        setup(tracker)
+    }
+
+    zones := map[string]Zone{"near": Near, "between": Between, "far": Far}
+    for i, s := range steps {
+        clock.Advance(s.advance)
+        events = nil
+        switch s.action {
+        case "tick":
+            tracker.Tick()
+        case "lose":
+            tracker.Lose(testAddress, ReasonManual)
+        default:
+            zone, ok := zones[s.action]
+            if !ok {
+                t.Fatalf("step %d: unknown action %q", i, s.action)
+            }
+            tracker.Observe(Observation{Address: testAddress, UUID: "uuid-1", RSSI: -70, Zone: zone})
+        }
+        if got := tracker.State(testAddress); got != s.want {
+            t.Errorf("step %d (%s after %v): state %v, want %v", i, s.action, s.advance, got, s.want)
+        }
+        if got := describe(events); got != s.events {
+            t.Errorf("step %d (%s after %v): events %q, want %q", i, s.action, s.advance, got, s.events)
+        }
+    }
+}
+
+func TestTrackerTransitions(t *testing.T) {
+    cfg := Config{DwellCount: 2, Timeout: 30 * time.Second}
+    tests := []struct {
+        name  string
+        cfg   Config
+        steps []step
+    }{
+        {"login after the dwell count", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Present, "approaching->present signal"},
+            {time.Second, "near", Present, ""},
+        }},
+        {"dwell count of one logs in at once", Config{DwellCount: 1, Timeout: 30 * time.Second}, []step{
+            {time.Second, "near", Present, "unknown->approaching signal, approaching->present signal"},
+        }},
+        {"between restarts the dwell count", Config{DwellCount: 3, Timeout: 30 * time.Second}, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Approaching, ""},
+            {time.Second, "between", Approaching, ""},
+            {time.Second, "near", Approaching, ""},
+            {time.Second, "near", Approaching, ""},
+            {time.Second, "near", Present, "approaching->present signal"},
+        }},
+        {"far while approaching gives up", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "far", Absent, "approaching->absent signal"},
+        }},
+        {"far device is absent without a session", cfg, []step{
+            {time.Second, "far", Absent, "unknown->absent signal"},
+            {time.Second, "far", Absent, ""},
+            {time.Second, "between", Absent, ""},
+        }},
+        {"between does not start approaching", cfg, []step{
+            {time.Second, "between", Unknown, ""},
+        }},
+        {"logout after the dwell count", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Present, "approaching->present signal"},
+            {time.Second, "far", Leaving, "present->leaving signal"},
+            {time.Second, "far", Absent, "leaving->absent signal"},
+            {time.Second, "near", Approaching, "absent->approaching signal"},
+        }},
+        {"signal back while leaving", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Present, "approaching->present signal"},
+            {time.Second, "far", Leaving, "present->leaving signal"},
+            {time.Second, "between", Present, "leaving->present signal"},
+            {time.Second, "between", Present, ""},
+        }},
+        {"leave timeout", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Present, "approaching->present signal"},
+            {30 * time.Second, "tick", Present, ""},
+            {time.Second, "tick", Absent, "present->absent timeout"},
+            {time.Second, "tick", Unknown, ""}, // Forgotten
+        }},
+        {"timeout while leaving", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Present, "approaching->present signal"},
+            {time.Second, "far", Leaving, "present->leaving signal"},
+            {31 * time.Second, "tick", Absent, "leaving->absent timeout"},
+        }},
+        {"timeout while approaching is forgotten without a logout", cfg, []step{
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {31 * time.Second, "tick", Absent, "approaching->absent timeout"},
+            {0, "tick", Unknown, ""},
+        }},
+        {"manual logout", cfg, []step{
+            {0, "lose", Unknown, ""},
+            {time.Second, "near", Approaching, "unknown->approaching signal"},
+            {time.Second, "near", Present, "approaching->present signal"},
+            {time.Second, "lose", Absent, "present->absent manual"},
+            {time.Second, "lose", Absent, ""},
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            runSteps(t, tt.cfg, nil, tt.steps)
+        })
+    }
+}
+
+func TestTrackerRestore(t *testing.T) {
+    cfg := Config{DwellCount: 2, Timeout: 30 * time.Second}
+    start := time.Unix(1700000000, 0)
+    restore := func(tracker *Tracker) {
+        tracker.Restore(Restored{Address: testAddress, UUID: "uuid-1", LastSeen: start.Add(-time.Hour), RSSI: -70}, 10*time.Second)
+    }
+
+    t.Run("seen within the grace period", func(t *testing.T) {
+        runSteps(t, cfg, restore, []step{
+            {5 * time.Second, "tick", Present, ""},
+            {time.Second, "between", Present, ""},
+            {10 * time.Second, "tick", Present, ""},
+            {25 * time.Second, "tick", Absent, "present->absent timeout"},
+        })
+    })
+    t.Run("not seen within the grace period", func(t *testing.T) {
+        runSteps(t, cfg, restore, []step{
+            {9 * time.Second, "tick", Present, ""},
+            {time.Second, "tick", Absent, "present->absent timeout"},
+        })
+    })
+    t.Run("far within the grace period", func(t *testing.T) {
+        runSteps(t, cfg, restore, []step{
+            {time.Second, "far", Leaving, "present->leaving signal"},
+            {time.Second, "far", Absent, "leaving->absent signal"},
+        })
+    })
+}
+
+func TestTrackerRestoredLogoutTime(t *testing.T) {
+    clock := NewManualClock(time.Unix(1700000000, 0))
+    tracker := NewTracker(Config{DwellCount: 2, Timeout: 30 * time.Second}, clock)
+    var events []Event
+    tracker.Subscribe(func(e Event) {
+        events = append(events, e)
+    })
+    lastSeen := clock.Now().Add(-time.Hour)
+    tracker.Restore(Restored{Address: testAddress, UUID: "uuid-1", LastSeen: lastSeen, RSSI: -70}, time.Minute)
+
+    clock.Advance(time.Minute)
+    tracker.Tick()
+    if len(events) != 1 || !events[0].IsLogout() {
+        t.Fatalf("events %+v, want one logout", events)
+    }
+    if !events[0].Time.Equal(lastSeen) {
+        t.Errorf("logged out at %v, want the last sighting %v", events[0].Time, lastSeen)
+    }
+}
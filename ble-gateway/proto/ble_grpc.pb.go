@@ -26,6 +26,12 @@ type DeviceServiceClient interface {
 	RequestUnusedUUID(ctx context.Context, in *UUIDRequest, opts ...grpc.CallOption) (*Response, error)
 	// BLE device status transmission
 	SendDeviceStatus(ctx context.Context, in *DeviceStatus, opts ...grpc.CallOption) (*Response, error)
+	// Long-lived channel opened by the gateway: it pushes status reports and
+	// heartbeats, and the server acknowledges reports and sends commands
+	PresenceStream(ctx context.Context, opts ...grpc.CallOption) (DeviceService_PresenceStreamClient, error)
+	// Full presence state of a gateway: the server should consider exactly these
+	// users logged in at this gateway, as of taken_at
+	SyncPresence(ctx context.Context, in *PresenceSnapshot, opts ...grpc.CallOption) (*Response, error)
 }
 
 type deviceServiceClient struct {
@@ -54,6 +60,46 @@ func (c *deviceServiceClient) SendDeviceStatus(ctx context.Context, in *DeviceSt
 	return out, nil
 }
 
+func (c *deviceServiceClient) PresenceStream(ctx context.Context, opts ...grpc.CallOption) (DeviceService_PresenceStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DeviceService_ServiceDesc.Streams[0], "/device.DeviceService/PresenceStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &deviceServicePresenceStreamClient{stream}
+	return x, nil
+}
+
+type DeviceService_PresenceStreamClient interface {
+	Send(*GatewayMessage) error
+	Recv() (*ServerMessage, error)
+	grpc.ClientStream
+}
+
+type deviceServicePresenceStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *deviceServicePresenceStreamClient) Send(m *GatewayMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *deviceServicePresenceStreamClient) Recv() (*ServerMessage, error) {
+	m := new(ServerMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *deviceServiceClient) SyncPresence(ctx context.Context, in *PresenceSnapshot, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/device.DeviceService/SyncPresence", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DeviceServiceServer is the server API for DeviceService service.
 // All implementations must embed UnimplementedDeviceServiceServer
 // for forward compatibility
@@ -62,6 +108,12 @@ type DeviceServiceServer interface {
 	RequestUnusedUUID(context.Context, *UUIDRequest) (*Response, error)
 	// BLE device status transmission
 	SendDeviceStatus(context.Context, *DeviceStatus) (*Response, error)
+	// Long-lived channel opened by the gateway: it pushes status reports and
+	// heartbeats, and the server acknowledges reports and sends commands
+	PresenceStream(DeviceService_PresenceStreamServer) error
+	// Full presence state of a gateway: the server should consider exactly these
+	// users logged in at this gateway, as of taken_at
+	SyncPresence(context.Context, *PresenceSnapshot) (*Response, error)
 	mustEmbedUnimplementedDeviceServiceServer()
 }
 
@@ -75,6 +127,12 @@ func (UnimplementedDeviceServiceServer) RequestUnusedUUID(context.Context, *UUID
 func (UnimplementedDeviceServiceServer) SendDeviceStatus(context.Context, *DeviceStatus) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SendDeviceStatus not implemented")
 }
+func (UnimplementedDeviceServiceServer) PresenceStream(DeviceService_PresenceStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PresenceStream not implemented")
+}
+func (UnimplementedDeviceServiceServer) SyncPresence(context.Context, *PresenceSnapshot) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SyncPresence not implemented")
+}
 func (UnimplementedDeviceServiceServer) mustEmbedUnimplementedDeviceServiceServer() {}
 
 // UnsafeDeviceServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -124,6 +182,50 @@ func _DeviceService_SendDeviceStatus_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _DeviceService_PresenceStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DeviceServiceServer).PresenceStream(&deviceServicePresenceStreamServer{stream})
+}
+
+type DeviceService_PresenceStreamServer interface {
+	Send(*ServerMessage) error
+	Recv() (*GatewayMessage, error)
+	grpc.ServerStream
+}
+
+type deviceServicePresenceStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *deviceServicePresenceStreamServer) Send(m *ServerMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *deviceServicePresenceStreamServer) Recv() (*GatewayMessage, error) {
+	m := new(GatewayMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _DeviceService_SyncPresence_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PresenceSnapshot)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeviceServiceServer).SyncPresence(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.DeviceService/SyncPresence",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeviceServiceServer).SyncPresence(ctx, req.(*PresenceSnapshot))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // DeviceService_ServiceDesc is the grpc.ServiceDesc for DeviceService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -139,6 +241,347 @@ var DeviceService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SendDeviceStatus",
 			Handler:    _DeviceService_SendDeviceStatus_Handler,
 		},
+		{
+			MethodName: "SyncPresence",
+			Handler:    _DeviceService_SyncPresence_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PresenceStream",
+			Handler:       _DeviceService_PresenceStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "proto/ble.proto",
+}
+
+// RegistryServiceClient is the client API for RegistryService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RegistryServiceClient interface {
+	// Add devices in one transaction; fails without changes if any entry is invalid or already registered
+	ImportDevices(ctx context.Context, in *ImportDevicesRequest, opts ...grpc.CallOption) (*ImportDevicesResponse, error)
+	// List devices, optionally filtered
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// Return a UUID to the pool of unused UUIDs
+	DeactivateDevice(ctx context.Context, in *DeviceRequest, opts ...grpc.CallOption) (*Response, error)
+	// Permanently withdraw a UUID (lost or stolen sensor); it is never handed out again
+	RevokeDevice(ctx context.Context, in *RevokeDeviceRequest, opts ...grpc.CallOption) (*Response, error)
+	// Give an existing UUID to a new user
+	ReassignDevice(ctx context.Context, in *ReassignDeviceRequest, opts ...grpc.CallOption) (*Response, error)
+}
+
+type registryServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRegistryServiceClient(cc grpc.ClientConnInterface) RegistryServiceClient {
+	return &registryServiceClient{cc}
+}
+
+func (c *registryServiceClient) ImportDevices(ctx context.Context, in *ImportDevicesRequest, opts ...grpc.CallOption) (*ImportDevicesResponse, error) {
+	out := new(ImportDevicesResponse)
+	err := c.cc.Invoke(ctx, "/device.RegistryService/ImportDevices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryServiceClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, "/device.RegistryService/ListDevices", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryServiceClient) DeactivateDevice(ctx context.Context, in *DeviceRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/device.RegistryService/DeactivateDevice", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryServiceClient) RevokeDevice(ctx context.Context, in *RevokeDeviceRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/device.RegistryService/RevokeDevice", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *registryServiceClient) ReassignDevice(ctx context.Context, in *ReassignDeviceRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/device.RegistryService/ReassignDevice", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RegistryServiceServer is the server API for RegistryService service.
+// All implementations must embed UnimplementedRegistryServiceServer
+// for forward compatibility
+type RegistryServiceServer interface {
+	// Add devices in one transaction; fails without changes if any entry is invalid or already registered
+	ImportDevices(context.Context, *ImportDevicesRequest) (*ImportDevicesResponse, error)
+	// List devices, optionally filtered
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// Return a UUID to the pool of unused UUIDs
+	DeactivateDevice(context.Context, *DeviceRequest) (*Response, error)
+	// Permanently withdraw a UUID (lost or stolen sensor); it is never handed out again
+	RevokeDevice(context.Context, *RevokeDeviceRequest) (*Response, error)
+	// Give an existing UUID to a new user
+	ReassignDevice(context.Context, *ReassignDeviceRequest) (*Response, error)
+	mustEmbedUnimplementedRegistryServiceServer()
+}
+
+// UnimplementedRegistryServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedRegistryServiceServer struct {
+}
+
+func (UnimplementedRegistryServiceServer) ImportDevices(context.Context, *ImportDevicesRequest) (*ImportDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportDevices not implemented")
+}
+func (UnimplementedRegistryServiceServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedRegistryServiceServer) DeactivateDevice(context.Context, *DeviceRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeactivateDevice not implemented")
+}
+func (UnimplementedRegistryServiceServer) RevokeDevice(context.Context, *RevokeDeviceRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeDevice not implemented")
+}
+func (UnimplementedRegistryServiceServer) ReassignDevice(context.Context, *ReassignDeviceRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReassignDevice not implemented")
+}
+func (UnimplementedRegistryServiceServer) mustEmbedUnimplementedRegistryServiceServer() {}
+
+// UnsafeRegistryServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RegistryServiceServer will
+// result in compilation errors.
+type UnsafeRegistryServiceServer interface {
+	mustEmbedUnimplementedRegistryServiceServer()
+}
+
+func RegisterRegistryServiceServer(s grpc.ServiceRegistrar, srv RegistryServiceServer) {
+	s.RegisterService(&RegistryService_ServiceDesc, srv)
+}
+
+func _RegistryService_ImportDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServiceServer).ImportDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.RegistryService/ImportDevices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServiceServer).ImportDevices(ctx, req.(*ImportDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RegistryService_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServiceServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.RegistryService/ListDevices",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServiceServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RegistryService_DeactivateDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServiceServer).DeactivateDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.RegistryService/DeactivateDevice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServiceServer).DeactivateDevice(ctx, req.(*DeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RegistryService_RevokeDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServiceServer).RevokeDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.RegistryService/RevokeDevice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServiceServer).RevokeDevice(ctx, req.(*RevokeDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RegistryService_ReassignDevice_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReassignDeviceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RegistryServiceServer).ReassignDevice(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.RegistryService/ReassignDevice",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RegistryServiceServer).ReassignDevice(ctx, req.(*ReassignDeviceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// RegistryService_ServiceDesc is the grpc.ServiceDesc for RegistryService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RegistryService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "device.RegistryService",
+	HandlerType: (*RegistryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ImportDevices",
+			Handler:    _RegistryService_ImportDevices_Handler,
+		},
+		{
+			MethodName: "ListDevices",
+			Handler:    _RegistryService_ListDevices_Handler,
+		},
+		{
+			MethodName: "DeactivateDevice",
+			Handler:    _RegistryService_DeactivateDevice_Handler,
+		},
+		{
+			MethodName: "RevokeDevice",
+			Handler:    _RegistryService_RevokeDevice_Handler,
+		},
+		{
+			MethodName: "ReassignDevice",
+			Handler:    _RegistryService_ReassignDevice_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/ble.proto",
+}
+
+// AdminServiceClient is the client API for AdminService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminServiceClient interface {
+	// Re-read the configuration file and environment and apply them without restarting;
+	// fails without changes if the new configuration is invalid
+	ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error)
+}
+
+type adminServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminServiceClient(cc grpc.ClientConnInterface) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) ReloadConfig(ctx context.Context, in *ReloadConfigRequest, opts ...grpc.CallOption) (*ReloadConfigResponse, error) {
+	out := new(ReloadConfigResponse)
+	err := c.cc.Invoke(ctx, "/device.AdminService/ReloadConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServiceServer is the server API for AdminService service.
+// All implementations must embed UnimplementedAdminServiceServer
+// for forward compatibility
+type AdminServiceServer interface {
+	// Re-read the configuration file and environment and apply them without restarting;
+	// fails without changes if the new configuration is invalid
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+// UnimplementedAdminServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServiceServer struct {
+}
+
+func (UnimplementedAdminServiceServer) ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadConfig not implemented")
+}
+func (UnimplementedAdminServiceServer) mustEmbedUnimplementedAdminServiceServer() {}
+
+// UnsafeAdminServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServiceServer will
+// result in compilation errors.
+type UnsafeAdminServiceServer interface {
+	mustEmbedUnimplementedAdminServiceServer()
+}
+
+func RegisterAdminServiceServer(s grpc.ServiceRegistrar, srv AdminServiceServer) {
+	s.RegisterService(&AdminService_ServiceDesc, srv)
+}
+
+func _AdminService_ReloadConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServiceServer).ReloadConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/device.AdminService/ReloadConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServiceServer).ReloadConfig(ctx, req.(*ReloadConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// AdminService_ServiceDesc is the grpc.ServiceDesc for AdminService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AdminService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "device.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ReloadConfig",
+			Handler:    _AdminService_ReloadConfig_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/ble.proto",
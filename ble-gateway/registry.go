@@ -0,0 +1,185 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "text/tabwriter"
+    "time"
+    "ble-gateway/handler"
+    pb "ble-gateway/proto"
+    "ble-gateway/store"
+)
+
+// registry subcommand: gateway registry <action> [flags] [args]
+func runRegistry(args []string) error {
+    usage := func(output io.Writer) {
+        fmt.Fprintln(output, "Usage: ble-gateway registry <action> [flags] [args]")
+        fmt.Fprintln(output, "Manages the UUID registry of a gateway through its RegistryService.")
+        fmt.Fprintln(output, "Actions:")
+        fmt.Fprintln(output, "  import <devices.csv>     add devices from CSV rows: uuid,device_name[,beacon_id]")
+        fmt.Fprintln(output, "  list                     list devices (-filter, -name, -limit)")
+        fmt.Fprintln(output, "  deactivate <uuid>        return a UUID to the pool of unused UUIDs")
+        fmt.Fprintln(output, "  revoke <uuid>            withdraw a UUID for good (-reason)")
+        fmt.Fprintln(output, "  reassign <uuid> <name>   give a UUID to a new user")
+    }
+    if len(args) == 0 {
+        usage(os.Stderr)
+        os.Exit(2)
+    }
+    action := args[0]
+
+    fs := flag.NewFlagSet("registry "+action, flag.ExitOnError)
+    fs.Usage = func() {
+        usage(fs.Output())
+        fmt.Fprintln(fs.Output(), "Flags:")
+        fs.PrintDefaults()
+    }
+    address := fs.String("addr", handler.DefaultGatewayAddress, "address of the gateway's gRPC server")
+    secretFile := fs.String("auth-secret-file", "", "file holding the shared secret that signs the calls")
+    tlsConfig := &handler.TLSConfig{}
+    upstreamTLSFlags(fs, tlsConfig)
+    filter := fs.String("filter", "all", "list: all, active, inactive or revoked")
+    name := fs.String("name", "", "list: only devices whose name contains this text")
+    limit := fs.Int("limit", 0, "list: maximum number of devices (0: no limit)")
+    reason := fs.String("reason", "", "revoke: why the UUID is revoked")
+    fs.Parse(args[1:])
+
+    positional := map[string]int{"import": 1, "list": 0, "deactivate": 1, "revoke": 1, "reassign": 2}
+    count, known := positional[action]
+    if !known || fs.NArg() != count {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    // Catch malformed UUIDs before contacting the gateway
+    if action == "deactivate" || action == "revoke" || action == "reassign" {
+        if _, err := store.NormalizeUUID(fs.Arg(0)); err != nil {
+            return err
+        }
+    }
+
+    cfg := handler.ClientConfig{Address: *address, TLS: *tlsConfig, Identity: handler.DefaultIdentity()}
+    if *secretFile != "" {
+        secret, err := handler.LoadSecret(*secretFile)
+        if err != nil {
+            return err
+        }
+        cfg.Secret = secret
+    }
+    client, conn, err := handler.DialRegistry(cfg)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    switch action {
+    case "import":
+        devices, err := readDeviceCSV(fs.Arg(0))
+        if err != nil {
+            return err
+        }
+        res, err := client.ImportDevices(ctx, &pb.ImportDevicesRequest{Devices: devices})
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Imported %d devices.\n", res.Imported)
+    case "list":
+        filters := map[string]pb.DeviceFilter{
+            "all":      pb.DeviceFilter_DEVICE_FILTER_ALL,
+            "active":   pb.DeviceFilter_DEVICE_FILTER_ACTIVE,
+            "inactive": pb.DeviceFilter_DEVICE_FILTER_INACTIVE,
+            "revoked":  pb.DeviceFilter_DEVICE_FILTER_REVOKED,
+        }
+        state, valid := filters[*filter]
+        if !valid || *limit < 0 {
+            return fmt.Errorf("-filter must be all, active, inactive or revoked and -limit must not be negative")
+        }
+        res, err := client.ListDevices(ctx, &pb.ListDevicesRequest{Filter: state, NameContains: *name, Limit: uint32(*limit)})
+        if err != nil {
+            return err
+        }
+        printDevices(res.Devices)
+    case "deactivate":
+        if _, err := client.DeactivateDevice(ctx, &pb.DeviceRequest{Uuid: fs.Arg(0)}); err != nil {
+            return err
+        }
+        fmt.Printf("Deactivated %s.\n", fs.Arg(0))
+    case "revoke":
+        if _, err := client.RevokeDevice(ctx, &pb.RevokeDeviceRequest{Uuid: fs.Arg(0), Reason: *reason}); err != nil {
+            return err
+        }
+        fmt.Printf("Revoked %s.\n", fs.Arg(0))
+    case "reassign":
+        if _, err := client.ReassignDevice(ctx, &pb.ReassignDeviceRequest{Uuid: fs.Arg(0), DeviceName: fs.Arg(1)}); err != nil {
+            return err
+        }
+        fmt.Printf("Reassigned %s to %s.\n", fs.Arg(0), fs.Arg(1))
+    }
+    return nil
+}
+
+// Read uuid,device_name[,beacon_id] rows; a first row starting with "uuid" is a header
+func readDeviceCSV(path string) ([]*pb.Device, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open device list: %v", err)
+    }
+    defer file.Close()
+
+    reader := csv.NewReader(file)
+    reader.FieldsPerRecord = -1
+    reader.TrimLeadingSpace = true
+    records, err := reader.ReadAll()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read device list: %v", err)
+    }
+
+    var devices []*pb.Device
+    for i, record := range records {
+        if i == 0 && strings.EqualFold(record[0], "uuid") {
+            continue
+        }
+        if len(record) < 2 || len(record) > 3 {
+            return nil, fmt.Errorf("%s line %d: expected uuid,device_name[,beacon_id]", path, i+1)
+        }
+        if _, err := store.NormalizeUUID(record[0]); err != nil {
+            return nil, fmt.Errorf("%s line %d: %v", path, i+1, err)
+        }
+
+        device := &pb.Device{Uuid: record[0], DeviceName: record[1]}
+        if len(record) == 3 {
+            device.BeaconId = record[2]
+        }
+        devices = append(devices, device)
+    }
+    return devices, nil
+}
+
+func printDevices(devices []*pb.Device) {
+    w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "UUID\tNAME\tSTATE\tBEACON\tNOTE")
+    for _, device := range devices {
+        state, note := "inactive", ""
+        if device.Active {
+            state = "active"
+        }
+        if device.Revoked {
+            state = "revoked"
+            note = device.RevokedAt.AsTime().Local().Format(time.RFC3339)
+            if device.RevokeReason != "" {
+                note += " " + device.RevokeReason
+            }
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", device.Uuid, device.DeviceName, state, device.BeaconId, note)
+    }
+    w.Flush()
+    fmt.Printf("%d devices.\n", len(devices))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "os/signal"
+    "reflect"
+    "strings"
+    "sync"
+    "syscall"
+    "ble-gateway/ble"
+    "ble-gateway/config"
+    "ble-gateway/handler"
+)
+
+// reloader applies a changed configuration to the running gateway.
+// The scan settings and the BALogin server address take effect at once;
+// every other change is reported and waits for a restart.
+type reloader struct {
+    mu      sync.Mutex
+    args    []string      // Command line the gateway was started with
+    current config.Config // Settings in effect
+
+    radio   ble.Radio
+    monitor *ble.Monitor
+    client  *handler.Client
+}
+
+// Report whether a running gateway applies a changed setting on reload
+func reloadable(key string) bool {
+    return strings.HasPrefix(key, "scan.") || key == "server.address"
+}
+
+// Reload reads the configuration again from the file, the environment and the
+// original flags. An invalid configuration is rejected and the old one keeps running.
+func (r *reloader) Reload() (applied []string, restartRequired []string, err error) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    next, err := r.load()
+    if err != nil {
+        return nil, nil, err
+    }
+    for _, key := range config.Diff(r.current, *next) {
+        if reloadable(key) {
+            applied = append(applied, key)
+        } else {
+            restartRequired = append(restartRequired, key)
+        }
+    }
+
+    // Nothing is changed unless every change can be applied: the scan settings
+    // are checked before connecting, and the old address comes back if they still fail
+    scanChanged := !reflect.DeepEqual(next.Scan, r.current.Scan)
+    if scanChanged {
+        if err := next.Scan.Validate(); err != nil {
+            return nil, nil, fmt.Errorf("scan: %v", err)
+        }
+    }
+    previous := r.current.Server.Address
+    if next.Server.Address != previous {
+        if err := r.client.SetAddress(next.Server.Address); err != nil {
+            return nil, nil, err
+        }
+    }
+    if scanChanged {
+        if err := ble.Reconfigure(r.radio, r.monitor, next.Scan); err != nil {
+            if next.Server.Address != previous {
+                if err := r.client.SetAddress(previous); err != nil {
+                    log.Printf("Failed to switch back to BALogin server %s: %v", previous, err)
+                    r.current.Server.Address = next.Server.Address
+                }
+            }
+            return nil, nil, err
+        }
+    }
+    r.current.Server.Address = next.Server.Address
+    r.current.Scan = next.Scan
+    return applied, restartRequired, nil
+}
+
+// Read and validate the configuration the way the gateway did at startup
+func (r *reloader) load() (*config.Config, error) {
+    fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    cfg, err := readGatewayConfig(fs, r.args)
+    if err != nil {
+        return nil, err
+    }
+    runtimeFlags(fs)
+    if err := fs.Parse(r.args); err != nil {
+        return nil, err
+    }
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
+    if err := cfg.CheckFiles(); err != nil {
+        return nil, err
+    }
+    return cfg, nil
+}
+
+// Reload the configuration on every SIGHUP
+func (r *reloader) watchSignals() {
+    hangup := make(chan os.Signal, 1)
+    signal.Notify(hangup, syscall.SIGHUP)
+    for range hangup {
+        r.reloadFrom("SIGHUP")
+    }
+}
+
+// Reload and log the outcome; source names what asked for the reload
+func (r *reloader) reloadFrom(source string) (applied []string, restartRequired []string, err error) {
+    fmt.Printf("Reloading configuration (%s)...\n", source)
+    applied, restartRequired, err = r.Reload()
+    if err != nil {
+        log.Printf("Configuration not reloaded, keeping the old one:\n%v", err)
+        return nil, nil, err
+    }
+
+    if len(applied) == 0 {
+        fmt.Println("Configuration reloaded, no changes applied.")
+    } else {
+        fmt.Printf("Configuration reloaded, applied: %s\n", strings.Join(applied, ", "))
+    }
+    if len(restartRequired) > 0 {
+        log.Printf("Changed settings that take effect after a restart: %s", strings.Join(restartRequired, ", "))
+    }
+    return applied, restartRequired, nil
+}
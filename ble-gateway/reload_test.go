@@ -0,0 +1,173 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+    "ble-gateway/ble"
+    "ble-gateway/handler"
+    "ble-gateway/presence"
+)
+
+const baseConfig = `
+database: gateway.db
+server:
+  address: localhost:50061
+scan:
+  mode: passive
+  enter_rssi: -80
+  exit_rssi: -90
+`
+
+// Start a reloader of a gateway configured by the file content, and return the file's path
+func newTestReloader(t *testing.T, content string) (*reloader, string) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "gateway.yaml")
+    writeConfig(t, path, content)
+
+    r := &reloader{args: []string{"-config", path}}
+    cfg, err := r.load()
+    if err != nil {
+        t.Fatalf("failed to load config: %v", err)
+    }
+    r.current = *cfg
+
+    r.client, err = handler.NewClient(handler.ClientConfig{Address: cfg.Server.Address, Identity: cfg.Identity})
+    if err != nil {
+        t.Fatalf("failed to create client: %v", err)
+    }
+    t.Cleanup(func() { r.client.Close() })
+
+    r.monitor = ble.NewMonitor(cfg.Scan, presence.NewManualClock(time.Unix(1700000000, 0)))
+    r.monitor.Start()
+    t.Cleanup(r.monitor.Stop)
+    r.radio = ble.NewSimRadio(nil)
+    return r, path
+}
+
+func writeConfig(t *testing.T, path string, content string) {
+    t.Helper()
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestReload(t *testing.T) {
+    tests := []struct {
+        name            string
+        content         string
+        wantErr         bool
+        applied         []string
+        restartRequired []string
+        address         string // Server address in use afterwards
+        enterRSSI       int    // Enter threshold of the monitor afterwards
+    }{
+        {
+            name:      "unchanged",
+            content:   baseConfig,
+            address:   "localhost:50061",
+            enterRSSI: -80,
+        },
+        {
+            name: "scan and server address applied",
+            content: `
+database: gateway.db
+server:
+  address: localhost:50062
+scan:
+  mode: passive
+  enter_rssi: -75
+  exit_rssi: -90
+`,
+            applied:   []string{"server.address", "scan.enter_rssi"},
+            address:   "localhost:50062",
+            enterRSSI: -75,
+        },
+        {
+            name: "other settings wait for a restart",
+            content: `
+database: other.db
+server:
+  address: localhost:50061
+  heartbeat: 5s
+scan:
+  mode: passive
+  enter_rssi: -80
+  exit_rssi: -90
+`,
+            restartRequired: []string{"database", "server.heartbeat"},
+            address:         "localhost:50061",
+            enterRSSI:       -80,
+        },
+        {
+            name: "invalid scan settings change nothing",
+            content: `
+database: gateway.db
+server:
+  address: localhost:50062
+scan:
+  mode: passive
+  enter_rssi: -95
+  exit_rssi: -90
+`,
+            wantErr:   true,
+            address:   "localhost:50061",
+            enterRSSI: -80,
+        },
+        {
+            name: "unknown keys change nothing",
+            content: `
+database: gateway.db
+server:
+  address: localhost:50062
+  adress: localhost:50063
+`,
+            wantErr:   true,
+            address:   "localhost:50061",
+            enterRSSI: -80,
+        },
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            r, path := newTestReloader(t, baseConfig)
+            before := r.current
+            writeConfig(t, path, tt.content)
+
+            applied, restartRequired, err := r.Reload()
+            if tt.wantErr {
+                if err == nil {
+                    t.Fatal("invalid configuration reloaded")
+                }
+                if !reflect.DeepEqual(r.current, before) {
+                    t.Errorf("settings in effect changed by a failed reload:\n%+v\nwant\n%+v", r.current, before)
+                }
+            } else if err != nil {
+                t.Fatalf("Reload failed: %v", err)
+            }
+            if !reflect.DeepEqual(applied, tt.applied) || !reflect.DeepEqual(restartRequired, tt.restartRequired) {
+                t.Errorf("Reload = applied %v, restart required %v, want %v and %v", applied, restartRequired, tt.applied, tt.restartRequired)
+            }
+            if got := r.client.Address(); got != tt.address {
+                t.Errorf("server address %s, want %s", got, tt.address)
+            }
+            if got := r.monitor.Config().EnterRSSI; got != tt.enterRSSI {
+                t.Errorf("monitor enter RSSI %d, want %d", got, tt.enterRSSI)
+            }
+        })
+    }
+}
+
+func TestReloadMissingFile(t *testing.T) {
+    r, path := newTestReloader(t, baseConfig)
+    if err := os.Remove(path); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := r.Reload(); err == nil {
+        t.Error("reload without the config file succeeded")
+    }
+    if got := r.client.Address(); got != "localhost:50061" {
+        t.Errorf("server address %s after a failed reload, want localhost:50061", got)
+    }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+    "ble-gateway/ble"
+    "ble-gateway/config"
+    "ble-gateway/db"
+)
+
+// replay subcommand: gateway replay [flags] <trace.jsonl>
+func runReplay(args []string) error {
+    fs := flag.NewFlagSet("replay", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: ble-gateway replay [flags] <trace.jsonl>")
+        fmt.Fprintln(fs.Output(), "Replays a scan trace recorded with -trace and prints the resulting login/logout reports.")
+        fs.PrintDefaults()
+    }
+    cfg, err := loadConfig(fs, args)
+    if err != nil {
+        return err
+    }
+    fs.StringVar(&cfg.Database, "db", cfg.Database, "path of the gateway database")
+    scanFlags(fs, &cfg.Scan)
+    if err := config.ApplyEnv(fs); err != nil {
+        return err
+    }
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    if err := cfg.Scan.Validate(); err != nil {
+        return fmt.Errorf("invalid configuration: %v", err)
+    }
+
+    file, err := os.Open(fs.Arg(0))
+    if err != nil {
+        return fmt.Errorf("failed to open trace: %v", err)
+    }
+    defer file.Close()
+
+    // The trace may come from another gateway: never create or migrate its database
+    database, err := db.OpenReadOnly(cfg.Database)
+    if err != nil {
+        return err
+    }
+    defer database.Close()
+
+    return ble.Replay(db.NewStore(database), file, cfg.Scan, os.Stdout)
+}
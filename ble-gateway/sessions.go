@@ -0,0 +1,115 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "text/tabwriter"
+    "time"
+    "ble-gateway/config"
+    "ble-gateway/db"
+    "ble-gateway/store"
+)
+
+// Time formats accepted by the sessions subcommand, in local time unless a zone is given
+var sessionTimeFormats = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// sessions subcommand: gateway sessions [flags]
+func runSessions(args []string) error {
+    fs := flag.NewFlagSet("sessions", flag.ExitOnError)
+    fs.Usage = func() {
+        fmt.Fprintln(fs.Output(), "Usage: ble-gateway sessions [flags]")
+        fmt.Fprintln(fs.Output(), "Lists the login sessions recorded in the gateway database, latest first.")
+        fmt.Fprintln(fs.Output(), "Times are RFC 3339 or local \"2006-01-02 15:04\".")
+        fs.PrintDefaults()
+    }
+    cfg, err := loadConfig(fs, args)
+    if err != nil {
+        return err
+    }
+    fs.StringVar(&cfg.Database, "db", cfg.Database, "path of the gateway database")
+    if err := config.ApplyEnv(fs); err != nil {
+        return err
+    }
+    uuid := fs.String("uuid", "", "only sessions of this UUID")
+    from := fs.String("from", "", "only sessions still open at or after this time")
+    to := fs.String("to", "", "only sessions started at or before this time")
+    at := fs.String("at", "", "only sessions open at this time (sets -from and -to)")
+    limit := fs.Int("limit", 100, "maximum number of sessions (0: no limit)")
+    prune := fs.Duration("prune", 0, "delete sessions that ended longer ago than this instead of listing")
+    fs.Parse(args)
+    if fs.NArg() != 0 || *limit < 0 || *prune < 0 {
+        fs.Usage()
+        os.Exit(2)
+    }
+
+    filter := store.SessionFilter{Limit: *limit}
+    if *uuid != "" {
+        normalized, err := store.NormalizeUUID(*uuid)
+        if err != nil {
+            return err
+        }
+        filter.UUID = normalized
+    }
+    if *at != "" {
+        *from, *to = *at, *at
+    }
+    if filter.From, err = parseSessionTime("-from", *from); err != nil {
+        return err
+    }
+    if filter.To, err = parseSessionTime("-to", *to); err != nil {
+        return err
+    }
+
+    database, err := db.Open(cfg.Database)
+    if err != nil {
+        return err
+    }
+    defer database.Close()
+    devices := db.NewStore(database)
+
+    if *prune > 0 {
+        pruned, err := devices.PruneSessions(time.Now().Add(-*prune))
+        if err != nil {
+            return err
+        }
+        fmt.Printf("Pruned %d sessions.\n", pruned)
+        return nil
+    }
+
+    sessions, err := devices.ListSessions(filter)
+    if err != nil {
+        return err
+    }
+    printSessions(os.Stdout, sessions)
+    return nil
+}
+
+// Parse a time flag; an empty value is the zero time
+func parseSessionTime(name string, value string) (time.Time, error) {
+    if value == "" {
+        return time.Time{}, nil
+    }
+    for _, format := range sessionTimeFormats {
+        if t, err := time.ParseInLocation(format, value, time.Local); err == nil {
+            return t, nil
+        }
+    }
+    return time.Time{}, fmt.Errorf("%s: cannot parse time %q", name, value)
+}
+
+func printSessions(output io.Writer, sessions []store.Session) {
+    w := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, "UUID\tMAC\tGATEWAY\tSTART\tEND\tREASON\tPEAK RSSI")
+    for _, session := range sessions {
+        end := "(logged in)"
+        if !session.Open() {
+            end = session.EndedAt.Local().Format(time.RFC3339)
+        }
+        fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", session.UUID, session.Address, session.Gateway,
+            session.StartedAt.Local().Format(time.RFC3339), end, session.EndReason, session.PeakRSSI)
+    }
+    w.Flush()
+    fmt.Fprintf(output, "%d sessions.\n", len(sessions))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+    "ble-gateway/config"
+    "ble-gateway/db"
+    "ble-gateway/store"
+)
+
+func TestParseSessionTime(t *testing.T) {
+    tests := []struct {
+        value   string
+        want    time.Time
+        wantErr bool
+    }{
+        {value: "", want: time.Time{}},
+        {value: "2024-11-10T16:13:31+09:00", want: time.Date(2024, 11, 10, 7, 13, 31, 0, time.UTC)},
+        {value: "2024-11-10 16:13:31", want: time.Date(2024, 11, 10, 16, 13, 31, 0, time.Local)},
+        {value: "2024-11-10 16:13", want: time.Date(2024, 11, 10, 16, 13, 0, 0, time.Local)},
+        {value: "2024-11-10", want: time.Date(2024, 11, 10, 0, 0, 0, 0, time.Local)},
+        {value: "10/11/2024", wantErr: true},
+        {value: "yesterday", wantErr: true},
+    }
+    for _, tt := range tests {
+        got, err := parseSessionTime("-from", tt.value)
+        if tt.wantErr {
+            if err == nil || !strings.HasPrefix(err.Error(), "-from: ") {
+                t.Errorf("parseSessionTime(%q) error = %v, want one naming -from", tt.value, err)
+            }
+            continue
+        }
+        if err != nil || !got.Equal(tt.want) {
+            t.Errorf("parseSessionTime(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+        }
+    }
+}
+
+func TestPrintSessions(t *testing.T) {
+    start := time.Date(2024, 11, 10, 16, 0, 0, 0, time.Local)
+    var out strings.Builder
+    printSessions(&out, []store.Session{
+        {UUID: "00000000-0000-4000-8000-000000000001", Address: "AA:BB:CC:DD:EE:01", Gateway: "gw-1", StartedAt: start, PeakRSSI: -60},
+        {UUID: "00000000-0000-4000-8000-000000000002", Address: "AA:BB:CC:DD:EE:02", Gateway: "gw-1", StartedAt: start, EndedAt: start.Add(time.Hour), EndReason: "timeout", PeakRSSI: -72},
+    })
+
+    lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+    if len(lines) != 4 {
+        t.Fatalf("printed %q, want a header, two sessions and a count", out.String())
+    }
+    if fields := strings.Fields(lines[1]); len(fields) != 7 || fields[4] != "(logged" || fields[6] != "-60" {
+        t.Errorf("open session printed as %q", lines[1])
+    }
+    if fields := strings.Fields(lines[2]); len(fields) != 7 || fields[4] != start.Add(time.Hour).Format(time.RFC3339) || fields[5] != "timeout" {
+        t.Errorf("ended session printed as %q", lines[2])
+    }
+    if lines[3] != "2 sessions." {
+        t.Errorf("count printed as %q", lines[3])
+    }
+}
+
+func TestSessionsCommandPrunes(t *testing.T) {
+    t.Setenv(config.PathEnv, "")
+    path := filepath.Join(t.TempDir(), "ble.db")
+    database, err := db.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    devices := db.NewStore(database)
+    now := time.Now()
+    for i, ended := range []time.Duration{72 * time.Hour, time.Hour, 0} {
+        uuid := fmt.Sprintf("00000000-0000-4000-8000-%012d", i+1)
+        id, err := devices.StartSession(store.Session{UUID: uuid, Address: "AA:BB:CC:DD:EE:01", Gateway: "gw-1", StartedAt: now.Add(-96 * time.Hour), PeakRSSI: -60})
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ended > 0 {
+            if err := devices.EndSession(id, now.Add(-ended), "timeout", -60); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+    database.Close()
+
+    if err := runSessions([]string{"-db", path, "-uuid", "not-a-uuid"}); err == nil {
+        t.Error("sessions listed for an invalid UUID")
+    }
+    if err := runSessions([]string{"-db", path, "-at", "yesterday"}); err == nil {
+        t.Error("sessions listed for an invalid time")
+    }
+    if err := runSessions([]string{"-db", path, "-prune", "24h"}); err != nil {
+        t.Fatalf("prune failed: %v", err)
+    }
+
+    database, err = db.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer database.Close()
+    sessions, err := db.NewStore(database).ListSessions(store.SessionFilter{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(sessions) != 2 {
+        t.Errorf("%d sessions left, want the recently ended and the open one", len(sessions))
+    }
+    for _, session := range sessions {
+        if session.UUID == "00000000-0000-4000-8000-000000000001" {
+            t.Errorf("session that ended 72 hours ago kept: %+v", session)
+        }
+    }
+}
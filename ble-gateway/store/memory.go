@@ -0,0 +1,325 @@
+package store
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// MemoryStore is a DeviceStore kept in memory, for tests and replays that
+// should not touch a database file. It is safe for concurrent use.
+type MemoryStore struct {
+    mu          sync.Mutex
+    devices     map[string]*Device
+    allocations map[string]string // Request key -> UUID
+    samples     map[string][]CalibrationSample
+    sessions    []Session // In order of ID
+    lastSession int64
+}
+
+// NewMemoryStore returns a store holding devices, which are copied
+func NewMemoryStore(devices ...Device) *MemoryStore {
+    s := &MemoryStore{
+        devices:     make(map[string]*Device),
+        allocations: make(map[string]string),
+        samples:     make(map[string][]CalibrationSample),
+    }
+    for _, device := range devices {
+        device := device
+        s.devices[device.UUID] = &device
+    }
+    return s
+}
+
+func (s *MemoryStore) Device(uuid string) (Device, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    device, found := s.devices[uuid]
+    if !found {
+        return Device{}, fmt.Errorf("%w: %s", ErrNotFound, uuid)
+    }
+    return *device, nil
+}
+
+func (s *MemoryStore) IsActive(uuid string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    device, found := s.devices[uuid]
+    return found && device.Active, nil
+}
+
+func (s *MemoryStore) FindByBeacon(beaconID string) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for _, device := range s.devices {
+        if device.BeaconID != "" && device.BeaconID == beaconID {
+            return device.UUID, nil
+        }
+    }
+    return "", nil
+}
+
+func (s *MemoryStore) ListDevices(filter DeviceFilter) ([]Device, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var devices []Device
+    for _, uuid := range s.sortedUUIDs() {
+        device := s.devices[uuid]
+        switch filter.State {
+        case FilterAll:
+        case FilterActive:
+            if !device.Active {
+                continue
+            }
+        case FilterInactive:
+            if device.Active || device.Revoked() {
+                continue
+            }
+        case FilterRevoked:
+            if !device.Revoked() {
+                continue
+            }
+        default:
+            return nil, fmt.Errorf("unknown device filter %q", filter.State)
+        }
+        if filter.NameContains != "" && !strings.Contains(strings.ToLower(device.Name), strings.ToLower(filter.NameContains)) {
+            continue
+        }
+        devices = append(devices, *device)
+        if filter.Limit > 0 && len(devices) == filter.Limit {
+            break
+        }
+    }
+    return devices, nil
+}
+
+// AllocateUUID activates the first unused UUID in UUID order.
+// Calls with the same non-empty requestKey return the same UUID.
+func (s *MemoryStore) AllocateUUID(requestKey string) (string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if uuid, found := s.allocations[requestKey]; found && requestKey != "" {
+        return uuid, nil
+    }
+    for _, uuid := range s.sortedUUIDs() {
+        device := s.devices[uuid]
+        if device.Active || device.Revoked() {
+            continue
+        }
+        device.Active = true
+        if requestKey != "" {
+            s.allocations[requestKey] = uuid
+        }
+        return uuid, nil
+    }
+    return "", ErrExhausted
+}
+
+// ImportDevices adds inactive devices; nothing is added if any entry fails
+func (s *MemoryStore) ImportDevices(devices []Device) (int, error) {
+    if err := ValidateImport(devices); err != nil {
+        return 0, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    beacons := make(map[string]bool)
+    for _, device := range s.devices {
+        if device.BeaconID != "" {
+            beacons[device.BeaconID] = true
+        }
+    }
+    for i, device := range devices {
+        if _, found := s.devices[device.UUID]; found {
+            return 0, fmt.Errorf("entry %d: %w: %s", i+1, ErrExists, device.UUID)
+        }
+        if device.BeaconID == "" {
+            continue
+        }
+        if beacons[device.BeaconID] {
+            return 0, fmt.Errorf("entry %d: beacon %s is already registered", i+1, device.BeaconID)
+        }
+        beacons[device.BeaconID] = true
+    }
+
+    for _, device := range devices {
+        s.devices[device.UUID] = &Device{UUID: device.UUID, Name: device.Name, BeaconID: device.BeaconID}
+    }
+    return len(devices), nil
+}
+
+func (s *MemoryStore) Deactivate(uuid string) error {
+    return s.update(uuid, func(device *Device) {
+        device.Active = false
+    })
+}
+
+func (s *MemoryStore) Revoke(uuid string, reason string) error {
+    return s.update(uuid, func(device *Device) {
+        device.Active = false
+        device.RevokedAt = time.Now()
+        device.RevokeReason = reason
+    })
+}
+
+func (s *MemoryStore) Reassign(uuid string, name string) error {
+    if strings.TrimSpace(name) == "" {
+        return ErrMissingName
+    }
+    return s.update(uuid, func(device *Device) {
+        device.Name = name
+        device.Active = true
+    })
+}
+
+func (s *MemoryStore) AddCalibrationSamples(uuid string, samples []CalibrationSample) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.samples[uuid] = append(s.samples[uuid], samples...)
+    return nil
+}
+
+func (s *MemoryStore) CalibrationSamples(uuid string) ([]CalibrationSample, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return append([]CalibrationSample(nil), s.samples[uuid]...), nil
+}
+
+func (s *MemoryStore) SetCalibration(uuid string, txPower float64, exponent float64) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    device, found := s.devices[uuid]
+    if !found {
+        return fmt.Errorf("%w: %s", ErrNotFound, uuid)
+    }
+    device.TxPower, device.PathLossExponent = txPower, exponent
+    return nil
+}
+
+// Apply a change to a registered, unrevoked UUID and forget its earlier allocations
+func (s *MemoryStore) update(uuid string, change func(device *Device)) error {
+    uuid, err := NormalizeUUID(uuid)
+    if err != nil {
+        return err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    device, found := s.devices[uuid]
+    if !found {
+        return fmt.Errorf("%w: %s", ErrNotFound, uuid)
+    }
+    if device.Revoked() {
+        return RevokedError(uuid, device.RevokedAt)
+    }
+    change(device)
+    for key, allocated := range s.allocations {
+        if allocated == uuid {
+            delete(s.allocations, key)
+        }
+    }
+    return nil
+}
+
+func (s *MemoryStore) sortedUUIDs() []string {
+    uuids := make([]string, 0, len(s.devices))
+    for uuid := range s.devices {
+        uuids = append(uuids, uuid)
+    }
+    sort.Strings(uuids)
+    return uuids
+}
+
+func (s *MemoryStore) StartSession(session Session) (int64, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.lastSession++
+    session.ID = s.lastSession
+    session.LastSeen = session.StartedAt
+    s.sessions = append(s.sessions, session)
+    return session.ID, nil
+}
+
+func (s *MemoryStore) EndSession(id int64, endedAt time.Time, reason string, peakRSSI int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for i := range s.sessions {
+        if s.sessions[i].ID == id {
+            s.sessions[i].EndedAt, s.sessions[i].EndReason = endedAt, reason
+            if peakRSSI > s.sessions[i].PeakRSSI {
+                s.sessions[i].PeakRSSI = peakRSSI
+            }
+            return nil
+        }
+    }
+    return fmt.Errorf("session %d not found", id)
+}
+
+func (s *MemoryStore) OpenSessions(gateway string) ([]Session, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var sessions []Session
+    for _, session := range s.sessions {
+        if session.Gateway == gateway && session.Open() {
+            sessions = append(sessions, session)
+        }
+    }
+    return sessions, nil
+}
+
+func (s *MemoryStore) MarkSeen(lastSeen map[int64]time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for i := range s.sessions {
+        if seen, exists := lastSeen[s.sessions[i].ID]; exists && s.sessions[i].Open() {
+            s.sessions[i].LastSeen = seen
+        }
+    }
+    return nil
+}
+
+// ListSessions returns the matching sessions, latest first
+func (s *MemoryStore) ListSessions(filter SessionFilter) ([]Session, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var sessions []Session
+    for i := len(s.sessions) - 1; i >= 0; i-- {
+        session := s.sessions[i]
+        if filter.UUID != "" && session.UUID != filter.UUID {
+            continue
+        }
+        if !filter.To.IsZero() && session.StartedAt.After(filter.To) {
+            continue
+        }
+        if !filter.From.IsZero() && !session.Open() && session.EndedAt.Before(filter.From) {
+            continue
+        }
+        sessions = append(sessions, session)
+    }
+    sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].StartedAt.After(sessions[j].StartedAt) })
+    if filter.Limit > 0 && len(sessions) > filter.Limit {
+        sessions = sessions[:filter.Limit]
+    }
+    return sessions, nil
+}
+
+func (s *MemoryStore) PruneSessions(before time.Time) (int, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    kept := s.sessions[:0]
+    for _, session := range s.sessions {
+        if session.Open() || !session.EndedAt.Before(before) {
+            kept = append(kept, session)
+        }
+    }
+    pruned := len(s.sessions) - len(kept)
+    s.sessions = kept
+    return pruned, nil
+}
@@ -0,0 +1,13 @@
+package store_test
+
+import (
+    "testing"
+    "ble-gateway/store"
+    "ble-gateway/store/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) store.DeviceStore {
+        return store.NewMemoryStore()
+    })
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+    "time"
+    "ble-gateway/beacon"
+)
+
+// DeviceStore is the device registry used by the scanner and the gRPC handlers.
+// The gateway keeps it in SQLite (db.NewStore); MemoryStore holds it in memory.
+type DeviceStore interface {
+    // Lookup
+    Device(uuid string) (Device, error)           // ErrNotFound if the UUID is not registered
+    IsActive(uuid string) (bool, error)           // False for unregistered UUIDs
+    FindByBeacon(beaconID string) (string, error) // "" if no device has the beacon
+    ListDevices(filter DeviceFilter) ([]Device, error)
+
+    // Allocation and activation
+    AllocateUUID(requestKey string) (string, error)
+    ImportDevices(devices []Device) (int, error)
+    Deactivate(uuid string) error
+    Revoke(uuid string, reason string) error
+    Reassign(uuid string, name string) error
+
+    // Calibration
+    AddCalibrationSamples(uuid string, samples []CalibrationSample) error
+    CalibrationSamples(uuid string) ([]CalibrationSample, error)
+    SetCalibration(uuid string, txPower float64, exponent float64) error
+
+    // Presence history
+    StartSession(session Session) (int64, error)
+    EndSession(id int64, endedAt time.Time, reason string, peakRSSI int) error
+    OpenSessions(gateway string) ([]Session, error) // Devices a gateway had logged in, oldest first
+    MarkSeen(lastSeen map[int64]time.Time) error    // Checkpoints the last sighting of open sessions by ID
+    ListSessions(filter SessionFilter) ([]Session, error)
+    PruneSessions(before time.Time) (int, error) // Deletes sessions that ended before the cutoff
+}
+
+// Errors returned by the registry functions, for callers that map them to status codes
+var (
+    ErrInvalidUUID   = errors.New("invalid UUID")
+    ErrNotFound      = errors.New("UUID not registered")
+    ErrExists        = errors.New("UUID already registered")
+    ErrRevoked       = errors.New("UUID is revoked")
+    ErrMissingName   = errors.New("device name is required")
+    ErrInvalidBeacon = errors.New("invalid beacon ID")
+    ErrExhausted     = errors.New("There are not enough devices available.") // Every UUID is active or revoked
+)
+
+// Canonical 8-4-4-4-12 hex form
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Device filters for ListDevices
+const (
+    FilterAll      = ""
+    FilterActive   = "active"
+    FilterInactive = "inactive"
+    FilterRevoked  = "revoked"
+)
+
+// Device is one registered UUID
+type Device struct {
+    UUID             string
+    Name             string
+    Active           bool
+    BeaconID         string    // "" if not a registered beacon
+    RevokedAt        time.Time // Zero unless revoked
+    RevokeReason     string
+    TxPower          float64 // Path-loss constants, both 0 unless calibrated
+    PathLossExponent float64
+}
+
+// Revoked reports whether the UUID has been withdrawn
+func (d Device) Revoked() bool {
+    return !d.RevokedAt.IsZero()
+}
+
+// Calibrated reports whether path-loss constants have been stored for the device
+func (d Device) Calibrated() bool {
+    return d.PathLossExponent > 0
+}
+
+// DeviceFilter selects devices in ListDevices
+type DeviceFilter struct {
+    State        string // FilterAll, FilterActive, FilterInactive or FilterRevoked
+    NameContains string // Case-insensitive substring of the device name
+    Limit        int    // 0: no limit
+}
+
+// CalibrationSample is an RSSI reading taken at a known distance
+type CalibrationSample struct {
+    Distance float64 // Meters
+    RSSI     float64
+}
+
+// Session is one stay of a device at a gateway, from login to logout
+type Session struct {
+    ID        int64
+    UUID      string
+    Address   string // MAC address
+    Gateway   string
+    StartedAt time.Time
+    EndedAt   time.Time // Zero while the device is logged in
+    EndReason string
+    PeakRSSI  int       // Strongest filtered RSSI, in dBm
+    LastSeen  time.Time // Last sighting as of the latest checkpoint
+}
+
+// Open reports whether the device is still logged in
+func (s Session) Open() bool {
+    return s.EndedAt.IsZero()
+}
+
+// SessionFilter selects sessions in ListSessions. A session matches a time
+// range if it overlaps it; From == To finds the sessions open at that moment.
+type SessionFilter struct {
+    UUID  string    // "" for every user
+    From  time.Time // Zero: no lower bound
+    To    time.Time // Zero: no upper bound
+    Limit int       // 0: no limit
+}
+
+// NormalizeUUID validates a UUID and returns it in lower case
+func NormalizeUUID(uuid string) (string, error) {
+    normalized := strings.ToLower(strings.TrimSpace(uuid))
+    if !uuidPattern.MatchString(normalized) {
+        return "", fmt.Errorf("%w: %q is not in the form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx", ErrInvalidUUID, uuid)
+    }
+    return normalized, nil
+}
+
+// ValidateImport normalizes the UUIDs and beacon IDs of devices to import
+// and checks that every entry is named and appears only once
+func ValidateImport(devices []Device) error {
+    seen := make(map[string]bool)
+    for i := range devices {
+        uuid, err := NormalizeUUID(devices[i].UUID)
+        if err != nil {
+            return fmt.Errorf("entry %d: %w", i+1, err)
+        }
+        if strings.TrimSpace(devices[i].Name) == "" {
+            return fmt.Errorf("entry %d: %w", i+1, ErrMissingName)
+        }
+        if seen[uuid] {
+            return fmt.Errorf("entry %d: %w: %s appears more than once", i+1, ErrExists, uuid)
+        }
+        seen[uuid] = true
+        devices[i].UUID = uuid
+
+        if devices[i].BeaconID != "" {
+            beaconID, err := beacon.NormalizeID(devices[i].BeaconID)
+            if err != nil {
+                return fmt.Errorf("entry %d: %w: %v", i+1, ErrInvalidBeacon, err)
+            }
+            devices[i].BeaconID = beaconID
+        }
+    }
+    return nil
+}
+
+// RevokedError reports that an update was refused because uuid was revoked
+func RevokedError(uuid string, revokedAt time.Time) error {
+    return fmt.Errorf("%w: %s was revoked on %s", ErrRevoked, uuid, revokedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,417 @@
+// Package storetest checks that a store.DeviceStore implementation behaves
+// like the others, so code written against the interface can be tested with
+// any of them.
+package storetest
+
+import (
+    "errors"
+    "fmt"
+    "testing"
+    "time"
+    "ble-gateway/store"
+)
+
+// Opener returns an empty store; it is called once per subtest
+type Opener func(t *testing.T) store.DeviceStore
+
+// UUID of the i-th test device
+func UUID(i int) string {
+    return fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+}
+
+// Run checks the behavior every DeviceStore shares
+func Run(t *testing.T, open Opener) {
+    tests := []struct {
+        name string
+        run  func(t *testing.T, s store.DeviceStore)
+    }{
+        {"Lookup", testLookup},
+        {"ImportDevices", testImportDevices},
+        {"ListDevices", testListDevices},
+        {"AllocateUUID", testAllocateUUID},
+        {"RegistryChanges", testRegistryChanges},
+        {"Calibration", testCalibration},
+        {"Sessions", testSessions},
+        {"ListSessions", testListSessions},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tt.run(t, open(t))
+        })
+    }
+}
+
+// Import n devices named "sensor <i>", failing the test on error
+func importDevices(t *testing.T, s store.DeviceStore, n int) {
+    t.Helper()
+    var devices []store.Device
+    for i := 0; i < n; i++ {
+        devices = append(devices, store.Device{UUID: UUID(i), Name: fmt.Sprintf("sensor %d", i)})
+    }
+    if _, err := s.ImportDevices(devices); err != nil {
+        t.Fatalf("import: %v", err)
+    }
+}
+
+func mustDevice(t *testing.T, s store.DeviceStore, uuid string) store.Device {
+    t.Helper()
+    device, err := s.Device(uuid)
+    if err != nil {
+        t.Fatalf("Device(%s): %v", uuid, err)
+    }
+    return device
+}
+
+func testLookup(t *testing.T, s store.DeviceStore) {
+    if _, err := s.ImportDevices([]store.Device{
+        {UUID: UUID(1), Name: "sensor"},
+        {UUID: UUID(2), Name: "beacon", BeaconID: "iBeacon:FDA50693-A4E2-4FB1-AFCF-C6EB07647825:1:02"},
+    }); err != nil {
+        t.Fatal(err)
+    }
+
+    device := mustDevice(t, s, UUID(1))
+    if device.UUID != UUID(1) || device.Name != "sensor" || device.Active || device.Revoked() || device.Calibrated() {
+        t.Errorf("imported device: %+v", device)
+    }
+    if _, err := s.Device(UUID(9)); !errors.Is(err, store.ErrNotFound) {
+        t.Errorf("Device of an unregistered UUID: %v, want ErrNotFound", err)
+    }
+
+    for uuid, want := range map[string]bool{UUID(1): false, UUID(9): false} {
+        if active, err := s.IsActive(uuid); err != nil || active != want {
+            t.Errorf("IsActive(%s) = %v, %v; want %v", uuid, active, err, want)
+        }
+    }
+
+    if uuid, err := s.FindByBeacon("ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:2"); err != nil || uuid != UUID(2) {
+        t.Errorf("FindByBeacon = %q, %v; want %s", uuid, err, UUID(2))
+    }
+    if uuid, err := s.FindByBeacon("ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:3"); err != nil || uuid != "" {
+        t.Errorf("FindByBeacon of an unregistered beacon = %q, %v", uuid, err)
+    }
+
+    // Beacon IDs are stored in the form the scanner looks them up by
+    if _, err := s.ImportDevices([]store.Device{{UUID: UUID(3), Name: "tag", BeaconID: " Eddystone:EDD1EBEAC04E5DEFA017:0000000000AB "}}); err != nil {
+        t.Fatal(err)
+    }
+    if device := mustDevice(t, s, UUID(3)); device.BeaconID != "eddystone:edd1ebeac04e5defa017:0000000000ab" {
+        t.Errorf("stored beacon ID %q", device.BeaconID)
+    }
+    if uuid, err := s.FindByBeacon("eddystone:edd1ebeac04e5defa017:0000000000ab"); err != nil || uuid != UUID(3) {
+        t.Errorf("FindByBeacon of an imported Eddystone beacon = %q, %v; want %s", uuid, err, UUID(3))
+    }
+}
+
+func testImportDevices(t *testing.T, s store.DeviceStore) {
+    tests := []struct {
+        name    string
+        devices []store.Device
+        err     error // nil: any error
+    }{
+        {"invalid UUID", []store.Device{{UUID: "not-a-uuid", Name: "a"}}, store.ErrInvalidUUID},
+        {"missing name", []store.Device{{UUID: UUID(1), Name: " "}}, store.ErrMissingName},
+        {"duplicate entry", []store.Device{{UUID: UUID(1), Name: "a"}, {UUID: UUID(1), Name: "b"}}, store.ErrExists},
+        {"invalid beacon", []store.Device{{UUID: UUID(1), Name: "a", BeaconID: "ibeacon:1:2"}}, store.ErrInvalidBeacon},
+        {"duplicate beacon", []store.Device{
+            {UUID: UUID(1), Name: "a", BeaconID: "ibeacon:fda50693a4e24fb1afcfc6eb07647825:1:2"},
+            {UUID: UUID(2), Name: "b", BeaconID: "IBEACON:FDA50693-A4E2-4FB1-AFCF-C6EB07647825:01:02"},
+        }, nil},
+    }
+    for _, tt := range tests {
+        n, err := s.ImportDevices(tt.devices)
+        if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+            t.Errorf("%s: imported %d, error %v, want %v", tt.name, n, err, tt.err)
+        }
+    }
+    if devices, err := s.ListDevices(store.DeviceFilter{}); err != nil || len(devices) != 0 {
+        t.Fatalf("failed imports left %d devices (%v)", len(devices), err)
+    }
+
+    // UUIDs are stored in lower case
+    upper := "00000000-0000-4000-8000-00000000000A"
+    if n, err := s.ImportDevices([]store.Device{{UUID: upper, Name: "a"}, {UUID: UUID(1), Name: "b"}}); err != nil || n != 2 {
+        t.Fatalf("import = %d, %v", n, err)
+    }
+    mustDevice(t, s, "00000000-0000-4000-8000-00000000000a")
+
+    // An import with one registered UUID adds nothing
+    if _, err := s.ImportDevices([]store.Device{{UUID: UUID(2), Name: "c"}, {UUID: UUID(1), Name: "d"}}); !errors.Is(err, store.ErrExists) {
+        t.Errorf("import of a registered UUID: %v, want ErrExists", err)
+    }
+    if _, err := s.Device(UUID(2)); !errors.Is(err, store.ErrNotFound) {
+        t.Errorf("partial import added %s", UUID(2))
+    }
+}
+
+func testListDevices(t *testing.T, s store.DeviceStore) {
+    if _, err := s.ImportDevices([]store.Device{
+        {UUID: UUID(3), Name: "Library kiosk"},
+        {UUID: UUID(1), Name: "Lab sensor"},
+        {UUID: UUID(2), Name: "library desk"},
+        {UUID: UUID(4), Name: "spare"},
+    }); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Reassign(UUID(2), "library desk"); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.Revoke(UUID(4), "lost"); err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        filter store.DeviceFilter
+        want   []string
+    }{
+        {store.DeviceFilter{}, []string{UUID(1), UUID(2), UUID(3), UUID(4)}},
+        {store.DeviceFilter{State: store.FilterActive}, []string{UUID(2)}},
+        {store.DeviceFilter{State: store.FilterInactive}, []string{UUID(1), UUID(3)}},
+        {store.DeviceFilter{State: store.FilterRevoked}, []string{UUID(4)}},
+        {store.DeviceFilter{NameContains: "LIBRARY"}, []string{UUID(2), UUID(3)}},
+        {store.DeviceFilter{Limit: 2}, []string{UUID(1), UUID(2)}},
+    }
+    for _, tt := range tests {
+        devices, err := s.ListDevices(tt.filter)
+        if err != nil {
+            t.Errorf("ListDevices(%+v): %v", tt.filter, err)
+            continue
+        }
+        var got []string
+        for _, device := range devices {
+            got = append(got, device.UUID)
+        }
+        if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Errorf("ListDevices(%+v) = %v, want %v", tt.filter, got, tt.want)
+        }
+    }
+    if _, err := s.ListDevices(store.DeviceFilter{State: "bogus"}); err == nil {
+        t.Error("unknown filter accepted")
+    }
+}
+
+func testAllocateUUID(t *testing.T, s store.DeviceStore) {
+    importDevices(t, s, 3)
+    if err := s.Revoke(UUID(0), "stolen"); err != nil {
+        t.Fatal(err)
+    }
+
+    first, err := s.AllocateUUID("signup-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if first == UUID(0) {
+        t.Error("revoked UUID handed out")
+    }
+    if active, _ := s.IsActive(first); !active {
+        t.Errorf("allocated %s is not active", first)
+    }
+    if again, err := s.AllocateUUID("signup-1"); err != nil || again != first {
+        t.Errorf("retried signup got %q, %v; want %s", again, err, first)
+    }
+
+    second, err := s.AllocateUUID("")
+    if err != nil || second == first {
+        t.Fatalf("second allocation = %q, %v", second, err)
+    }
+    if _, err := s.AllocateUUID("signup-3"); !errors.Is(err, store.ErrExhausted) {
+        t.Errorf("allocation from an empty pool: %v, want ErrExhausted", err)
+    }
+
+    // A deactivated UUID goes back to the pool, and its old signup is forgotten
+    if err := s.Deactivate(first); err != nil {
+        t.Fatal(err)
+    }
+    if uuid, err := s.AllocateUUID("signup-4"); err != nil || uuid != first {
+        t.Errorf("allocation after deactivation = %q, %v; want %s", uuid, err, first)
+    }
+    if _, err := s.AllocateUUID("signup-1"); !errors.Is(err, store.ErrExhausted) {
+        t.Errorf("forgotten signup: %v, want ErrExhausted", err)
+    }
+}
+
+func testRegistryChanges(t *testing.T, s store.DeviceStore) {
+    importDevices(t, s, 2)
+
+    if err := s.Reassign(UUID(0), "new owner"); err != nil {
+        t.Fatal(err)
+    }
+    if device := mustDevice(t, s, UUID(0)); device.Name != "new owner" || !device.Active {
+        t.Errorf("reassigned device: %+v", device)
+    }
+    if err := s.Reassign(UUID(0), " "); !errors.Is(err, store.ErrMissingName) {
+        t.Errorf("reassign without a name: %v, want ErrMissingName", err)
+    }
+    if err := s.Deactivate(UUID(0)); err != nil {
+        t.Fatal(err)
+    }
+    if active, _ := s.IsActive(UUID(0)); active {
+        t.Error("deactivated device still active")
+    }
+
+    if err := s.Revoke(UUID(1), "stolen"); err != nil {
+        t.Fatal(err)
+    }
+    device := mustDevice(t, s, UUID(1))
+    if !device.Revoked() || device.RevokeReason != "stolen" || device.Active {
+        t.Errorf("revoked device: %+v", device)
+    }
+    for name, change := range map[string]func() error{
+        "Deactivate": func() error { return s.Deactivate(UUID(1)) },
+        "Revoke":     func() error { return s.Revoke(UUID(1), "again") },
+        "Reassign":   func() error { return s.Reassign(UUID(1), "thief") },
+    } {
+        if err := change(); !errors.Is(err, store.ErrRevoked) {
+            t.Errorf("%s of a revoked UUID: %v, want ErrRevoked", name, err)
+        }
+    }
+
+    // UUIDs are matched in any case; unknown and malformed ones are rejected
+    if err := s.Deactivate("00000000-0000-4000-8000-00000000000A"); !errors.Is(err, store.ErrNotFound) {
+        t.Errorf("Deactivate of an unregistered UUID: %v, want ErrNotFound", err)
+    }
+    if err := s.Deactivate("bogus"); !errors.Is(err, store.ErrInvalidUUID) {
+        t.Errorf("Deactivate of a malformed UUID: %v, want ErrInvalidUUID", err)
+    }
+}
+
+func testCalibration(t *testing.T, s store.DeviceStore) {
+    importDevices(t, s, 1)
+
+    samples := []store.CalibrationSample{{Distance: 1, RSSI: -59}, {Distance: 2, RSSI: -65}}
+    if err := s.AddCalibrationSamples(UUID(0), samples[:1]); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.AddCalibrationSamples(UUID(0), samples[1:]); err != nil {
+        t.Fatal(err)
+    }
+    got, err := s.CalibrationSamples(UUID(0))
+    if err != nil || fmt.Sprint(got) != fmt.Sprint(samples) {
+        t.Errorf("CalibrationSamples = %v, %v; want %v", got, err, samples)
+    }
+
+    if err := s.SetCalibration(UUID(0), -59, 2.2); err != nil {
+        t.Fatal(err)
+    }
+    if device := mustDevice(t, s, UUID(0)); !device.Calibrated() || device.TxPower != -59 || device.PathLossExponent != 2.2 {
+        t.Errorf("calibrated device: %+v", device)
+    }
+    if err := s.SetCalibration(UUID(9), -59, 2); !errors.Is(err, store.ErrNotFound) {
+        t.Errorf("SetCalibration of an unregistered UUID: %v, want ErrNotFound", err)
+    }
+}
+
+var start = time.Date(2024, 11, 10, 14, 0, 0, 0, time.UTC)
+
+func mustStart(t *testing.T, s store.DeviceStore, session store.Session) int64 {
+    t.Helper()
+    id, err := s.StartSession(session)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return id
+}
+
+func testSessions(t *testing.T, s store.DeviceStore) {
+    first := mustStart(t, s, store.Session{UUID: UUID(1), Address: "AA:BB:CC:DD:EE:01", Gateway: "gw-1", StartedAt: start, PeakRSSI: -70})
+    second := mustStart(t, s, store.Session{UUID: UUID(2), Address: "AA:BB:CC:DD:EE:02", Gateway: "gw-1", StartedAt: start.Add(time.Minute), PeakRSSI: -75})
+    mustStart(t, s, store.Session{UUID: UUID(3), Address: "AA:BB:CC:DD:EE:03", Gateway: "gw-2", StartedAt: start, PeakRSSI: -80})
+
+    open, err := s.OpenSessions("gw-1")
+    if err != nil || len(open) != 2 || open[0].ID != first || open[1].ID != second {
+        t.Fatalf("OpenSessions = %+v, %v; want sessions %d and %d", open, err, first, second)
+    }
+    if !open[0].LastSeen.Equal(start) {
+        t.Errorf("new session last seen %v, want its start %v", open[0].LastSeen, start)
+    }
+
+    seen := start.Add(10 * time.Minute)
+    if err := s.MarkSeen(map[int64]time.Time{first: seen}); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.EndSession(first, start.Add(15*time.Minute), "timeout", -60); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.MarkSeen(map[int64]time.Time{first: start.Add(time.Hour)}); err != nil {
+        t.Fatal(err)
+    }
+    if err := s.EndSession(first+second+100, start, "timeout", -60); err == nil {
+        t.Error("ending an unknown session succeeded")
+    }
+
+    open, _ = s.OpenSessions("gw-1")
+    if len(open) != 1 || open[0].ID != second {
+        t.Errorf("open after logout: %+v", open)
+    }
+    sessions, err := s.ListSessions(store.SessionFilter{UUID: UUID(1)})
+    if err != nil || len(sessions) != 1 {
+        t.Fatalf("ListSessions = %+v, %v", sessions, err)
+    }
+    ended := sessions[0]
+    if ended.Open() || !ended.EndedAt.Equal(start.Add(15*time.Minute)) || ended.EndReason != "timeout" || ended.PeakRSSI != -60 {
+        t.Errorf("ended session: %+v", ended)
+    }
+    if !ended.LastSeen.Equal(seen) {
+        t.Errorf("ended session last seen %v, want %v", ended.LastSeen, seen)
+    }
+
+    // The peak only goes up
+    if err := s.EndSession(second, start.Add(20*time.Minute), "manual", -90); err != nil {
+        t.Fatal(err)
+    }
+    if sessions, _ := s.ListSessions(store.SessionFilter{UUID: UUID(2)}); len(sessions) != 1 || sessions[0].PeakRSSI != -75 {
+        t.Errorf("peak after a weaker logout: %+v", sessions)
+    }
+
+    // Pruning keeps open sessions and those that ended after the cutoff
+    pruned, err := s.PruneSessions(start.Add(18 * time.Minute))
+    if err != nil || pruned != 1 {
+        t.Errorf("PruneSessions = %d, %v; want 1", pruned, err)
+    }
+    if sessions, _ := s.ListSessions(store.SessionFilter{}); len(sessions) != 2 {
+        t.Errorf("%d sessions left after pruning, want 2", len(sessions))
+    }
+}
+
+func testListSessions(t *testing.T, s store.DeviceStore) {
+    // Stays of 10 minutes starting every 30 minutes; the last one is still open
+    var ids []int64
+    for i := 0; i < 4; i++ {
+        begin := start.Add(time.Duration(i) * 30 * time.Minute)
+        id := mustStart(t, s, store.Session{UUID: UUID(i % 2), Address: "AA:BB:CC:DD:EE:01", Gateway: "gw-1", StartedAt: begin, PeakRSSI: -70})
+        if i < 3 {
+            if err := s.EndSession(id, begin.Add(10*time.Minute), "signal", -70); err != nil {
+                t.Fatal(err)
+            }
+        }
+        ids = append(ids, id)
+    }
+
+    tests := []struct {
+        name   string
+        filter store.SessionFilter
+        want   []int64 // Latest first
+    }{
+        {"all", store.SessionFilter{}, []int64{ids[3], ids[2], ids[1], ids[0]}},
+        {"by UUID", store.SessionFilter{UUID: UUID(1)}, []int64{ids[3], ids[1]}},
+        {"limit", store.SessionFilter{Limit: 2}, []int64{ids[3], ids[2]}},
+        {"at a moment", store.SessionFilter{From: start.Add(35 * time.Minute), To: start.Add(35 * time.Minute)}, []int64{ids[1]}},
+        {"between stays", store.SessionFilter{From: start.Add(15 * time.Minute), To: start.Add(20 * time.Minute)}, nil},
+        {"overlapping range", store.SessionFilter{From: start.Add(5 * time.Minute), To: start.Add(30 * time.Minute)}, []int64{ids[1], ids[0]}},
+        {"open session", store.SessionFilter{From: start.Add(5 * time.Hour)}, []int64{ids[3]}},
+    }
+    for _, tt := range tests {
+        sessions, err := s.ListSessions(tt.filter)
+        if err != nil {
+            t.Errorf("%s: %v", tt.name, err)
+            continue
+        }
+        var got []int64
+        for _, session := range sessions {
+            got = append(got, session.ID)
+        }
+        if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Errorf("%s: sessions %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
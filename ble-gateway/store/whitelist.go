@@ -0,0 +1,275 @@
+package store
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "sync"
+    "sync/atomic"
+)
+
+// Whitelist is a DeviceStore that answers the lookups of the scan path
+// (IsActive, FindByBeacon and Device) from an in-memory copy of the registry,
+// so the scan callback never waits on the database. Changes made through it
+// update the copy; changes made elsewhere (another process, a server push)
+// are picked up by Reload.
+type Whitelist struct {
+    DeviceStore // Backing store, used for everything but the lookups
+
+    reloadMu sync.Mutex // Serializes Reload
+
+    mu      sync.RWMutex
+    devices map[string]Device // UUID -> registered device
+    beacons map[string]string // Beacon ID -> UUID
+    changed map[string]bool   // UUIDs written through during a Reload, nil otherwise
+    hits    atomic.Uint64
+    misses  atomic.Uint64
+}
+
+// WhitelistStats counts IsActive lookups since the whitelist was created
+type WhitelistStats struct {
+    Size   int    // Active UUIDs in the set
+    Hits   uint64 // Lookups of an active UUID
+    Misses uint64 // Lookups of an inactive, revoked or unregistered UUID
+}
+
+// NewWhitelist loads the registry of backing into memory
+func NewWhitelist(backing DeviceStore) (*Whitelist, error) {
+    w := &Whitelist{DeviceStore: backing}
+    if err := w.Reload(); err != nil {
+        return nil, err
+    }
+    return w, nil
+}
+
+// Reload replaces the copy with the registry of the backing store. Devices
+// written through while it is loaded keep the state they were written with,
+// which is at least as new as what the load read.
+func (w *Whitelist) Reload() error {
+    w.reloadMu.Lock()
+    defer w.reloadMu.Unlock()
+
+    w.mu.Lock()
+    w.changed = make(map[string]bool)
+    w.mu.Unlock()
+
+    devices, err := w.load()
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if err != nil {
+        w.changed = nil
+        return err
+    }
+    for uuid := range w.changed {
+        if device, found := w.devices[uuid]; found {
+            devices[uuid] = device
+        } else {
+            delete(devices, uuid)
+        }
+    }
+    w.changed = nil
+    w.devices = devices
+    w.beacons = make(map[string]string)
+    for _, device := range devices {
+        if device.BeaconID != "" {
+            w.beacons[device.BeaconID] = device.UUID
+        }
+    }
+    return nil
+}
+
+// Check compares the copy with the backing store and returns the UUIDs that
+// are active there but not in the copy (missing), those active only in the
+// copy (extra), and registered devices whose other fields differ (stale)
+func (w *Whitelist) Check() (missing []string, extra []string, stale []string, err error) {
+    devices, err := w.load()
+    if err != nil {
+        return nil, nil, nil, err
+    }
+
+    w.mu.RLock()
+    for uuid, device := range devices {
+        cached, found := w.devices[uuid]
+        switch {
+        case device.Active && !cached.Active:
+            missing = append(missing, uuid)
+        case !device.Active && cached.Active:
+            extra = append(extra, uuid)
+        case !found || !sameDevice(device, cached):
+            stale = append(stale, uuid)
+        }
+    }
+    for uuid, cached := range w.devices {
+        if _, found := devices[uuid]; !found {
+            if cached.Active {
+                extra = append(extra, uuid)
+            } else {
+                stale = append(stale, uuid)
+            }
+        }
+    }
+    w.mu.RUnlock()
+
+    sort.Strings(missing)
+    sort.Strings(extra)
+    sort.Strings(stale)
+    return missing, extra, stale, nil
+}
+
+// Stats returns the number of active UUIDs and the lookup counters
+func (w *Whitelist) Stats() WhitelistStats {
+    w.mu.RLock()
+    size := 0
+    for _, device := range w.devices {
+        if device.Active {
+            size++
+        }
+    }
+    w.mu.RUnlock()
+    return WhitelistStats{Size: size, Hits: w.hits.Load(), Misses: w.misses.Load()}
+}
+
+// IsActive reports whether uuid is active, without touching the backing store
+func (w *Whitelist) IsActive(uuid string) (bool, error) {
+    w.mu.RLock()
+    isActive := w.devices[uuid].Active
+    w.mu.RUnlock()
+
+    if isActive {
+        w.hits.Add(1)
+    } else {
+        w.misses.Add(1)
+    }
+    return isActive, nil
+}
+
+// FindByBeacon returns the UUID registered for a beacon, without touching the backing store
+func (w *Whitelist) FindByBeacon(beaconID string) (string, error) {
+    w.mu.RLock()
+    defer w.mu.RUnlock()
+    return w.beacons[beaconID], nil
+}
+
+// Device returns a registered device, without touching the backing store
+func (w *Whitelist) Device(uuid string) (Device, error) {
+    w.mu.RLock()
+    defer w.mu.RUnlock()
+    device, found := w.devices[uuid]
+    if !found {
+        return Device{}, fmt.Errorf("%w: %s", ErrNotFound, uuid)
+    }
+    return device, nil
+}
+
+func (w *Whitelist) ImportDevices(devices []Device) (int, error) {
+    imported, err := w.DeviceStore.ImportDevices(devices)
+    if err == nil {
+        // The backing store has normalized the UUIDs in place
+        for _, device := range devices {
+            w.refresh(device.UUID, nil)
+        }
+    }
+    return imported, err
+}
+
+func (w *Whitelist) AllocateUUID(requestKey string) (string, error) {
+    uuid, err := w.DeviceStore.AllocateUUID(requestKey)
+    if err == nil {
+        w.refresh(uuid, func(device *Device) { device.Active = true })
+    }
+    return uuid, err
+}
+
+func (w *Whitelist) Deactivate(uuid string) error {
+    err := w.DeviceStore.Deactivate(uuid)
+    if err == nil {
+        w.refresh(uuid, func(device *Device) { device.Active = false })
+    }
+    return err
+}
+
+func (w *Whitelist) Revoke(uuid string, reason string) error {
+    err := w.DeviceStore.Revoke(uuid, reason)
+    if err == nil {
+        w.refresh(uuid, func(device *Device) { device.Active = false })
+    }
+    return err
+}
+
+func (w *Whitelist) Reassign(uuid string, name string) error {
+    err := w.DeviceStore.Reassign(uuid, name)
+    if err == nil {
+        w.refresh(uuid, func(device *Device) { device.Active = true })
+    }
+    return err
+}
+
+func (w *Whitelist) SetCalibration(uuid string, txPower float64, exponent float64) error {
+    err := w.DeviceStore.SetCalibration(uuid, txPower, exponent)
+    if err == nil {
+        w.refresh(uuid, nil)
+    }
+    return err
+}
+
+// Copy one device from the backing store after a change made through the
+// whitelist. If it cannot be read, fallback applies the change to the old copy
+// and the next Check finds any other difference. Updates use the normalized
+// UUID, as the backing store does.
+func (w *Whitelist) refresh(uuid string, fallback func(device *Device)) {
+    if normalized, err := NormalizeUUID(uuid); err == nil {
+        uuid = normalized
+    }
+    device, err := w.DeviceStore.Device(uuid)
+
+    w.mu.Lock()
+    defer w.mu.Unlock()
+    if w.changed != nil {
+        w.changed[uuid] = true
+    }
+    if err != nil && !errors.Is(err, ErrNotFound) {
+        if fallback != nil {
+            old, found := w.devices[uuid]
+            if !found {
+                old = Device{UUID: uuid}
+            }
+            fallback(&old)
+            w.devices[uuid] = old
+        }
+        return
+    }
+    if old, found := w.devices[uuid]; found && old.BeaconID != "" {
+        delete(w.beacons, old.BeaconID)
+    }
+    if err != nil {
+        delete(w.devices, uuid)
+    } else {
+        w.devices[uuid] = device
+        if device.BeaconID != "" {
+            w.beacons[device.BeaconID] = uuid
+        }
+    }
+}
+
+// Read every registered device of the backing store
+func (w *Whitelist) load() (map[string]Device, error) {
+    devices, err := w.DeviceStore.ListDevices(DeviceFilter{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to load whitelist: %v", err)
+    }
+    registered := make(map[string]Device, len(devices))
+    for _, device := range devices {
+        registered[device.UUID] = device
+    }
+    return registered, nil
+}
+
+// Whether two copies of a device agree, comparing times by instant
+func sameDevice(a Device, b Device) bool {
+    if !a.RevokedAt.Equal(b.RevokedAt) {
+        return false
+    }
+    a.RevokedAt = b.RevokedAt
+    return a == b
+}
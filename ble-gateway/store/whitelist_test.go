@@ -0,0 +1,196 @@
+package store_test
+
+import (
+    "reflect"
+    "sync/atomic"
+    "testing"
+    "ble-gateway/store"
+    "ble-gateway/store/storetest"
+)
+
+// countingStore counts the lookups that reach the backing store and can run
+// a change right after ListDevices has read the registry
+type countingStore struct {
+    store.DeviceStore
+    lookups   atomic.Int64
+    afterList func()
+}
+
+func (s *countingStore) Device(uuid string) (store.Device, error) {
+    s.lookups.Add(1)
+    return s.DeviceStore.Device(uuid)
+}
+
+func (s *countingStore) IsActive(uuid string) (bool, error) {
+    s.lookups.Add(1)
+    return s.DeviceStore.IsActive(uuid)
+}
+
+func (s *countingStore) FindByBeacon(beaconID string) (string, error) {
+    s.lookups.Add(1)
+    return s.DeviceStore.FindByBeacon(beaconID)
+}
+
+func (s *countingStore) ListDevices(filter store.DeviceFilter) ([]store.Device, error) {
+    devices, err := s.DeviceStore.ListDevices(filter)
+    if s.afterList != nil {
+        afterList := s.afterList
+        s.afterList = nil
+        afterList()
+    }
+    return devices, err
+}
+
+const beaconID = "ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:2"
+
+// A whitelist over a memory store with an active, an inactive and a calibrated beacon device
+func newTestWhitelist(t *testing.T) (*store.Whitelist, *countingStore) {
+    t.Helper()
+    backing := &countingStore{DeviceStore: store.NewMemoryStore(
+        store.Device{UUID: storetest.UUID(0), Name: "active", Active: true},
+        store.Device{UUID: storetest.UUID(1), Name: "inactive"},
+        store.Device{UUID: storetest.UUID(2), Name: "beacon", Active: true, BeaconID: beaconID, TxPower: -59, PathLossExponent: 2},
+    )}
+    w, err := store.NewWhitelist(backing)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return w, backing
+}
+
+func TestWhitelistConformance(t *testing.T) {
+    storetest.Run(t, func(t *testing.T) store.DeviceStore {
+        w, err := store.NewWhitelist(store.NewMemoryStore())
+        if err != nil {
+            t.Fatal(err)
+        }
+        return w
+    })
+}
+
+func TestWhitelistLookupsStayInMemory(t *testing.T) {
+    w, backing := newTestWhitelist(t)
+
+    for uuid, want := range map[string]bool{storetest.UUID(0): true, storetest.UUID(1): false, storetest.UUID(2): true, storetest.UUID(9): false} {
+        if active, err := w.IsActive(uuid); err != nil || active != want {
+            t.Errorf("IsActive(%s) = %v, %v; want %v", uuid, active, err, want)
+        }
+    }
+    if uuid, err := w.FindByBeacon(beaconID); err != nil || uuid != storetest.UUID(2) {
+        t.Errorf("FindByBeacon = %q, %v", uuid, err)
+    }
+    if device, err := w.Device(storetest.UUID(2)); err != nil || !device.Calibrated() {
+        t.Errorf("Device = %+v, %v; want the calibrated device", device, err)
+    }
+    if _, err := w.Device(storetest.UUID(9)); err == nil {
+        t.Error("Device of an unregistered UUID succeeded")
+    }
+
+    if n := backing.lookups.Load(); n != 0 {
+        t.Errorf("%d lookups reached the backing store", n)
+    }
+    if stats := w.Stats(); stats != (store.WhitelistStats{Size: 2, Hits: 2, Misses: 2}) {
+        t.Errorf("stats %+v, want 2 active, 2 hits and 2 misses", stats)
+    }
+}
+
+func TestWhitelistWritesThrough(t *testing.T) {
+    w, _ := newTestWhitelist(t)
+    other := "ibeacon:fda50693-a4e2-4fb1-afcf-c6eb07647825:1:3"
+
+    tests := []struct {
+        name   string
+        change func() error
+        uuid   string
+        active bool
+    }{
+        {"allocate", func() error { _, err := w.AllocateUUID("signup"); return err }, storetest.UUID(1), true},
+        {"deactivate", func() error { return w.Deactivate(storetest.UUID(0)) }, storetest.UUID(0), false},
+        {"reassign", func() error { return w.Reassign(storetest.UUID(0), "new owner") }, storetest.UUID(0), true},
+        {"revoke", func() error { return w.Revoke(storetest.UUID(2), "lost") }, storetest.UUID(2), false},
+        {"import", func() error {
+            _, err := w.ImportDevices([]store.Device{{UUID: storetest.UUID(3), Name: "tag", BeaconID: other}})
+            return err
+        }, storetest.UUID(3), false},
+        {"calibrate", func() error { return w.SetCalibration(storetest.UUID(3), -60, 2.5) }, storetest.UUID(3), false},
+    }
+    for _, tt := range tests {
+        if err := tt.change(); err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        if active, _ := w.IsActive(tt.uuid); active != tt.active {
+            t.Errorf("%s: %s active = %v, want %v", tt.name, tt.uuid, active, tt.active)
+        }
+    }
+
+    if uuid, _ := w.FindByBeacon(other); uuid != storetest.UUID(3) {
+        t.Errorf("imported beacon resolves to %q", uuid)
+    }
+    if device, _ := w.Device(storetest.UUID(3)); device.PathLossExponent != 2.5 {
+        t.Errorf("calibration not written through: %+v", device)
+    }
+    if device, _ := w.Device(storetest.UUID(2)); !device.Revoked() {
+        t.Errorf("revocation not written through: %+v", device)
+    }
+    if missing, extra, stale, err := w.Check(); err != nil || len(missing)+len(extra)+len(stale) != 0 {
+        t.Errorf("Check after write-through = %v, %v, %v, %v", missing, extra, stale, err)
+    }
+}
+
+func TestWhitelistCheckAndReload(t *testing.T) {
+    w, backing := newTestWhitelist(t)
+
+    // Changes made behind the whitelist, as by another process
+    if _, err := backing.AllocateUUID(""); err != nil {
+        t.Fatal(err)
+    }
+    if err := backing.Deactivate(storetest.UUID(0)); err != nil {
+        t.Fatal(err)
+    }
+    if err := backing.SetCalibration(storetest.UUID(2), -65, 3); err != nil {
+        t.Fatal(err)
+    }
+
+    missing, extra, stale, err := w.Check()
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := [][]string{{storetest.UUID(1)}, {storetest.UUID(0)}, {storetest.UUID(2)}}
+    if got := [][]string{missing, extra, stale}; !reflect.DeepEqual(got, want) {
+        t.Errorf("Check = missing %v, extra %v, stale %v; want %v", missing, extra, stale, want)
+    }
+
+    if err := w.Reload(); err != nil {
+        t.Fatal(err)
+    }
+    if missing, extra, stale, _ := w.Check(); len(missing)+len(extra)+len(stale) != 0 {
+        t.Errorf("Check after Reload = %v, %v, %v", missing, extra, stale)
+    }
+    if active, _ := w.IsActive(storetest.UUID(1)); !active {
+        t.Error("UUID activated behind the whitelist is still inactive after Reload")
+    }
+}
+
+func TestWhitelistReloadKeepsConcurrentWrites(t *testing.T) {
+    w, backing := newTestWhitelist(t)
+
+    // Both changes commit after Reload has read the registry and before it swaps the copy in
+    backing.afterList = func() {
+        if _, err := w.AllocateUUID("signup"); err != nil {
+            t.Error(err)
+        }
+        if err := w.Deactivate(storetest.UUID(0)); err != nil {
+            t.Error(err)
+        }
+    }
+    if err := w.Reload(); err != nil {
+        t.Fatal(err)
+    }
+
+    if active, _ := w.IsActive(storetest.UUID(0)); active {
+        t.Error("deactivation made during Reload was lost")
+    }
+    if active, _ := w.IsActive(storetest.UUID(1)); !active {
+        t.Error("allocation made during Reload was lost")
+    }
+}